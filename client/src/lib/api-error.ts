@@ -52,7 +52,24 @@ export async function parseApiError(
     return { fieldErrors: {}, formError: raw || fallback.formError };
   }
 
-  // 422 from validator
+  // application/problem+json (RFC 9457) from backend
+  if (typeof data.status === "number" && typeof data.title === "string") {
+    const fieldErrors: FieldErrors = {};
+    if (Array.isArray(data.invalid_params)) {
+      for (const p of data.invalid_params) {
+        if (p && typeof p.name === "string" && !(p.name in fieldErrors)) {
+          fieldErrors[p.name] = String(p.reason ?? "");
+        }
+      }
+    }
+    const hasFieldErrors = Object.keys(fieldErrors).length > 0;
+    return {
+      fieldErrors,
+      formError: hasFieldErrors ? null : data.detail ?? data.title,
+    };
+  }
+
+  // 422 from validator (legacy error format)
   if (data.FieldErrors || data.Errors) {
     return {
       fieldErrors: (data.FieldErrors ?? {}) as FieldErrors,
@@ -63,7 +80,7 @@ export async function parseApiError(
     };
   }
 
-  // 400/404/405/500/503 message shape from backend
+  // 400/404/405/500/503 message shape from backend (legacy error format)
   if (typeof data.Error === "string") {
     return { fieldErrors: {}, formError: data.Error };
   }
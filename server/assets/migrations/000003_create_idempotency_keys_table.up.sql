@@ -0,0 +1,13 @@
+CREATE TABLE idempotency_keys (
+    idempotency_key TEXT NOT NULL,
+    principal TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    response_status INTEGER,
+    response_headers JSONB,
+    response_body BYTEA,
+    date_created TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (idempotency_key, principal)
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS short_links;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE short_links (
+    code TEXT PRIMARY KEY,
+    url TEXT NOT NULL,
+    alias BOOLEAN NOT NULL,
+    hits BIGINT NOT NULL DEFAULT 0,
+    date_created TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP
+);
+
+-- Generated links that never expire are shared by every request for the same
+-- canonical URL.
+CREATE UNIQUE INDEX short_links_url_idx ON short_links (url) WHERE NOT alias AND expires_at IS NULL;
@@ -0,0 +1,20 @@
+CREATE TABLE url_rules (
+    id UUID PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('host', 'query', 'path')),
+    host TEXT NOT NULL,
+    target_host TEXT NOT NULL DEFAULT '',
+    query_allow TEXT[] NOT NULL DEFAULT '{}',
+    query_deny TEXT[] NOT NULL DEFAULT '{}',
+    query_drop_all BOOLEAN NOT NULL DEFAULT FALSE,
+    pattern TEXT NOT NULL DEFAULT '',
+    replacement TEXT NOT NULL DEFAULT '',
+    priority INTEGER NOT NULL DEFAULT 0,
+    description TEXT NOT NULL DEFAULT '',
+    date_created TIMESTAMP NOT NULL,
+    date_updated TIMESTAMP NOT NULL,
+    version INTEGER NOT NULL
+);
+
+-- A host has at most one mapping and one query policy; path rewrites may
+-- share hosts.
+CREATE UNIQUE INDEX url_rules_host_idx ON url_rules (kind, host) WHERE kind IN ('host', 'query');
@@ -5,11 +5,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/Babatunde50/book-crud/server/internal/tracing"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/Babatunde50/book-crud/server/business/book")
+
 // Set of error variables for CRUD operations.
 var (
 	ErrNotFound      = errors.New("book not found")
@@ -27,18 +35,26 @@ type Storer interface {
 
 // Core manages the set of APIs for book access.
 type Core struct {
+	log    *slog.Logger
+	tran   database.Transactor
 	storer Storer
 }
 
-// NewCore constructs a core for book API access.
-func NewCore(storer Storer) *Core {
+// NewCore constructs a core for book API access. Operations that need several
+// store calls to happen atomically run them through tran.
+func NewCore(log *slog.Logger, tran database.Transactor, storer Storer) *Core {
 	return &Core{
+		log:    log,
+		tran:   tran,
 		storer: storer,
 	}
 }
 
 // Create adds a new book to the system.
-func (c *Core) Create(ctx context.Context, nb NewBook) (Book, error) {
+func (c *Core) Create(ctx context.Context, nb NewBook) (_ Book, err error) {
+	ctx, span := tracer.Start(ctx, "book.Create")
+	defer func() { tracing.End(span, err) }()
+
 	now := time.Now()
 
 	book := Book{
@@ -55,11 +71,17 @@ func (c *Core) Create(ctx context.Context, nb NewBook) (Book, error) {
 		return Book{}, fmt.Errorf("create: %w", err)
 	}
 
+	span.SetAttributes(bookIDAttr(book.ID))
+	c.log.InfoContext(ctx, "book created", "book_id", book.ID)
+
 	return book, nil
 }
 
 // Update modifies information about a book.
-func (c *Core) Update(ctx context.Context, book Book, ub UpdateBook) (Book, error) {
+func (c *Core) Update(ctx context.Context, book Book, ub UpdateBook) (_ Book, err error) {
+	ctx, span := tracer.Start(ctx, "book.Update", trace.WithAttributes(bookIDAttr(book.ID)))
+	defer func() { tracing.End(span, err) }()
+
 	if ub.Title != nil {
 		book.Title = *ub.Title
 	}
@@ -79,19 +101,54 @@ func (c *Core) Update(ctx context.Context, book Book, ub UpdateBook) (Book, erro
 		return Book{}, fmt.Errorf("update: %w", err)
 	}
 
+	c.log.InfoContext(ctx, "book updated", "book_id", book.ID, "version", book.Version)
+
 	return book, nil
 }
 
+// UpdateByID reads a book and applies ub to it in a single transaction, so the
+// update is always based on the latest version of the book. If the book is
+// changed concurrently the transaction is retried against the new version.
+func (c *Core) UpdateByID(ctx context.Context, bookID uuid.UUID, ub UpdateBook) (_ Book, err error) {
+	ctx, span := tracer.Start(ctx, "book.UpdateByID", trace.WithAttributes(bookIDAttr(bookID)))
+	defer func() { tracing.End(span, err) }()
+
+	var updated Book
+
+	err = c.tran.WithinTran(ctx, func(ctx context.Context) error {
+		book, err := c.QueryByID(ctx, bookID)
+		if err != nil {
+			return err
+		}
+
+		updated, err = c.Update(ctx, book, ub)
+		return err
+	})
+	if err != nil {
+		return Book{}, err
+	}
+
+	return updated, nil
+}
+
 // Delete removes a book from the system.
-func (c *Core) Delete(ctx context.Context, bookID uuid.UUID) error {
+func (c *Core) Delete(ctx context.Context, bookID uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "book.Delete", trace.WithAttributes(bookIDAttr(bookID)))
+	defer func() { tracing.End(span, err) }()
+
 	if err := c.storer.Delete(ctx, bookID); err != nil {
 		return fmt.Errorf("delete: %w", err)
 	}
+
+	c.log.InfoContext(ctx, "book deleted", "book_id", bookID)
 	return nil
 }
 
 // QueryByID finds a book by its ID.
-func (c *Core) QueryByID(ctx context.Context, bookID uuid.UUID) (Book, error) {
+func (c *Core) QueryByID(ctx context.Context, bookID uuid.UUID) (_ Book, err error) {
+	ctx, span := tracer.Start(ctx, "book.QueryByID", trace.WithAttributes(bookIDAttr(bookID)))
+	defer func() { tracing.End(span, err) }()
+
 	book, err := c.storer.QueryByID(ctx, bookID)
 	if err != nil {
 		return Book{}, fmt.Errorf("query: id[%s]: %w", bookID, err)
@@ -100,10 +157,17 @@ func (c *Core) QueryByID(ctx context.Context, bookID uuid.UUID) (Book, error) {
 }
 
 // QueryAll returns all books in the system.
-func (c *Core) QueryAll(ctx context.Context) ([]Book, error) {
+func (c *Core) QueryAll(ctx context.Context) (_ []Book, err error) {
+	ctx, span := tracer.Start(ctx, "book.QueryAll")
+	defer func() { tracing.End(span, err) }()
+
 	books, err := c.storer.QueryAll(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("query all: %w", err)
 	}
 	return books, nil
 }
+
+func bookIDAttr(bookID uuid.UUID) attribute.KeyValue {
+	return attribute.String("book.id", bookID.String())
+}
@@ -3,6 +3,8 @@ package book_test
 import (
 	"context"
 	"errors"
+	"io"
+	"log/slog"
 	"runtime/debug"
 	"testing"
 	"time"
@@ -11,6 +13,10 @@ import (
 	"github.com/Babatunde50/book-crud/server/business/book/bookdb"
 	"github.com/Babatunde50/book-crud/server/internal/dbtest"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_Book_CRUD(t *testing.T) {
@@ -32,8 +38,9 @@ func Test_Book_CRUD(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
 	store := bookdb.New(test.DB)
-	core := book.NewCore(store)
+	core := book.NewCore(log, test.DB, store)
 
 	// ---------------------------------------------------------------------
 
@@ -91,8 +98,45 @@ func Test_Book_CRUD(t *testing.T) {
 
 	// ---------------------------------------------------------------------
 
+	t.Log("\tWhen updating the book by ID in a transaction")
+	revisedYear := 2018
+	revisedBook, err := core.UpdateByID(ctx, createdBook.ID, book.UpdateBook{Year: &revisedYear})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to update book by ID: %s", err)
+	}
+
+	if revisedBook.Year != revisedYear || revisedBook.Title != updatedTitle || revisedBook.Version != updatedBook.Version+1 {
+		t.Errorf("\t\tShould apply the update to the latest version: got %+v", revisedBook)
+	}
+
+	if _, err := core.UpdateByID(ctx, uuid.New(), book.UpdateBook{Year: &revisedYear}); !errors.Is(err, book.ErrNotFound) {
+		t.Errorf("\t\tExpected ErrNotFound updating a missing book, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen a transaction fails")
+	errAbort := errors.New("abort")
+	var rolledBack book.Book
+	err = test.DB.WithinTran(ctx, func(ctx context.Context) error {
+		rolledBack, err = core.Create(ctx, book.NewBook{Title: "Rolled Back", Author: "Nobody", Year: 2000})
+		if err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("\t\tExpected the transaction's error, got %v", err)
+	}
+
+	if _, err := core.QueryByID(ctx, rolledBack.ID); !errors.Is(err, book.ErrNotFound) {
+		t.Errorf("\t\tShould not keep writes from a rolled back transaction, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
 	t.Log("\tWhen deleting the book")
-	err = core.Delete(ctx, updatedBook.ID)
+	err = core.Delete(ctx, revisedBook.ID)
 	if err != nil {
 		t.Fatalf("\t\tShould be able to delete book: %s", err)
 	}
@@ -117,3 +161,120 @@ func Test_Book_CRUD(t *testing.T) {
 		t.Errorf("\t\tExpected ErrNotFound, got %v", err)
 	}
 }
+
+// Test_Book_Tracing swaps the global tracer provider and must not run in parallel.
+func Test_Book_Tracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(prev)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	core := book.NewCore(log, noTran{}, newMemStore())
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+
+	t.Log("Given the need to trace book operations")
+
+	t.Log("\tWhen creating a book")
+	created, err := core.Create(ctx, book.NewBook{Title: "Go in Action", Author: "William Kennedy", Year: 2015})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create book: %s", err)
+	}
+
+	t.Log("\tWhen querying a missing book")
+	if _, err := core.QueryByID(ctx, uuid.New()); !errors.Is(err, book.ErrNotFound) {
+		t.Fatalf("\t\tExpected ErrNotFound, got %v", err)
+	}
+
+	parent.End()
+
+	spans := sr.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("\t\tGot %d spans, want 3", len(spans))
+	}
+
+	create, query := spans[0], spans[1]
+
+	if create.Name() != "book.Create" {
+		t.Errorf("\t\tGot span name %q, want %q", create.Name(), "book.Create")
+	}
+	if create.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("\t\tExpected book.Create to be a child of the caller's span")
+	}
+	if create.Status().Code == codes.Error {
+		t.Errorf("\t\tExpected book.Create not to be marked as failed")
+	}
+
+	var idAttr string
+	for _, kv := range create.Attributes() {
+		if kv.Key == "book.id" {
+			idAttr = kv.Value.AsString()
+		}
+	}
+	if idAttr != created.ID.String() {
+		t.Errorf("\t\tGot book.id %q, want %q", idAttr, created.ID)
+	}
+
+	if query.Name() != "book.QueryByID" {
+		t.Errorf("\t\tGot span name %q, want %q", query.Name(), "book.QueryByID")
+	}
+	if query.Status().Code != codes.Error {
+		t.Errorf("\t\tExpected book.QueryByID to be marked as failed")
+	}
+}
+
+// noTran runs units of work without a transaction, for memStore.
+type noTran struct{}
+
+func (noTran) WithinTran(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// memStore is an in-memory book.Storer for tests that don't need a database.
+type memStore struct {
+	books map[uuid.UUID]book.Book
+}
+
+func newMemStore() *memStore {
+	return &memStore{books: make(map[uuid.UUID]book.Book)}
+}
+
+func (s *memStore) Create(ctx context.Context, bk book.Book) error {
+	s.books[bk.ID] = bk
+	return nil
+}
+
+func (s *memStore) Update(ctx context.Context, bk book.Book) error {
+	if _, ok := s.books[bk.ID]; !ok {
+		return book.ErrNotFound
+	}
+	s.books[bk.ID] = bk
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, bookID uuid.UUID) error {
+	if _, ok := s.books[bookID]; !ok {
+		return book.ErrNotFound
+	}
+	delete(s.books, bookID)
+	return nil
+}
+
+func (s *memStore) QueryByID(ctx context.Context, bookID uuid.UUID) (book.Book, error) {
+	bk, ok := s.books[bookID]
+	if !ok {
+		return book.Book{}, book.ErrNotFound
+	}
+	return bk, nil
+}
+
+func (s *memStore) QueryAll(ctx context.Context) ([]book.Book, error) {
+	books := make([]book.Book, 0, len(s.books))
+	for _, bk := range s.books {
+		books = append(books, bk)
+	}
+	return books, nil
+}
@@ -21,7 +21,7 @@ func New(db *database.DB) *Store {
 
 // Create inserts a new book into the database.
 func (s *Store) Create(ctx context.Context, bk book.Book) error {
-	const query = `
+	const query = `-- name=bookdb.Create
 		INSERT INTO books (
 			id, title, author, year, date_created, date_updated, version
 		)
@@ -40,7 +40,7 @@ func (s *Store) Create(ctx context.Context, bk book.Book) error {
 
 // Update modifies an existing book record.
 func (s *Store) Update(ctx context.Context, bk book.Book) error {
-	const query = `
+	const query = `-- name=bookdb.Update
 		UPDATE books SET
 			title = :title,
 			author = :author,
@@ -70,7 +70,8 @@ func (s *Store) Update(ctx context.Context, bk book.Book) error {
 
 // Delete removes a book by its ID.
 func (s *Store) Delete(ctx context.Context, id uuid.UUID) error {
-	const query = `DELETE FROM books WHERE id = $1`
+	const query = `-- name=bookdb.Delete
+		DELETE FROM books WHERE id = $1`
 
 	result, err := s.db.ExecContext(ctx, query, id)
 	if err != nil {
@@ -89,12 +90,13 @@ func (s *Store) Delete(ctx context.Context, id uuid.UUID) error {
 	return nil
 }
 
-// QueryByID retrieves a book by its ID.
+// QueryByID retrieves a book by its ID, from a replica when one is available.
 func (s *Store) QueryByID(ctx context.Context, id uuid.UUID) (book.Book, error) {
-	const query = `SELECT * FROM books WHERE id = $1`
+	const query = `-- name=bookdb.QueryByID
+		SELECT * FROM books WHERE id = $1`
 
 	var dbBook dbBook
-	if err := s.db.GetContext(ctx, &dbBook, query, id); err != nil {
+	if err := s.db.Reader(ctx).GetContext(ctx, &dbBook, query, id); err != nil {
 
 		if errors.Is(err, sql.ErrNoRows) {
 			return book.Book{}, book.ErrNotFound
@@ -106,12 +108,13 @@ func (s *Store) QueryByID(ctx context.Context, id uuid.UUID) (book.Book, error)
 	return toCoreBook(dbBook), nil
 }
 
-// Query retrieves all books.
+// QueryAll retrieves all books, from a replica when one is available.
 func (s *Store) QueryAll(ctx context.Context) ([]book.Book, error) {
-	const query = `SELECT * FROM books ORDER BY date_created DESC`
+	const query = `-- name=bookdb.QueryAll
+		SELECT * FROM books ORDER BY date_created DESC`
 
 	var dbBooks []dbBook
-	if err := s.db.SelectContext(ctx, &dbBooks, query); err != nil {
+	if err := s.db.Reader(ctx).SelectContext(ctx, &dbBooks, query); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,122 @@
+// Package idempotency provides support for replaying the response of a
+// request that was retried with the same Idempotency-Key.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Set of error variables for idempotency key handling.
+var (
+	ErrNotFound            = errors.New("idempotency key not found")
+	ErrInProgress          = errors.New("a request with this idempotency key is still being processed")
+	ErrFingerprintMismatch = errors.New("idempotency key was already used for a different request")
+)
+
+// Storer defines the behavior the idempotency package expects from the data
+// store layer.
+type Storer interface {
+	Insert(ctx context.Context, rec Record) (bool, error)
+	Complete(ctx context.Context, key string, principal string, resp Response) error
+	Delete(ctx context.Context, key string, principal string) error
+	DeleteExpired(ctx context.Context, now time.Time) (int64, error)
+	QueryByKey(ctx context.Context, key string, principal string) (Record, error)
+}
+
+// Core manages the set of APIs for idempotency key access.
+type Core struct {
+	storer Storer
+	ttl    time.Duration
+}
+
+// NewCore constructs a core for idempotency key access. Stored responses are
+// replayed for ttl after the key was first used.
+func NewCore(storer Storer, ttl time.Duration) *Core {
+	return &Core{
+		storer: storer,
+		ttl:    ttl,
+	}
+}
+
+// Begin claims the key for the given principal. It returns a nil Response
+// when the caller should go on and process the request, and the stored
+// Response when the request is a replay of one that already completed.
+func (c *Core) Begin(ctx context.Context, key string, principal string, fingerprint string) (*Response, error) {
+	now := time.Now()
+
+	rec := Record{
+		Key:         key,
+		Principal:   principal,
+		Fingerprint: fingerprint,
+		DateCreated: now,
+		ExpiresAt:   now.Add(c.ttl),
+	}
+
+	// A second attempt is only needed when the existing record turned out to
+	// be expired or disappeared between the insert and the query.
+	for range 2 {
+		inserted, err := c.storer.Insert(ctx, rec)
+		if err != nil {
+			return nil, fmt.Errorf("begin: insert: %w", err)
+		}
+
+		if inserted {
+			return nil, nil
+		}
+
+		existing, err := c.storer.QueryByKey(ctx, key, principal)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, fmt.Errorf("begin: query: %w", err)
+		}
+
+		if !existing.ExpiresAt.After(now) {
+			if _, err := c.storer.DeleteExpired(ctx, now); err != nil {
+				return nil, fmt.Errorf("begin: delete expired: %w", err)
+			}
+			continue
+		}
+
+		switch {
+		case existing.Fingerprint != fingerprint:
+			return nil, ErrFingerprintMismatch
+		case existing.Response == nil:
+			return nil, ErrInProgress
+		}
+
+		return existing.Response, nil
+	}
+
+	return nil, ErrInProgress
+}
+
+// Complete stores the response for a key claimed with Begin.
+func (c *Core) Complete(ctx context.Context, key string, principal string, resp Response) error {
+	if err := c.storer.Complete(ctx, key, principal, resp); err != nil {
+		return fmt.Errorf("complete: %w", err)
+	}
+	return nil
+}
+
+// Release gives up a key claimed with Begin so the request can be retried,
+// for example after it failed with a server error.
+func (c *Core) Release(ctx context.Context, key string, principal string) error {
+	if err := c.storer.Delete(ctx, key, principal); err != nil {
+		return fmt.Errorf("release: %w", err)
+	}
+	return nil
+}
+
+// PurgeExpired removes every record whose TTL has elapsed.
+func (c *Core) PurgeExpired(ctx context.Context) (int64, error) {
+	n, err := c.storer.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("purge expired: %w", err)
+	}
+	return n, nil
+}
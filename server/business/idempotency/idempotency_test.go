@@ -0,0 +1,134 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/idempotency"
+	"github.com/Babatunde50/book-crud/server/business/idempotency/idempotencydb"
+	"github.com/Babatunde50/book-crud/server/internal/dbtest"
+)
+
+func Test_Idempotency(t *testing.T) {
+	c, err := dbtest.StartDB(t)
+	if err != nil {
+		t.Fatalf("starting test DB: %v", err)
+	}
+	defer dbtest.StopDB(c)
+
+	test := dbtest.NewTest(t, c)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Log(r)
+			t.Error(string(debug.Stack()))
+		}
+		test.Teardown()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Run as a deployment five hours behind UTC would, with a TTL shorter
+	// than the offset.
+	local := time.Local
+	time.Local = time.FixedZone("UTC-5", -5*60*60)
+	defer func() { time.Local = local }()
+
+	store := idempotencydb.New(test.DB)
+	core := idempotency.NewCore(store, time.Hour)
+
+	resp := idempotency.Response{
+		StatusCode: http.StatusCreated,
+		Header:     map[string][]string{"Content-Type": {"application/json"}},
+		Body:       []byte(`{"id":1}`),
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("Given the need to replay requests retried with the same key")
+
+	t.Log("\tWhen a request completes in a time zone behind UTC")
+	if replay, err := core.Begin(ctx, "key-1", "alice", "fp-1"); err != nil || replay != nil {
+		t.Fatalf("\t\tShould claim a new key: got %v, %v", replay, err)
+	}
+	if err := core.Complete(ctx, "key-1", "alice", resp); err != nil {
+		t.Fatalf("\t\tShould be able to complete the request: %s", err)
+	}
+
+	replay, err := core.Begin(ctx, "key-1", "alice", "fp-1")
+	if err != nil {
+		t.Fatalf("\t\tShould replay the stored response within the TTL: %s", err)
+	}
+	if replay == nil || replay.StatusCode != resp.StatusCode || string(replay.Body) != string(resp.Body) {
+		t.Errorf("\t\tShould replay the stored response: got %+v", replay)
+	}
+
+	if n, err := core.PurgeExpired(ctx); err != nil || n != 0 {
+		t.Errorf("\t\tShould not purge a record within its TTL: got %d, %v", n, err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen a record expired at a time given in another time zone")
+	tokyo := time.FixedZone("UTC+9", 9*60*60)
+	created := time.Now().Add(-2 * time.Hour).In(tokyo)
+	expired := idempotency.Record{
+		Key:         "key-2",
+		Principal:   "alice",
+		Fingerprint: "fp-old",
+		DateCreated: created,
+		ExpiresAt:   created.Add(time.Hour),
+	}
+	if _, err := store.Insert(ctx, expired); err != nil {
+		t.Fatalf("\t\tShould be able to insert a record: %s", err)
+	}
+
+	rec, err := store.QueryByKey(ctx, "key-2", "alice")
+	if err != nil {
+		t.Fatalf("\t\tShould be able to query the record: %s", err)
+	}
+	if !rec.ExpiresAt.Equal(expired.ExpiresAt.Truncate(time.Microsecond)) {
+		t.Errorf("\t\tShould keep the instant the record expires: got %s, want %s", rec.ExpiresAt, expired.ExpiresAt)
+	}
+
+	if replay, err := core.Begin(ctx, "key-2", "alice", "fp-new"); err != nil || replay != nil {
+		t.Errorf("\t\tShould claim the key afresh once its record expired: got %v, %v", replay, err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen a duplicate arrives while the request is still being processed")
+	if replay, err := core.Begin(ctx, "key-3", "alice", "fp-3"); err != nil || replay != nil {
+		t.Fatalf("\t\tShould claim a new key: got %v, %v", replay, err)
+	}
+
+	if _, err := core.Begin(ctx, "key-3", "alice", "fp-3"); !errors.Is(err, idempotency.ErrInProgress) {
+		t.Errorf("\t\tExpected ErrInProgress, got %v", err)
+	}
+
+	if replay, err := core.Begin(ctx, "key-3", "bob", "fp-3"); err != nil || replay != nil {
+		t.Errorf("\t\tShould let another principal claim the same key: got %v, %v", replay, err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen the request is released after it failed")
+	if err := core.Release(ctx, "key-3", "alice"); err != nil {
+		t.Fatalf("\t\tShould be able to release the key: %s", err)
+	}
+
+	if replay, err := core.Begin(ctx, "key-3", "alice", "fp-3"); err != nil || replay != nil {
+		t.Errorf("\t\tShould claim the released key again: got %v, %v", replay, err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen the key is reused for a different request")
+	if _, err := core.Begin(ctx, "key-1", "alice", "fp-other"); !errors.Is(err, idempotency.ErrFingerprintMismatch) {
+		t.Errorf("\t\tExpected ErrFingerprintMismatch, got %v", err)
+	}
+}
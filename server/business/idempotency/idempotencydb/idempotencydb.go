@@ -0,0 +1,121 @@
+package idempotencydb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/idempotency"
+	"github.com/Babatunde50/book-crud/server/internal/database"
+)
+
+type Store struct {
+	db *database.DB
+}
+
+// New creates a new idempotencydb store that satisfies the idempotency.Storer interface.
+func New(db *database.DB) *Store {
+	return &Store{db: db}
+}
+
+// Insert claims an idempotency key. It reports false, without an error, when
+// the key is already claimed by the principal.
+func (s *Store) Insert(ctx context.Context, rec idempotency.Record) (bool, error) {
+	const query = `-- name=idempotencydb.Insert
+		INSERT INTO idempotency_keys (
+			idempotency_key, principal, fingerprint, date_created, expires_at
+		)
+		VALUES (
+			$1, $2, $3, $4, $5
+		)
+		ON CONFLICT (idempotency_key, principal) DO NOTHING`
+
+	dbRec := toDBRecord(rec)
+
+	result, err := s.db.ExecContext(ctx, query, dbRec.Key, dbRec.Principal, dbRec.Fingerprint, dbRec.DateCreated, dbRec.ExpiresAt)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// Complete stores the response for a claimed idempotency key.
+func (s *Store) Complete(ctx context.Context, key string, principal string, resp idempotency.Response) error {
+	const query = `-- name=idempotencydb.Complete
+		UPDATE idempotency_keys SET
+			response_status = $3,
+			response_headers = $4,
+			response_body = $5
+		WHERE idempotency_key = $1 AND principal = $2`
+
+	headers, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.ExecContext(ctx, query, key, principal, resp.StatusCode, string(headers), resp.Body)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return idempotency.ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a claimed idempotency key.
+func (s *Store) Delete(ctx context.Context, key string, principal string) error {
+	const query = `-- name=idempotencydb.Delete
+		DELETE FROM idempotency_keys WHERE idempotency_key = $1 AND principal = $2`
+
+	if _, err := s.db.ExecContext(ctx, query, key, principal); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpired removes every idempotency key that expired before now.
+func (s *Store) DeleteExpired(ctx context.Context, now time.Time) (int64, error) {
+	const query = `-- name=idempotencydb.DeleteExpired
+		DELETE FROM idempotency_keys WHERE expires_at <= $1`
+
+	result, err := s.db.ExecContext(ctx, query, now.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// QueryByKey retrieves the record for an idempotency key.
+func (s *Store) QueryByKey(ctx context.Context, key string, principal string) (idempotency.Record, error) {
+	const query = `-- name=idempotencydb.QueryByKey
+		SELECT * FROM idempotency_keys WHERE idempotency_key = $1 AND principal = $2`
+
+	var dbRec dbRecord
+	if err := s.db.GetContext(ctx, &dbRec, query, key, principal); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return idempotency.Record{}, idempotency.ErrNotFound
+		}
+
+		return idempotency.Record{}, err
+	}
+
+	return toCoreRecord(dbRec)
+}
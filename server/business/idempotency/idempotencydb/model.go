@@ -0,0 +1,63 @@
+package idempotencydb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/idempotency"
+)
+
+// dbRecord represents how an idempotency key is stored in the database.
+type dbRecord struct {
+	Key             string         `db:"idempotency_key"`
+	Principal       string         `db:"principal"`
+	Fingerprint     string         `db:"fingerprint"`
+	ResponseStatus  sql.NullInt64  `db:"response_status"`
+	ResponseHeaders sql.NullString `db:"response_headers"`
+	ResponseBody    []byte         `db:"response_body"`
+	DateCreated     time.Time      `db:"date_created"`
+	ExpiresAt       time.Time      `db:"expires_at"`
+}
+
+// toDBRecord converts the claim of a core idempotency.Record to its database
+// representation. Times are stored in UTC: the columns have no time zone, and
+// Postgres drops the offset of any other.
+func toDBRecord(rec idempotency.Record) dbRecord {
+	return dbRecord{
+		Key:         rec.Key,
+		Principal:   rec.Principal,
+		Fingerprint: rec.Fingerprint,
+		DateCreated: rec.DateCreated.UTC(),
+		ExpiresAt:   rec.ExpiresAt.UTC(),
+	}
+}
+
+// toCoreRecord converts a dbRecord to the core idempotency.Record type.
+func toCoreRecord(db dbRecord) (idempotency.Record, error) {
+	rec := idempotency.Record{
+		Key:         db.Key,
+		Principal:   db.Principal,
+		Fingerprint: db.Fingerprint,
+		DateCreated: db.DateCreated.UTC(),
+		ExpiresAt:   db.ExpiresAt.UTC(),
+	}
+
+	if db.ResponseStatus.Valid {
+		resp := idempotency.Response{
+			StatusCode: int(db.ResponseStatus.Int64),
+			Body:       db.ResponseBody,
+		}
+
+		if db.ResponseHeaders.Valid {
+			if err := json.Unmarshal([]byte(db.ResponseHeaders.String), &resp.Header); err != nil {
+				return idempotency.Record{}, fmt.Errorf("decoding response headers: %w", err)
+			}
+		}
+
+		rec.Response = &resp
+	}
+
+	return rec, nil
+}
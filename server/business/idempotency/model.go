@@ -0,0 +1,21 @@
+package idempotency
+
+import "time"
+
+// Record represents a claimed idempotency key and, once the original request
+// has finished, the response that was sent for it.
+type Record struct {
+	Key         string
+	Principal   string
+	Fingerprint string
+	Response    *Response
+	DateCreated time.Time
+	ExpiresAt   time.Time
+}
+
+// Response holds the parts of an HTTP response needed to replay it.
+type Response struct {
+	StatusCode int
+	Header     map[string][]string
+	Body       []byte
+}
@@ -0,0 +1,35 @@
+package shortlink
+
+import "time"
+
+// Link represents a short link and the URL it redirects to.
+type Link struct {
+	Code        string
+	URL         string
+	Alias       bool
+	Hits        int64
+	DateCreated time.Time
+	ExpiresAt   *time.Time
+}
+
+// Expired reports whether the link no longer redirects at now.
+func (l Link) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !l.ExpiresAt.After(now)
+}
+
+// Permanent reports whether the link redirects for good. Links that expire
+// redirect temporarily.
+func (l Link) Permanent() bool {
+	return l.ExpiresAt == nil
+}
+
+// NewLink holds data required to create a short link.
+type NewLink struct {
+	URL string
+
+	// Alias is the code to use instead of a generated one.
+	Alias string
+
+	// ExpiresAt, when set, ends the redirect.
+	ExpiresAt *time.Time
+}
@@ -0,0 +1,279 @@
+// Package shortlink provides support for short links that redirect to URLs
+// canonicalized by the URL processor.
+package shortlink
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Babatunde50/book-crud/server/business/shortlink")
+
+// Set of error variables for short link handling.
+var (
+	ErrNotFound     = errors.New("short link not found")
+	ErrExpired      = errors.New("short link expired")
+	ErrAliasTaken   = errors.New("alias is already taken")
+	ErrInvalidAlias = errors.New("invalid alias")
+	ErrInvalidURL   = errors.New("invalid URL")
+)
+
+// Defaults for the zero fields of Config.
+const (
+	DefaultCodeLength = 7
+	MaxURLLength      = 2048
+)
+
+// DefaultRules canonicalize URLs before they are shortened: the RFC 3986
+// normalizations, then the rules of the canonical operation.
+var DefaultRules = []string{
+	urlprocessor.RuleNormalize,
+	urlprocessor.RuleStripTracking,
+	urlprocessor.RuleDropFragment,
+	urlprocessor.RuleTrimTrailingSlash,
+}
+
+// maxCodeAttempts bounds the codes generated for a link when they turn out to
+// be taken. With 62^7 codes a second attempt is already rare.
+const maxCodeAttempts = 5
+
+// base62 is the alphabet of generated codes.
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,64}$`)
+
+// Storer defines the behavior the shortlink package expects from the data
+// store layer.
+type Storer interface {
+	Create(ctx context.Context, link Link) (bool, error)
+	QueryByCode(ctx context.Context, code string) (Link, error)
+	QueryByURL(ctx context.Context, url string) (Link, error)
+	AddHit(ctx context.Context, code string, now time.Time) (Link, error)
+}
+
+// Config sets how links are created.
+type Config struct {
+	// Rules canonicalize URLs, DefaultRules when nil.
+	Rules []string
+
+	// CodeLength is the length of generated codes, DefaultCodeLength when
+	// zero.
+	CodeLength int
+}
+
+// Core manages the set of APIs for short link access.
+type Core struct {
+	log        *slog.Logger
+	storer     Storer
+	processor  *urlprocessor.URLProcessor
+	rules      []string
+	codeLength int
+}
+
+// NewCore constructs a core for short link access. URLs are canonicalized by
+// processor before they are stored.
+func NewCore(log *slog.Logger, storer Storer, processor *urlprocessor.URLProcessor, cfg Config) (*Core, error) {
+	c := Core{
+		log:        log,
+		storer:     storer,
+		processor:  processor,
+		rules:      slices.Clone(cfg.Rules),
+		codeLength: cfg.CodeLength,
+	}
+
+	if c.rules == nil {
+		c.rules = slices.Clone(DefaultRules)
+	}
+	if c.codeLength == 0 {
+		c.codeLength = DefaultCodeLength
+	}
+
+	if c.codeLength < 4 || c.codeLength > 32 {
+		return nil, fmt.Errorf("code length %d is not between 4 and 32", c.codeLength)
+	}
+	for _, rule := range c.rules {
+		if !slices.Contains(processor.Rules(), rule) {
+			return nil, fmt.Errorf("%w: %q", urlprocessor.ErrUnknownRule, rule)
+		}
+	}
+
+	return &c, nil
+}
+
+// ValidateAlias reports whether alias may be used as the code of a link.
+func ValidateAlias(alias string) error {
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("%w: %q must be 3 to 64 letters, digits, '-' or '_'", ErrInvalidAlias, alias)
+	}
+	return nil
+}
+
+// Create adds a short link for the canonical form of nl.URL. Without an alias
+// or an expiry, the link already made for the same canonical URL is returned
+// instead of a new one; created reports which happened.
+func (c *Core) Create(ctx context.Context, nl NewLink) (_ Link, created bool, err error) {
+	ctx, span := tracer.Start(ctx, "shortlink.Create")
+	defer func() { tracing.End(span, err) }()
+
+	if nl.Alias != "" {
+		if err := ValidateAlias(nl.Alias); err != nil {
+			return Link{}, false, err
+		}
+	}
+
+	canonical, err := c.canonicalize(ctx, nl.URL)
+	if err != nil {
+		return Link{}, false, err
+	}
+
+	link := Link{
+		URL:         canonical,
+		Alias:       nl.Alias != "",
+		DateCreated: time.Now(),
+		ExpiresAt:   nl.ExpiresAt,
+	}
+	shared := !link.Alias && link.ExpiresAt == nil
+
+	for range maxCodeAttempts {
+		link.Code = nl.Alias
+		if !link.Alias {
+			if link.Code, err = newCode(c.codeLength); err != nil {
+				return Link{}, false, fmt.Errorf("create: %w", err)
+			}
+		}
+
+		inserted, err := c.storer.Create(ctx, link)
+		if err != nil {
+			return Link{}, false, fmt.Errorf("create: %w", err)
+		}
+
+		if inserted {
+			span.SetAttributes(codeAttr(link.Code))
+			c.log.InfoContext(ctx, "short link created", "code", link.Code, "alias", link.Alias)
+			return link, true, nil
+		}
+
+		if link.Alias {
+			return Link{}, false, ErrAliasTaken
+		}
+
+		// The insert conflicted with either the code or, for a shared link,
+		// the link another request made for the URL.
+		if shared {
+			existing, err := c.storer.QueryByURL(ctx, canonical)
+			switch {
+			case err == nil:
+				span.SetAttributes(codeAttr(existing.Code))
+				return existing, false, nil
+			case !errors.Is(err, ErrNotFound):
+				return Link{}, false, fmt.Errorf("create: query url: %w", err)
+			}
+		}
+	}
+
+	return Link{}, false, fmt.Errorf("create: no free code after %d attempts", maxCodeAttempts)
+}
+
+// QueryByCode finds a link by its code, whether or not it expired.
+func (c *Core) QueryByCode(ctx context.Context, code string) (_ Link, err error) {
+	ctx, span := tracer.Start(ctx, "shortlink.QueryByCode", trace.WithAttributes(codeAttr(code)))
+	defer func() { tracing.End(span, err) }()
+
+	link, err := c.storer.QueryByCode(ctx, code)
+	if err != nil {
+		return Link{}, fmt.Errorf("query: code[%s]: %w", code, err)
+	}
+	return link, nil
+}
+
+// Visit counts a hit on the link with the given code and returns it, or
+// ErrExpired when the link no longer redirects.
+func (c *Core) Visit(ctx context.Context, code string) (_ Link, err error) {
+	ctx, span := tracer.Start(ctx, "shortlink.Visit", trace.WithAttributes(codeAttr(code)))
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now()
+
+	link, err := c.storer.AddHit(ctx, code, now)
+	if err == nil {
+		return link, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return Link{}, fmt.Errorf("visit: code[%s]: %w", code, err)
+	}
+
+	// Nothing was counted: tell a missing link from an expired one.
+	link, err = c.storer.QueryByCode(ctx, code)
+	if err != nil {
+		return Link{}, fmt.Errorf("visit: code[%s]: %w", code, err)
+	}
+	if link.Expired(now) {
+		return Link{}, ErrExpired
+	}
+	return Link{}, fmt.Errorf("visit: code[%s]: %w", code, ErrNotFound)
+}
+
+// canonicalize runs rawURL through the rules of c and checks that the result
+// may be redirected to.
+func (c *Core) canonicalize(ctx context.Context, rawURL string) (string, error) {
+	result, err := c.processor.Apply(ctx, rawURL, c.rules, urlprocessor.Options{})
+	switch {
+	case errors.Is(err, urlprocessor.ErrInvalidURL), errors.Is(err, urlprocessor.ErrForbiddenAddress),
+		errors.Is(err, urlprocessor.ErrResolve):
+		return "", fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	case err != nil:
+		return "", fmt.Errorf("canonicalize: %w", err)
+	}
+
+	u, err := url.Parse(result.URL)
+	switch {
+	case err != nil:
+		return "", fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	case u.Scheme != "http" && u.Scheme != "https":
+		return "", fmt.Errorf("%w: scheme must be http or https", ErrInvalidURL)
+	case u.Host == "":
+		return "", fmt.Errorf("%w: host is required", ErrInvalidURL)
+	case len(result.URL) > MaxURLLength:
+		return "", fmt.Errorf("%w: longer than %d bytes", ErrInvalidURL, MaxURLLength)
+	}
+
+	return result.URL, nil
+}
+
+// newCode returns n characters drawn uniformly from base62.
+func newCode(n int) (string, error) {
+	code := make([]byte, 0, n)
+	buf := make([]byte, n)
+
+	for len(code) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// 248 is the largest multiple of 62 below 256; larger bytes
+			// would favour the start of the alphabet.
+			if b < 248 && len(code) < n {
+				code = append(code, base62[b%62])
+			}
+		}
+	}
+
+	return string(code), nil
+}
+
+func codeAttr(code string) attribute.KeyValue {
+	return attribute.String("shortlink.code", code)
+}
@@ -0,0 +1,241 @@
+package shortlink_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/shortlink"
+	"github.com/Babatunde50/book-crud/server/business/shortlink/shortlinkdb"
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/internal/dbtest"
+)
+
+func Test_ShortLink(t *testing.T) {
+	c, err := dbtest.StartDB(t)
+	if err != nil {
+		t.Fatalf("starting test DB: %v", err)
+	}
+	defer dbtest.StopDB(c)
+
+	test := dbtest.NewTest(t, c)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Log(r)
+			t.Error(string(debug.Stack()))
+		}
+		test.Teardown()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	core := newCore(t, shortlinkdb.New(test.DB))
+
+	// ---------------------------------------------------------------------
+
+	t.Log("Given the need to shorten URLs")
+
+	t.Log("\tWhen creating a link")
+	link, created, err := core.Create(ctx, shortlink.NewLink{URL: "HTTPS://Example.com/a/./b/?utm_source=x&id=1#top"})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a link: %s", err)
+	}
+
+	if !created {
+		t.Errorf("\t\tShould report the link as created")
+	}
+	if link.URL != "https://example.com/a/b?id=1" {
+		t.Errorf("\t\tShould store the canonical URL: got %q", link.URL)
+	}
+	if !regexp.MustCompile(`^[0-9A-Za-z]{7}$`).MatchString(link.Code) {
+		t.Errorf("\t\tShould generate a base62 code of 7 characters: got %q", link.Code)
+	}
+	if !link.Permanent() {
+		t.Errorf("\t\tShould make a link without expiry permanent")
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen shortening the same canonical URL again")
+	again, created, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.com/a/b/?id=1&utm_medium=y"})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a link: %s", err)
+	}
+
+	if created || again.Code != link.Code {
+		t.Errorf("\t\tShould return the existing link %q: got %q, created %t", link.Code, again.Code, created)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen creating a link with an alias")
+	alias, created, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.com/a/b?id=1", Alias: "my-link"})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create an aliased link: %s", err)
+	}
+
+	if !created || alias.Code != "my-link" || !alias.Alias {
+		t.Errorf("\t\tShould create a link under the alias: got %+v", alias)
+	}
+
+	if _, _, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.org", Alias: "my-link"}); !errors.Is(err, shortlink.ErrAliasTaken) {
+		t.Errorf("\t\tExpected ErrAliasTaken reusing the alias, got %v", err)
+	}
+
+	if _, _, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.org", Alias: "no/slash"}); !errors.Is(err, shortlink.ErrInvalidAlias) {
+		t.Errorf("\t\tExpected ErrInvalidAlias, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen creating a link that expires")
+	expiresAt := time.Now().Add(time.Hour)
+	expiring, created, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.com/a/b?id=1", ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create an expiring link: %s", err)
+	}
+
+	if !created || expiring.Code == link.Code || expiring.Permanent() {
+		t.Errorf("\t\tShould create a new, temporary link: got %+v", expiring)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen visiting the link")
+	for range 2 {
+		if _, err := core.Visit(ctx, link.Code); err != nil {
+			t.Fatalf("\t\tShould be able to visit the link: %s", err)
+		}
+	}
+
+	stats, err := core.QueryByCode(ctx, link.Code)
+	if err != nil {
+		t.Fatalf("\t\tShould be able to query the link: %s", err)
+	}
+	if stats.Hits != 2 {
+		t.Errorf("\t\tShould count every visit: got %d hits, want 2", stats.Hits)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen visiting an expired link")
+	expiredAt := time.Now().Add(-time.Minute)
+	expired, _, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.com/old", ExpiresAt: &expiredAt})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a link: %s", err)
+	}
+
+	if _, err := core.Visit(ctx, expired.Code); !errors.Is(err, shortlink.ErrExpired) {
+		t.Errorf("\t\tExpected ErrExpired, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen links expire at times given in other time zones")
+	tokyo := time.FixedZone("UTC+9", 9*60*60)
+	newYork := time.FixedZone("UTC-5", -5*60*60)
+
+	pastInTokyo := time.Now().Add(-time.Minute).Truncate(time.Second).In(tokyo)
+	pastLink, _, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.com/tokyo", ExpiresAt: &pastInTokyo})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a link: %s", err)
+	}
+	if _, err := core.Visit(ctx, pastLink.Code); !errors.Is(err, shortlink.ErrExpired) {
+		t.Errorf("\t\tExpected ErrExpired for a link that expired at %s, got %v", pastInTokyo, err)
+	}
+
+	futureInNewYork := time.Now().Add(time.Hour).Truncate(time.Second).In(newYork)
+	futureLink, _, err := core.Create(ctx, shortlink.NewLink{URL: "https://example.com/new-york", ExpiresAt: &futureInNewYork})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a link: %s", err)
+	}
+	if _, err := core.Visit(ctx, futureLink.Code); err != nil {
+		t.Errorf("\t\tShould be able to visit a link that expires at %s: %s", futureInNewYork, err)
+	}
+
+	stored, err := core.QueryByCode(ctx, futureLink.Code)
+	if err != nil {
+		t.Fatalf("\t\tShould be able to query the link: %s", err)
+	}
+	if stored.ExpiresAt == nil || !stored.ExpiresAt.Equal(futureInNewYork) {
+		t.Errorf("\t\tShould keep the instant the link expires: got %v, want %s", stored.ExpiresAt, futureInNewYork)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen visiting a missing link")
+	if _, err := core.Visit(ctx, "missing"); !errors.Is(err, shortlink.ErrNotFound) {
+		t.Errorf("\t\tExpected ErrNotFound, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen shortening a URL that cannot be redirected to")
+	for _, rawURL := range []string{"javascript:alert(1)", "mailto:someone@example.com", "not a url"} {
+		if _, _, err := core.Create(ctx, shortlink.NewLink{URL: rawURL}); !errors.Is(err, shortlink.ErrInvalidURL) {
+			t.Errorf("\t\tExpected ErrInvalidURL for %q, got %v", rawURL, err)
+		}
+	}
+}
+
+func TestNewCore_InvalidConfig(t *testing.T) {
+	processor, err := urlprocessor.New(urlprocessor.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]shortlink.Config{
+		"short code":   {CodeLength: 3},
+		"long code":    {CodeLength: 33},
+		"unknown rule": {Rules: []string{"shorten"}},
+	}
+
+	for name, cfg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := shortlink.NewCore(slog.New(slog.NewTextHandler(io.Discard, nil)), nil, processor, cfg); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidateAlias(t *testing.T) {
+	tests := map[string]bool{
+		"abc":         true,
+		"Spring_Sale": true,
+		"launch-2025": true,
+		"ab":          false,
+		"with space":  false,
+		"a/b":         false,
+		"café":        false,
+	}
+
+	for alias, valid := range tests {
+		if err := shortlink.ValidateAlias(alias); (err == nil) != valid {
+			t.Errorf("ValidateAlias(%q) = %v, want valid %t", alias, err, valid)
+		}
+	}
+}
+
+func newCore(t *testing.T, store shortlink.Storer) *shortlink.Core {
+	t.Helper()
+
+	processor, err := urlprocessor.New(urlprocessor.Config{
+		Query: urlprocessor.QueryRules{Default: urlprocessor.QueryPolicy{Deny: urlprocessor.TrackingParams}},
+	})
+	if err != nil {
+		t.Fatalf("creating url processor: %v", err)
+	}
+
+	core, err := shortlink.NewCore(slog.New(slog.NewTextHandler(io.Discard, nil)), store, processor, shortlink.Config{})
+	if err != nil {
+		t.Fatalf("creating core: %v", err)
+	}
+	return core
+}
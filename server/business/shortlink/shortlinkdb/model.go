@@ -0,0 +1,55 @@
+package shortlinkdb
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/shortlink"
+)
+
+// dbLink represents how a short link is stored in the database.
+type dbLink struct {
+	Code        string       `db:"code"`
+	URL         string       `db:"url"`
+	Alias       bool         `db:"alias"`
+	Hits        int64        `db:"hits"`
+	DateCreated time.Time    `db:"date_created"`
+	ExpiresAt   sql.NullTime `db:"expires_at"`
+}
+
+// toDBLink converts a core shortlink.Link to its database representation.
+// Times are stored in UTC: the columns have no time zone, and Postgres drops
+// the offset of any other.
+func toDBLink(link shortlink.Link) dbLink {
+	db := dbLink{
+		Code:        link.Code,
+		URL:         link.URL,
+		Alias:       link.Alias,
+		Hits:        link.Hits,
+		DateCreated: link.DateCreated.UTC(),
+	}
+
+	if link.ExpiresAt != nil {
+		db.ExpiresAt = sql.NullTime{Time: link.ExpiresAt.UTC(), Valid: true}
+	}
+
+	return db
+}
+
+// toCoreLink converts a dbLink to the core shortlink.Link type.
+func toCoreLink(db dbLink) shortlink.Link {
+	link := shortlink.Link{
+		Code:        db.Code,
+		URL:         db.URL,
+		Alias:       db.Alias,
+		Hits:        db.Hits,
+		DateCreated: db.DateCreated.UTC(),
+	}
+
+	if db.ExpiresAt.Valid {
+		expiresAt := db.ExpiresAt.Time.UTC()
+		link.ExpiresAt = &expiresAt
+	}
+
+	return link
+}
@@ -0,0 +1,101 @@
+package shortlinkdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/shortlink"
+	"github.com/Babatunde50/book-crud/server/internal/database"
+)
+
+type Store struct {
+	db *database.DB
+}
+
+// New creates a new shortlinkdb store that satisfies the shortlink.Storer interface.
+func New(db *database.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create inserts a new short link. It reports false, without an error, when
+// the code is taken or, for a link that is shared, the URL already has one.
+func (s *Store) Create(ctx context.Context, link shortlink.Link) (bool, error) {
+	const query = `-- name=shortlinkdb.Create
+		INSERT INTO short_links (
+			code, url, alias, hits, date_created, expires_at
+		)
+		VALUES (
+			:code, :url, :alias, :hits, :date_created, :expires_at
+		)
+		ON CONFLICT DO NOTHING`
+
+	result, err := s.db.NamedExecContext(ctx, query, toDBLink(link))
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows == 1, nil
+}
+
+// QueryByCode retrieves a short link by its code, from a replica when one is
+// available.
+func (s *Store) QueryByCode(ctx context.Context, code string) (shortlink.Link, error) {
+	const query = `-- name=shortlinkdb.QueryByCode
+		SELECT * FROM short_links WHERE code = $1`
+
+	var dbLink dbLink
+	if err := s.db.Reader(ctx).GetContext(ctx, &dbLink, query, code); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return shortlink.Link{}, shortlink.ErrNotFound
+		}
+
+		return shortlink.Link{}, err
+	}
+
+	return toCoreLink(dbLink), nil
+}
+
+// QueryByURL retrieves the shared link for a canonical URL: the one that was
+// generated rather than aliased and never expires.
+func (s *Store) QueryByURL(ctx context.Context, url string) (shortlink.Link, error) {
+	const query = `-- name=shortlinkdb.QueryByURL
+		SELECT * FROM short_links WHERE url = $1 AND NOT alias AND expires_at IS NULL`
+
+	var dbLink dbLink
+	if err := s.db.GetContext(ctx, &dbLink, query, url); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return shortlink.Link{}, shortlink.ErrNotFound
+		}
+
+		return shortlink.Link{}, err
+	}
+
+	return toCoreLink(dbLink), nil
+}
+
+// AddHit counts a hit on the short link with the given code, unless it
+// expired before now, and returns the link.
+func (s *Store) AddHit(ctx context.Context, code string, now time.Time) (shortlink.Link, error) {
+	const query = `-- name=shortlinkdb.AddHit
+		UPDATE short_links SET hits = hits + 1
+		WHERE code = $1 AND (expires_at IS NULL OR expires_at > $2)
+		RETURNING *`
+
+	var dbLink dbLink
+	if err := s.db.GetContext(ctx, &dbLink, query, code, now.UTC()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return shortlink.Link{}, shortlink.ErrNotFound
+		}
+
+		return shortlink.Link{}, err
+	}
+
+	return toCoreLink(dbLink), nil
+}
@@ -0,0 +1,57 @@
+package urlprocessor
+
+import "net/url"
+
+// Step records what a single rule did to a URL.
+type Step struct {
+	Rule    string
+	Before  string
+	After   string
+	Changed []string // components the rule changed, in URL order
+}
+
+// URL components reported in Step.Changed.
+const (
+	ComponentScheme   = "scheme"
+	ComponentOpaque   = "opaque"
+	ComponentUserinfo = "userinfo"
+	ComponentHost     = "host"
+	ComponentPath     = "path"
+	ComponentQuery    = "query"
+	ComponentFragment = "fragment"
+)
+
+// changed returns the components that differ between before and after,
+// comparing them in their escaped form.
+func changed(before, after *url.URL) []string {
+	components := []struct {
+		name string
+		part func(u *url.URL) string
+	}{
+		{ComponentScheme, func(u *url.URL) string { return u.Scheme }},
+		{ComponentOpaque, func(u *url.URL) string { return u.Opaque }},
+		{ComponentUserinfo, func(u *url.URL) string {
+			if u.User == nil {
+				return ""
+			}
+			return u.User.String() + "@"
+		}},
+		{ComponentHost, func(u *url.URL) string { return u.Host }},
+		{ComponentPath, func(u *url.URL) string { return u.EscapedPath() }},
+		{ComponentQuery, func(u *url.URL) string {
+			if u.ForceQuery {
+				return "?" + u.RawQuery
+			}
+			return u.RawQuery
+		}},
+		{ComponentFragment, func(u *url.URL) string { return u.EscapedFragment() }},
+	}
+
+	var names []string
+	for _, c := range components {
+		if c.part(before) != c.part(after) {
+			names = append(names, c.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,298 @@
+package urlprocessor
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Profile describes how the redirection operation rewrites the scheme, host
+// and path of a URL.
+type Profile struct {
+	// Hosts maps a source host to its canonical host. A source of the form
+	// "*.example.com" matches every subdomain of example.com, at any depth,
+	// but not example.com itself. Exact sources win over patterns, and longer
+	// patterns over shorter ones. Internationalized hosts may be written in
+	// Unicode or punycode.
+	Hosts map[string]string
+
+	// DefaultHost replaces hosts that match nothing in Hosts. When empty,
+	// those hosts are kept.
+	DefaultHost string
+
+	// Scheme, when set, replaces the scheme of every URL.
+	Scheme string
+
+	// CaseSensitivePaths lists, for the redirected host keyed like Hosts,
+	// escaped path prefixes after which the path keeps its case. Redirection
+	// lowercases the rest. A prefix of "/" keeps the whole path.
+	CaseSensitivePaths map[string][]string
+}
+
+// Config holds the profile used by default, any named profiles requests may
+// select instead, the query rules canonicalization follows, how resolve
+// follows redirects and any rules added to the built-in ones.
+type Config struct {
+	Default  Profile
+	Profiles map[string]Profile
+	Query    QueryRules
+	Resolve  ResolveConfig
+
+	// Rules are custom rules pipelines may name. Names are made of lowercase
+	// letters, digits and underscores, and may not be those of built-in rules.
+	Rules map[string]Rule
+}
+
+// Options adjust how a single URL is processed.
+type Options struct {
+	Profile    string // named profile to use instead of the default
+	TargetHost string // host to redirect to, overriding the profile's mapping
+	Unicode    bool   // also return the Unicode form of the result
+	Explain    bool   // also return a trace of the rules applied
+}
+
+// hostMap is a Profile ready for lookups.
+type hostMap struct {
+	hosts         hostTable[string]
+	defaultHost   string
+	scheme        string
+	caseSensitive hostTable[[]string]
+}
+
+func newHostMap(p Profile) (*hostMap, error) {
+	if err := ValidateScheme(p.Scheme); err != nil {
+		return nil, err
+	}
+	if p.DefaultHost != "" {
+		if err := ValidateHost(p.DefaultHost); err != nil {
+			return nil, fmt.Errorf("default host: %w", err)
+		}
+	}
+
+	m := hostMap{
+		defaultHost: lowerASCIIHost(p.DefaultHost),
+		scheme:      strings.ToLower(p.Scheme),
+	}
+
+	for source, target := range p.Hosts {
+		if err := ValidateSource(source); err != nil {
+			return nil, err
+		}
+		if err := ValidateHost(target); err != nil {
+			return nil, fmt.Errorf("target of %q: %w", source, err)
+		}
+		m.hosts.add(source, lowerASCIIHost(target))
+	}
+	m.hosts.sort()
+
+	for source, prefixes := range p.CaseSensitivePaths {
+		if err := ValidateSource(source); err != nil {
+			return nil, fmt.Errorf("case sensitive paths: %w", err)
+		}
+		for _, prefix := range prefixes {
+			if !strings.HasPrefix(prefix, "/") {
+				return nil, fmt.Errorf("case sensitive path %q of %q must start with /", prefix, source)
+			}
+		}
+		m.caseSensitive.add(source, prefixes)
+	}
+	m.caseSensitive.sort()
+
+	return &m, nil
+}
+
+// lookup returns the host a URL on host redirects to.
+func (m *hostMap) lookup(host string) string {
+	if _, target, ok := m.hosts.match(host); ok {
+		return target
+	}
+
+	if m.defaultHost != "" {
+		return m.defaultHost
+	}
+	return strings.ToLower(host)
+}
+
+// lowerPath lowercases the escaped path p of a URL on host, up to the end of
+// the first case sensitive prefix configured for host that p starts with.
+func (m *hostMap) lowerPath(host, p string) string {
+	_, prefixes, _ := m.caseSensitive.match(host)
+
+	for _, prefix := range prefixes {
+		if len(p) >= len(prefix) && strings.EqualFold(p[:len(prefix)], prefix) {
+			return lowerPath(p[:len(prefix)]) + p[len(prefix):]
+		}
+	}
+
+	return lowerPath(p)
+}
+
+// hostTable looks values up by host. Keys are exact hosts or "*.domain"
+// patterns; exact keys win over patterns, and longer patterns over shorter
+// ones.
+type hostTable[T any] struct {
+	exact    map[string]T
+	patterns []hostPattern[T] // longest suffix first, once sorted
+}
+
+type hostPattern[T any] struct {
+	source string // "*.example.com"
+	value  T
+}
+
+func (t *hostTable[T]) add(source string, value T) {
+	source = strings.ToLower(source)
+	if name, ok := strings.CutPrefix(source, "*."); ok {
+		source = "*." + lowerASCIIHost(name)
+	} else {
+		source = lowerASCIIHost(source)
+	}
+
+	if strings.HasPrefix(source, "*.") {
+		t.patterns = append(t.patterns, hostPattern[T]{source: source, value: value})
+		return
+	}
+
+	if t.exact == nil {
+		t.exact = map[string]T{}
+	}
+	t.exact[source] = value
+}
+
+// sort orders the patterns for match; call it once every key is added.
+func (t *hostTable[T]) sort() {
+	sort.Slice(t.patterns, func(i, j int) bool {
+		return len(t.patterns[i].source) > len(t.patterns[j].source)
+	})
+}
+
+// match returns the key matching host, ignoring any port, and its value.
+func (t *hostTable[T]) match(host string) (source string, value T, ok bool) {
+	name := strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(name); err == nil {
+		name = h
+	}
+
+	if value, ok := t.exact[name]; ok {
+		return name, value, true
+	}
+
+	for _, p := range t.patterns {
+		if strings.HasSuffix(name, p.source[1:]) {
+			return p.source, p.value, true
+		}
+	}
+
+	return "", value, false
+}
+
+// ParseHosts parses "source=target" pairs, as given on the command line, into
+// a Profile's Hosts table.
+func ParseHosts(pairs []string) (map[string]string, error) {
+	hosts := make(map[string]string, len(pairs))
+
+	for _, pair := range pairs {
+		source, target, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q is not a source=target pair", pair)
+		}
+		if err := ValidateSource(source); err != nil {
+			return nil, err
+		}
+		if err := ValidateHost(target); err != nil {
+			return nil, fmt.Errorf("target of %q: %w", source, err)
+		}
+		hosts[source] = target
+	}
+
+	return hosts, nil
+}
+
+// ParseCaseSensitivePaths parses "host=/prefix" pairs, as given on the command
+// line, into a Profile's CaseSensitivePaths table. A host may appear in
+// several pairs.
+func ParseCaseSensitivePaths(pairs []string) (map[string][]string, error) {
+	paths := map[string][]string{}
+
+	for _, pair := range pairs {
+		source, prefix, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("%q is not a host=/prefix pair", pair)
+		}
+		if err := ValidateSource(source); err != nil {
+			return nil, err
+		}
+		paths[source] = append(paths[source], prefix)
+	}
+
+	return paths, nil
+}
+
+// ValidateScheme reports whether s may be enforced by a Profile.
+func ValidateScheme(s string) error {
+	switch strings.ToLower(s) {
+	case "", "http", "https":
+		return nil
+	default:
+		return fmt.Errorf("unsupported scheme %q (use http or https)", s)
+	}
+}
+
+// ValidateHost reports whether host is a DNS name, in ASCII or Unicode, or an
+// IP address, optionally followed by a port.
+func ValidateHost(host string) error {
+	name := host
+	if h, port, err := net.SplitHostPort(host); err == nil {
+		if port == "" {
+			return fmt.Errorf("%q has an empty port", host)
+		}
+		name = h
+	}
+
+	if net.ParseIP(name) != nil || validName(name) {
+		return nil
+	}
+	return fmt.Errorf("%q is not a valid host", host)
+}
+
+// ValidateSource reports whether source is a host or a *.domain pattern that
+// may key a Profile's Hosts table.
+func ValidateSource(source string) error {
+	name := strings.TrimPrefix(source, "*.")
+	if !validName(name) {
+		return fmt.Errorf("%q is not a host or a *.domain pattern", source)
+	}
+	return nil
+}
+
+// validName reports whether name is made of dot separated labels of letters,
+// digits and inner hyphens, once internationalized labels are converted to
+// punycode.
+func validName(name string) bool {
+	if !isASCII(name) {
+		var err error
+		if name, err = idna.Lookup.ToASCII(name); err != nil {
+			return false
+		}
+	}
+
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	for label := range strings.SplitSeq(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,180 @@
+package urlprocessor
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultPorts are the ports implied by a scheme, which normalization drops.
+// These schemes also treat an empty path as "/".
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+}
+
+// normalize applies the syntax and scheme based normalizations of RFC 3986
+// section 6.2.2 and 6.2.3: scheme and host are lowercased, percent encodings
+// get uppercase hex digits and are decoded when they stand for an unreserved
+// character, dot-segments are removed from the path, a default or empty port
+// is dropped and an empty path becomes "/". Only the percent encodings of the
+// query and fragment are touched. The userinfo needs no work, as url.URL
+// always encodes it in normal form.
+func normalize(u *url.URL, _ *State) error {
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	if u.Opaque != "" {
+		u.Opaque = normalizePercent(u.Opaque)
+	} else {
+		if u.Host != "" {
+			u.Host = normalizeHost(u)
+		}
+
+		path := removeDotSegments(normalizePercent(u.EscapedPath()))
+		if _, ok := defaultPorts[u.Scheme]; ok && path == "" && u.Host != "" {
+			path = "/"
+		}
+		if err := setEscapedPath(u, path); err != nil {
+			return err
+		}
+	}
+
+	u.RawQuery = normalizePercent(u.RawQuery)
+
+	if u.Fragment != "" {
+		fragment := normalizePercent(u.EscapedFragment())
+		unescaped, err := url.PathUnescape(fragment)
+		if err != nil {
+			return fmt.Errorf("fragment %q: %w", fragment, err)
+		}
+		u.Fragment, u.RawFragment = unescaped, fragment
+	}
+
+	return nil
+}
+
+// normalizeHost lowercases the host of u, leaving an IPv6 zone as it is, and
+// drops the port when it is empty or the default for the scheme.
+func normalizeHost(u *url.URL) string {
+	host, zone, _ := strings.Cut(u.Hostname(), "%")
+	host = strings.ToLower(host)
+
+	if strings.Contains(host, ":") {
+		if zone != "" {
+			host += "%" + zone
+		}
+		host = "[" + host + "]"
+	}
+
+	port := u.Port()
+	if port == "" || port == defaultPorts[u.Scheme] {
+		return host
+	}
+	return host + ":" + port
+}
+
+// normalizePercent uppercases the hex digits of every percent encoding in s
+// and decodes those standing for unreserved characters. Malformed encodings
+// are left alone.
+func normalizePercent(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if unreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(percentEncode(c))
+		}
+		i += 2
+	}
+
+	return b.String()
+}
+
+// removeDotSegments implements the algorithm of RFC 3986 section 5.2.4.
+func removeDotSegments(in string) string {
+	var out []string
+
+	pop := func() {
+		if len(out) > 0 {
+			out = out[:len(out)-1]
+		}
+	}
+
+	for in != "" {
+		switch {
+		case strings.HasPrefix(in, "../"):
+			in = in[3:]
+		case strings.HasPrefix(in, "./"):
+			in = in[2:]
+		case strings.HasPrefix(in, "/./"):
+			in = in[2:]
+		case in == "/.":
+			in = "/"
+		case strings.HasPrefix(in, "/../"):
+			in = in[3:]
+			pop()
+		case in == "/..":
+			in = "/"
+			pop()
+		case in == "." || in == "..":
+			in = ""
+		default:
+			start := 0
+			if in[0] == '/' {
+				start = 1
+			}
+			end := strings.IndexByte(in[start:], '/')
+			if end < 0 {
+				end = len(in)
+			} else {
+				end += start
+			}
+			out = append(out, in[:end])
+			in = in[end:]
+		}
+	}
+
+	return strings.Join(out, "")
+}
+
+// unreserved reports whether c is an unreserved character, which never needs
+// to be percent-encoded.
+func unreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func percentEncode(c byte) string {
+	const hex = "0123456789ABCDEF"
+	return string([]byte{'%', hex[c>>4], hex[c&0xF]})
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
@@ -0,0 +1,252 @@
+package urlprocessor
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxPatternLength bounds the regular expressions of path rewrites.
+const maxPatternLength = 1024
+
+// Overlay holds rules managed at run time, such as those kept in a database,
+// layered over the Config the processor was built with. Its host mappings
+// and query policies win over configured ones for the same host.
+type Overlay struct {
+	// Hosts adds to the host mappings of the default profile and of every
+	// named profile.
+	Hosts map[string]string
+
+	// Query adds to the per-host query policies.
+	Query map[string]QueryPolicy
+
+	// PathRewrites are tried in order by the rewrite_path rule.
+	PathRewrites []PathRewrite
+}
+
+// PathRewrite rewrites the paths of URLs on a host with a regular expression.
+type PathRewrite struct {
+	// Host is a host or *.domain pattern, keyed as in Profile.Hosts, or
+	// empty for every host.
+	Host string
+
+	// Pattern is matched against the escaped path.
+	Pattern string
+
+	// Replacement replaces the first match of Pattern. It may refer to
+	// capture groups as $1 or ${name}; $$ is a literal $.
+	Replacement string
+}
+
+// snapshot is everything a pipeline run reads that an Overlay changes. It is
+// replaced whole, never modified.
+type snapshot struct {
+	defaultHosts *hostMap
+	profiles     map[string]*hostMap
+	query        *queryRules
+	rewrites     []pathRewrite
+}
+
+// pathRewrite is a PathRewrite ready to apply.
+type pathRewrite struct {
+	host        hostTable[struct{}] // empty for every host
+	anyHost     bool
+	re          *regexp.Regexp
+	replacement string
+}
+
+// SetOverlay validates o and makes the processor follow it, on top of its
+// Config, from the next URL processed on. Runs already under way finish with
+// the rules they started with. On error the processor keeps its rules.
+func (p *URLProcessor) SetOverlay(o Overlay) error {
+	s, err := p.newSnapshot(o)
+	if err != nil {
+		return err
+	}
+
+	p.current.Store(s)
+	return nil
+}
+
+// WithOverlay returns a processor that follows o on top of the Config of p,
+// leaving p as it is. It lets changes to an overlay be tried out first.
+func (p *URLProcessor) WithOverlay(o Overlay) (*URLProcessor, error) {
+	s, err := p.newSnapshot(o)
+	if err != nil {
+		return nil, err
+	}
+
+	q := URLProcessor{
+		base:     p.base,
+		resolver: p.resolver,
+		rules:    p.rules,
+	}
+	q.current.Store(s)
+	return &q, nil
+}
+
+// newSnapshot builds the rules of the processor's Config with o layered over
+// them.
+func (p *URLProcessor) newSnapshot(o Overlay) (*snapshot, error) {
+	defaultHosts, err := newHostMap(withHosts(p.base.Default, o.Hosts))
+	if err != nil {
+		return nil, fmt.Errorf("default profile: %w", err)
+	}
+
+	profiles := make(map[string]*hostMap, len(p.base.Profiles))
+	for name, profile := range p.base.Profiles {
+		if profiles[name], err = newHostMap(withHosts(profile, o.Hosts)); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", name, err)
+		}
+	}
+
+	query := p.base.Query
+	query.Hosts = maps.Clone(query.Hosts)
+	for source, policy := range o.Query {
+		if query.Hosts == nil {
+			query.Hosts = make(map[string]QueryPolicy, len(o.Query))
+		}
+		query.Hosts[source] = policy
+	}
+
+	queryRules, err := newQueryRules(query)
+	if err != nil {
+		return nil, err
+	}
+
+	s := snapshot{
+		defaultHosts: defaultHosts,
+		profiles:     profiles,
+		query:        queryRules,
+		rewrites:     make([]pathRewrite, len(o.PathRewrites)),
+	}
+
+	for i, r := range o.PathRewrites {
+		if s.rewrites[i], err = newPathRewrite(r); err != nil {
+			return nil, fmt.Errorf("path rewrite %d: %w", i+1, err)
+		}
+	}
+
+	return &s, nil
+}
+
+// withHosts returns profile with hosts added to its host mappings, replacing
+// those for the same source.
+func withHosts(profile Profile, hosts map[string]string) Profile {
+	if len(hosts) == 0 {
+		return profile
+	}
+
+	profile.Hosts = maps.Clone(profile.Hosts)
+	if profile.Hosts == nil {
+		profile.Hosts = make(map[string]string, len(hosts))
+	}
+	maps.Copy(profile.Hosts, hosts)
+	return profile
+}
+
+// ValidatePathRewrite reports whether r may be used in an Overlay.
+func ValidatePathRewrite(r PathRewrite) error {
+	_, err := newPathRewrite(r)
+	return err
+}
+
+func newPathRewrite(r PathRewrite) (pathRewrite, error) {
+	rw := pathRewrite{anyHost: r.Host == "", replacement: r.Replacement}
+
+	if !rw.anyHost {
+		if err := ValidateSource(r.Host); err != nil {
+			return pathRewrite{}, err
+		}
+		rw.host.add(r.Host, struct{}{})
+	}
+
+	if r.Pattern == "" {
+		return pathRewrite{}, errors.New("pattern must not be empty")
+	}
+	if len(r.Pattern) > maxPatternLength {
+		return pathRewrite{}, fmt.Errorf("pattern is longer than %d bytes", maxPatternLength)
+	}
+
+	var err error
+	if rw.re, err = regexp.Compile(r.Pattern); err != nil {
+		return pathRewrite{}, fmt.Errorf("pattern: %w", err)
+	}
+
+	if err := checkReplacement(rw.re, r.Replacement); err != nil {
+		return pathRewrite{}, fmt.Errorf("replacement: %w", err)
+	}
+
+	return rw, nil
+}
+
+// checkReplacement reports references in repl to groups re does not have,
+// which regexp would silently replace with nothing.
+func checkReplacement(re *regexp.Regexp, repl string) error {
+	for i := 0; i < len(repl); i++ {
+		if repl[i] != '$' {
+			continue
+		}
+		i++
+
+		if i < len(repl) && repl[i] == '$' {
+			continue
+		}
+
+		var name string
+		if i < len(repl) && repl[i] == '{' {
+			end := strings.IndexByte(repl[i:], '}')
+			if end < 0 {
+				return fmt.Errorf("unterminated ${ in %q", repl)
+			}
+			name = repl[i+1 : i+end]
+			i += end
+		} else {
+			start := i
+			for i < len(repl) && isGroupNameByte(repl[i]) {
+				i++
+			}
+			name = repl[start:i]
+			i--
+		}
+
+		if name == "" || !hasGroup(re, name) {
+			return fmt.Errorf("%q refers to a group the pattern does not have", "$"+name)
+		}
+	}
+
+	return nil
+}
+
+func isGroupNameByte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '_'
+}
+
+// hasGroup reports whether name is the number or the name of a group of re.
+func hasGroup(re *regexp.Regexp, name string) bool {
+	if n, err := strconv.Atoi(name); err == nil {
+		return n <= re.NumSubexp()
+	}
+	return re.SubexpIndex(name) >= 0
+}
+
+// rewrite applies the rewrite to the escaped path p of a URL on host. It
+// reports false when the rewrite does not match.
+func (rw *pathRewrite) rewrite(host, p string) (string, bool) {
+	if !rw.anyHost {
+		if _, _, ok := rw.host.match(host); !ok {
+			return "", false
+		}
+	}
+
+	match := rw.re.FindStringSubmatchIndex(p)
+	if match == nil {
+		return "", false
+	}
+
+	expanded := rw.re.ExpandString(nil, rw.replacement, p, match)
+	return p[:match[0]] + string(expanded) + p[match[1]:], true
+}
@@ -0,0 +1,171 @@
+package urlprocessor_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+)
+
+func TestURLProcessor_SetOverlay(t *testing.T) {
+	p, err := urlprocessor.New(urlprocessor.Config{
+		Default: urlprocessor.Profile{
+			Hosts:       map[string]string{"old.example": "configured.example"},
+			DefaultHost: "www.byfood.com",
+		},
+		Profiles: map[string]urlprocessor.Profile{
+			"partner": {Hosts: map[string]string{"old.example": "partner.example", "legacy.example": "partner.example"}},
+		},
+		Query: urlprocessor.QueryRules{Default: urlprocessor.QueryPolicy{Deny: urlprocessor.TrackingParams}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overlay := urlprocessor.Overlay{
+		Hosts: map[string]string{"old.example": "new.example", "*.shop.example": "shop.example"},
+		Query: map[string]urlprocessor.QueryPolicy{"shop.example": {Allow: []string{"id"}}},
+		PathRewrites: []urlprocessor.PathRewrite{
+			{Host: "shop.example", Pattern: `^/items/(\d+)$`, Replacement: "/products/$1"},
+			{Host: "*.example", Pattern: `^/(?P<lang>en|ja)/`, Replacement: "/${lang}-jp/"},
+			{Pattern: `^/items/`, Replacement: "/catalog/"},
+		},
+	}
+	if err := p.SetOverlay(overlay); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rawURL string
+		op     urlprocessor.Operation
+		want   string
+	}{
+		{"https://old.example/a", urlprocessor.OpRedirection, "https://new.example/a"},
+		{"https://eu.shop.example/items/42", urlprocessor.OpRedirection, "https://shop.example/products/42"},
+		{"https://old.example/en/food", urlprocessor.OpRedirection, "https://new.example/en-jp/food"},
+		{"https://other.test/items/42", urlprocessor.OpRedirection, "https://www.byfood.com/catalog/42"},
+		{"https://shop.example/items?id=1&ref=x&utm_source=y", urlprocessor.OpCanonical, "https://shop.example/items?id=1"},
+	}
+
+	for _, tc := range tests {
+		res, err := p.Process(t.Context(), tc.rawURL, string(tc.op), urlprocessor.Options{})
+		if err != nil {
+			t.Errorf("%s: %v", tc.rawURL, err)
+			continue
+		}
+		if res.URL != tc.want {
+			t.Errorf("%s %s: got %q, want %q", tc.op, tc.rawURL, res.URL, tc.want)
+		}
+	}
+
+	t.Run("invalid overlay keeps the rules", func(t *testing.T) {
+		bad := urlprocessor.Overlay{PathRewrites: []urlprocessor.PathRewrite{{Pattern: "("}}}
+		if err := p.SetOverlay(bad); err == nil {
+			t.Fatal("expected an error")
+		}
+
+		res, err := p.Process(t.Context(), "https://old.example/a", string(urlprocessor.OpRedirection), urlprocessor.Options{})
+		if err != nil || res.URL != "https://new.example/a" {
+			t.Errorf("got %q, %v; want the overlay set before", res.URL, err)
+		}
+	})
+
+	t.Run("named profiles follow the overlay", func(t *testing.T) {
+		tests := map[string]string{
+			"https://old.example/a":      "https://new.example/a",
+			"https://legacy.example/a":   "https://partner.example/a",
+			"https://eu.shop.example/a":  "https://shop.example/a",
+			"https://unmapped.example/a": "https://unmapped.example/a",
+		}
+
+		for rawURL, want := range tests {
+			res, err := p.Process(t.Context(), rawURL, string(urlprocessor.OpRedirection), urlprocessor.Options{Profile: "partner"})
+			if err != nil || res.URL != want {
+				t.Errorf("%s: got %q, %v; want %q", rawURL, res.URL, err, want)
+			}
+		}
+	})
+
+	t.Run("with overlay leaves the processor alone", func(t *testing.T) {
+		q, err := p.WithOverlay(urlprocessor.Overlay{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, err := q.Process(t.Context(), "https://old.example/a", string(urlprocessor.OpRedirection), urlprocessor.Options{})
+		if err != nil || res.URL != "https://configured.example/a" {
+			t.Errorf("got %q, %v; want the configured mapping", res.URL, err)
+		}
+
+		res, err = p.Process(t.Context(), "https://old.example/a", string(urlprocessor.OpRedirection), urlprocessor.Options{})
+		if err != nil || res.URL != "https://new.example/a" {
+			t.Errorf("got %q, %v; want the overlay of the processor", res.URL, err)
+		}
+	})
+}
+
+func TestURLProcessor_SetOverlayConcurrently(t *testing.T) {
+	p, err := urlprocessor.New(urlprocessor.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overlays := []urlprocessor.Overlay{
+		{Hosts: map[string]string{"a.example": "b.example"}},
+		{Hosts: map[string]string{"a.example": "c.example"}},
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := range 1000 {
+			if err := p.SetOverlay(overlays[i%2]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for range 1000 {
+			res, err := p.Process(t.Context(), "https://a.example/", string(urlprocessor.OpRedirection), urlprocessor.Options{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.URL != "https://a.example/" && res.URL != "https://b.example/" && res.URL != "https://c.example/" {
+				t.Errorf("got %q", res.URL)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestValidatePathRewrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		r     urlprocessor.PathRewrite
+		valid bool
+	}{
+		{"groups", urlprocessor.PathRewrite{Pattern: `^/(a)/(?P<rest>.*)$`, Replacement: "/$1/${rest}/${2}"}, true},
+		{"dollar", urlprocessor.PathRewrite{Pattern: `^/price$`, Replacement: "/$$"}, true},
+		{"host pattern", urlprocessor.PathRewrite{Host: "*.example.com", Pattern: `^/`, Replacement: "/"}, true},
+		{"empty pattern", urlprocessor.PathRewrite{Replacement: "/"}, false},
+		{"bad pattern", urlprocessor.PathRewrite{Pattern: `^/(`, Replacement: "/"}, false},
+		{"missing group", urlprocessor.PathRewrite{Pattern: `^/(a)$`, Replacement: "/$2"}, false},
+		{"missing name", urlprocessor.PathRewrite{Pattern: `^/(a)$`, Replacement: "/${name}"}, false},
+		{"digits then letters", urlprocessor.PathRewrite{Pattern: `^/(a)$`, Replacement: "/$1x"}, false},
+		{"unterminated", urlprocessor.PathRewrite{Pattern: `^/(a)$`, Replacement: "/${1"}, false},
+		{"bad host", urlprocessor.PathRewrite{Host: "bad host", Pattern: `^/`, Replacement: "/"}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := urlprocessor.ValidatePathRewrite(tc.r); (err == nil) != tc.valid {
+				t.Errorf("got %v, want valid %t", err, tc.valid)
+			}
+		})
+	}
+}
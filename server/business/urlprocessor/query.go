@@ -0,0 +1,183 @@
+package urlprocessor
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultQueryPolicyName is reported for URLs whose host has no query rule.
+const DefaultQueryPolicyName = "default"
+
+// TrackingParams are the query parameters added by analytics and advertising
+// platforms, which the default query policy removes. A trailing "*" matches
+// any parameter with that prefix.
+var TrackingParams = []string{
+	"utm_*",
+	"gclid", "gclsrc", "dclid", "gbraid", "wbraid",
+	"fbclid", "msclkid", "yclid", "twclid", "ttclid", "li_fat_id", "igshid",
+	"mc_cid", "mc_eid",
+	"_ga", "_gl", "_hsenc", "_hsmi", "mkt_tok",
+}
+
+// QueryPolicy decides which query parameters canonicalization keeps. The
+// parameters kept are sorted by name, so equivalent URLs come out the same.
+// Names are matched without regard to case, and a trailing "*" matches any
+// name with that prefix.
+type QueryPolicy struct {
+	// Allow, when not empty, lists the only parameters kept.
+	Allow []string
+
+	// Deny lists parameters removed even when allowed.
+	Deny []string
+
+	// DropAll removes the whole query.
+	DropAll bool
+}
+
+// QueryRules picks the query policy for a URL by its host. Hosts are keyed
+// as in Profile.Hosts.
+type QueryRules struct {
+	Default QueryPolicy
+	Hosts   map[string]QueryPolicy
+}
+
+// queryPolicy is a QueryPolicy ready to filter queries.
+type queryPolicy struct {
+	name    string
+	allow   []string
+	deny    []string
+	dropAll bool
+}
+
+type queryRules struct {
+	def   *queryPolicy
+	hosts hostTable[*queryPolicy]
+}
+
+func newQueryRules(r QueryRules) (*queryRules, error) {
+	def, err := newQueryPolicy(DefaultQueryPolicyName, r.Default)
+	if err != nil {
+		return nil, fmt.Errorf("default query policy: %w", err)
+	}
+
+	rules := queryRules{def: def}
+
+	for source, p := range r.Hosts {
+		if err := ValidateSource(source); err != nil {
+			return nil, fmt.Errorf("query policy: %w", err)
+		}
+
+		policy, err := newQueryPolicy(strings.ToLower(source), p)
+		if err != nil {
+			return nil, fmt.Errorf("query policy for %q: %w", source, err)
+		}
+		rules.hosts.add(source, policy)
+	}
+	rules.hosts.sort()
+
+	return &rules, nil
+}
+
+// ValidateQueryPolicy reports whether p lists valid parameter names and
+// prefixes.
+func ValidateQueryPolicy(p QueryPolicy) error {
+	_, err := newQueryPolicy("", p)
+	return err
+}
+
+func newQueryPolicy(name string, p QueryPolicy) (*queryPolicy, error) {
+	allow, err := paramPatterns(p.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow: %w", err)
+	}
+	deny, err := paramPatterns(p.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny: %w", err)
+	}
+
+	return &queryPolicy{name: name, allow: allow, deny: deny, dropAll: p.DropAll}, nil
+}
+
+func paramPatterns(params []string) ([]string, error) {
+	patterns := make([]string, len(params))
+
+	for i, param := range params {
+		if name := strings.TrimSuffix(param, "*"); name == "" || strings.Contains(name, "*") {
+			return nil, fmt.Errorf("%q is not a parameter name or a prefix*", param)
+		}
+		patterns[i] = strings.ToLower(param)
+	}
+
+	return patterns, nil
+}
+
+// policy returns the query policy for a URL with the given host.
+func (r *queryRules) policy(host string) *queryPolicy {
+	if _, policy, ok := r.hosts.match(host); ok {
+		return policy
+	}
+	return r.def
+}
+
+// apply filters and sorts the parameters of rawQuery, keeping the encoding of
+// those it keeps.
+func (p *queryPolicy) apply(rawQuery string) string {
+	if p.dropAll || rawQuery == "" {
+		return ""
+	}
+
+	type param struct {
+		name string
+		raw  string
+	}
+
+	var params []param
+	for raw := range strings.SplitSeq(rawQuery, "&") {
+		if raw == "" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(raw, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+
+		if p.keeps(name) {
+			params = append(params, param{name: name, raw: raw})
+		}
+	}
+
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].name < params[j].name
+	})
+
+	kept := make([]string, len(params))
+	for i, param := range params {
+		kept[i] = param.raw
+	}
+	return strings.Join(kept, "&")
+}
+
+func (p *queryPolicy) keeps(name string) bool {
+	name = strings.ToLower(name)
+
+	if len(p.allow) > 0 && !matchParam(p.allow, name) {
+		return false
+	}
+	return !matchParam(p.deny, name)
+}
+
+func matchParam(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,283 @@
+package urlprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Defaults for the zero fields of ResolveConfig.
+const (
+	DefaultResolveMaxHops = 10
+	DefaultResolveTimeout = 10 * time.Second
+	DefaultResolveMethod  = http.MethodHead
+)
+
+// ResolveConfig sets how the resolve rule follows redirects.
+type ResolveConfig struct {
+	// MaxHops is the number of redirects followed before giving up.
+	MaxHops int
+
+	// Timeout bounds the whole chain of requests.
+	Timeout time.Duration
+
+	// Method is HEAD, which falls back to GET for servers that reject it, or
+	// GET.
+	Method string
+
+	// AllowedNetworks are exempt from the blocking of private, loopback and
+	// link-local addresses.
+	AllowedNetworks []netip.Prefix
+}
+
+// Hop is a request made while resolving a URL.
+type Hop struct {
+	URL    string
+	Method string
+	Status int
+}
+
+// resolver follows the redirects of URLs over HTTP. Its client refuses, at
+// dial time, to connect to addresses requests from outside must not reach,
+// whatever name they were found under.
+type resolver struct {
+	client  *http.Client
+	maxHops int
+	timeout time.Duration
+	method  string
+}
+
+func newResolver(c ResolveConfig) (*resolver, error) {
+	r := resolver{maxHops: c.MaxHops, timeout: c.Timeout, method: strings.ToUpper(c.Method)}
+
+	if r.maxHops == 0 {
+		r.maxHops = DefaultResolveMaxHops
+	}
+	if r.timeout == 0 {
+		r.timeout = DefaultResolveTimeout
+	}
+	if r.method == "" {
+		r.method = DefaultResolveMethod
+	}
+
+	if r.maxHops < 0 {
+		return nil, errors.New("resolve: max hops must not be negative")
+	}
+	if r.timeout < 0 {
+		return nil, errors.New("resolve: timeout must not be negative")
+	}
+	if err := ValidateResolveMethod(r.method); err != nil {
+		return nil, fmt.Errorf("resolve: %w", err)
+	}
+
+	dialer := net.Dialer{
+		Timeout: r.timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			return checkAddress(address, c.AllowedNetworks)
+		},
+	}
+
+	r.client = &http.Client{
+		Transport: &http.Transport{
+			// No proxy, which would connect on our behalf unchecked.
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: r.timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &r, nil
+}
+
+// ValidateResolveMethod reports whether method may be used to resolve URLs.
+func ValidateResolveMethod(method string) error {
+	switch strings.ToUpper(method) {
+	case http.MethodHead, http.MethodGet:
+		return nil
+	default:
+		return fmt.Errorf("unsupported method %q (use HEAD or GET)", method)
+	}
+}
+
+// ParseNetworks parses CIDR prefixes or single addresses, as given on the
+// command line, for ResolveConfig.AllowedNetworks.
+func ParseNetworks(networks []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return nil, fmt.Errorf("%q is not an address or a CIDR prefix", network)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// forbiddenNetworks are special-purpose ranges, beyond the ones netip.Addr
+// has predicates for, that requests from outside must not reach.
+var forbiddenNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // shared address space, e.g. carrier-grade NAT and cloud metadata
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, and the broadcast address
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+}
+
+// Prefixes of IPv6 addresses that carry an IPv4 address a gateway forwards
+// to: well-known NAT64 in the last 32 bits, 6to4 in bits 16 to 48.
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// checkAddress refuses the private, loopback, link-local, unspecified,
+// multicast and other special-purpose addresses outside allowed, including
+// IPv4 addresses embedded in NAT64 and 6to4 addresses.
+func checkAddress(address string, allowed []netip.Prefix) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %q", ErrForbiddenAddress, address)
+	}
+	addr := addrPort.Addr().Unmap()
+
+	if forbiddenAddress(addr, allowed) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, addr)
+	}
+	return nil
+}
+
+func forbiddenAddress(addr netip.Addr, allowed []netip.Prefix) bool {
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	if embedded, ok := embeddedIPv4(addr); ok {
+		return forbiddenAddress(embedded, allowed)
+	}
+
+	for _, prefix := range forbiddenNetworks {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsUnspecified() || addr.IsMulticast()
+}
+
+// embeddedIPv4 returns the IPv4 address a NAT64 or 6to4 address leads to.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	b := addr.As16()
+
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	default:
+		return netip.Addr{}, false
+	}
+}
+
+// resolve follows the redirects of u and returns the requests made, the last
+// of which is for the URL u ends up at.
+func (r *resolver) resolve(ctx context.Context, u *url.URL) ([]Hop, *url.URL, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var chain []Hop
+	for {
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return chain, nil, fmt.Errorf("%w: unsupported scheme %q", ErrResolve, u.Scheme)
+		}
+
+		method, res, err := r.fetch(ctx, u)
+		if err != nil {
+			if errors.Is(err, ErrForbiddenAddress) {
+				return chain, nil, fmt.Errorf("%w: %s", ErrForbiddenAddress, u.Host)
+			}
+			return chain, nil, fmt.Errorf("%w: %w", ErrResolve, err)
+		}
+		chain = append(chain, Hop{URL: u.String(), Method: method, Status: res.StatusCode})
+
+		location := res.Header.Get("Location")
+		if !isRedirect(res.StatusCode) || location == "" {
+			return chain, u, nil
+		}
+
+		if len(chain) > r.maxHops {
+			return chain, nil, fmt.Errorf("%w: more than %d redirects", ErrResolve, r.maxHops)
+		}
+
+		next, err := u.Parse(location)
+		if err != nil {
+			return chain, nil, fmt.Errorf("%w: bad Location %q: %w", ErrResolve, location, err)
+		}
+		u = next
+	}
+}
+
+// fetch requests u with the configured method, retrying a HEAD request with
+// GET when the server does not support HEAD. The body is not read.
+func (r *resolver) fetch(ctx context.Context, u *url.URL) (string, *http.Response, error) {
+	method := r.method
+
+	res, err := r.do(ctx, method, u)
+	if err == nil && method == http.MethodHead &&
+		(res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented) {
+		method = http.MethodGet
+		res, err = r.do(ctx, method, u)
+	}
+
+	return method, res, err
+}
+
+func (r *resolver) do(ctx context.Context, method string, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drain a little of the body so the connection may be reused.
+	_, _ = io.CopyN(io.Discard, res.Body, 4096)
+	res.Body.Close()
+
+	return res, nil
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
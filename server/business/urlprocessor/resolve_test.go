@@ -0,0 +1,238 @@
+package urlprocessor_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+)
+
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+
+func TestURLProcessor_Resolve(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle?x=1", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/end", http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/get-only", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.Redirect(w, r, "/end", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	mux.HandleFunc("/missing", http.NotFound)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	p, err := urlprocessor.New(urlprocessor.Config{
+		Resolve: urlprocessor.ResolveConfig{MaxHops: 3, Timeout: 200 * time.Millisecond, AllowedNetworks: loopback},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		want      string
+		wantChain []urlprocessor.Hop
+		wantErr   error
+	}{
+		{
+			name: "chain",
+			path: "/start",
+			want: srv.URL + "/end",
+			wantChain: []urlprocessor.Hop{
+				{URL: srv.URL + "/start", Method: http.MethodHead, Status: http.StatusMovedPermanently},
+				{URL: srv.URL + "/middle?x=1", Method: http.MethodHead, Status: http.StatusTemporaryRedirect},
+				{URL: srv.URL + "/end", Method: http.MethodHead, Status: http.StatusOK},
+			},
+		},
+		{
+			name: "head falls back to get",
+			path: "/get-only",
+			want: srv.URL + "/end",
+			wantChain: []urlprocessor.Hop{
+				{URL: srv.URL + "/get-only", Method: http.MethodGet, Status: http.StatusFound},
+				{URL: srv.URL + "/end", Method: http.MethodHead, Status: http.StatusOK},
+			},
+		},
+		{
+			name: "final status reported",
+			path: "/missing",
+			want: srv.URL + "/missing",
+			wantChain: []urlprocessor.Hop{
+				{URL: srv.URL + "/missing", Method: http.MethodHead, Status: http.StatusNotFound},
+			},
+		},
+		{
+			name:    "too many redirects",
+			path:    "/loop",
+			wantErr: urlprocessor.ErrResolve,
+		},
+		{
+			name:    "timeout",
+			path:    "/slow",
+			wantErr: urlprocessor.ErrResolve,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := p.Process(t.Context(), srv.URL+tc.path, string(urlprocessor.OpResolve), urlprocessor.Options{})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("got error %v, want %v", err, tc.wantErr)
+			}
+			if res.URL != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, res.URL)
+			}
+			if !reflect.DeepEqual(res.Chain, tc.wantChain) {
+				t.Errorf("got chain %+v, want %+v", res.Chain, tc.wantChain)
+			}
+		})
+	}
+}
+
+func TestURLProcessor_ResolveBlocksPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://10.0.0.1/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	p, err := urlprocessor.New(urlprocessor.Config{Resolve: urlprocessor.ResolveConfig{Timeout: time.Second}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, rawURL := range []string{
+		srv.URL,
+		"http://localhost/",
+		"http://[::1]/",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://192.168.1.1/",
+		"http://[::ffff:127.0.0.1]/",
+		"http://0.0.0.0/",
+	} {
+		_, err := p.Process(t.Context(), rawURL, string(urlprocessor.OpResolve), urlprocessor.Options{})
+		if !errors.Is(err, urlprocessor.ErrForbiddenAddress) {
+			t.Errorf("resolving %s: got error %v, want %v", rawURL, err, urlprocessor.ErrForbiddenAddress)
+		}
+	}
+
+	// Allowing the test server's network lets the redirect to a private
+	// address be caught on the next hop.
+	p, err = urlprocessor.New(urlprocessor.Config{Resolve: urlprocessor.ResolveConfig{Timeout: time.Second, AllowedNetworks: loopback}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.Apply(t.Context(), srv.URL, []string{urlprocessor.RuleResolve}, urlprocessor.Options{})
+	if !errors.Is(err, urlprocessor.ErrForbiddenAddress) {
+		t.Errorf("got error %v, want %v", err, urlprocessor.ErrForbiddenAddress)
+	}
+	if res.URL != "" {
+		t.Errorf("got result %+v for a blocked URL", res)
+	}
+}
+
+func TestURLProcessor_ResolveBlocksSpecialPurposeAddresses(t *testing.T) {
+	p, err := urlprocessor.New(urlprocessor.Config{
+		Resolve: urlprocessor.ResolveConfig{
+			Timeout:         200 * time.Millisecond,
+			AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("100.64.1.0/24")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		url       string
+		forbidden bool
+	}{
+		{name: "shared address space", url: "http://100.64.0.1/", forbidden: true},
+		{name: "cloud metadata in shared address space", url: "http://100.100.100.200/latest/meta-data/", forbidden: true},
+		{name: "benchmarking", url: "http://198.18.0.1/", forbidden: true},
+		{name: "benchmarking upper half", url: "http://198.19.255.254/", forbidden: true},
+		{name: "reserved", url: "http://240.0.0.1/", forbidden: true},
+		{name: "broadcast", url: "http://255.255.255.255/", forbidden: true},
+		{name: "this network", url: "http://0.1.2.3/", forbidden: true},
+		{name: "IETF protocol assignments", url: "http://192.0.0.8/", forbidden: true},
+		{name: "NAT64 embedding a private address", url: "http://[64:ff9b::a00:1]/", forbidden: true},
+		{name: "NAT64 embedding loopback", url: "http://[64:ff9b::7f00:1]/", forbidden: true},
+		{name: "NAT64 embedding cloud metadata", url: "http://[64:ff9b::a9fe:a9fe]/", forbidden: true},
+		{name: "local-use NAT64", url: "http://[64:ff9b:1::808:808]/", forbidden: true},
+		{name: "6to4 embedding a private address", url: "http://[2002:c0a8:101::1]/", forbidden: true},
+		{name: "6to4 embedding loopback", url: "http://[2002:7f00:1::]/", forbidden: true},
+		{name: "allowed network", url: "http://100.64.1.1/"},
+		{name: "NAT64 embedding an allowed address", url: "http://[64:ff9b::6440:101]/"},
+		{name: "NAT64 embedding a public address", url: "http://[64:ff9b::808:808]/"},
+		{name: "6to4 embedding a public address", url: "http://[2002:808:808::1]/"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Addresses that are let through are dialled and fail to
+			// connect in some other way, or time out.
+			_, err := p.Process(t.Context(), tc.url, string(urlprocessor.OpResolve), urlprocessor.Options{})
+			if forbidden := errors.Is(err, urlprocessor.ErrForbiddenAddress); forbidden != tc.forbidden {
+				t.Errorf("got error %v, want forbidden %t", err, tc.forbidden)
+			}
+		})
+	}
+}
+
+func TestNew_InvalidResolveConfig(t *testing.T) {
+	for _, c := range []urlprocessor.ResolveConfig{
+		{Method: "POST"},
+		{MaxHops: -1},
+		{Timeout: -time.Second},
+	} {
+		if _, err := urlprocessor.New(urlprocessor.Config{Resolve: c}); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	got, err := urlprocessor.ParseNetworks([]string{"10.1.2.3/8", "192.168.0.7", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.0.7/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if _, err := urlprocessor.ParseNetworks([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an error for a bad prefix")
+	}
+}
@@ -0,0 +1,190 @@
+package urlprocessor
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Rule is a single step of a pipeline. It changes u in place.
+type Rule interface {
+	Apply(u *url.URL, s *State) error
+}
+
+// RuleFunc adapts an ordinary function to a Rule.
+type RuleFunc func(u *url.URL, s *State) error
+
+// Apply calls f(u, s).
+func (f RuleFunc) Apply(u *url.URL, s *State) error {
+	return f(u, s)
+}
+
+// State is shared by the rules of a single pipeline run.
+type State struct {
+	// TargetHost is the host the request asked to redirect to, if any.
+	TargetHost string
+
+	// QueryPolicy is set by rules that filter the query and reported in the
+	// Result.
+	QueryPolicy string
+
+	// Chain is set by rules that follow redirects and reported in the Result.
+	Chain []Hop
+
+	ctx      context.Context
+	profile  *hostMap
+	query    *queryRules
+	rewrites []pathRewrite
+	resolver *resolver
+}
+
+// Context returns the context of the run, which bounds any request a rule
+// makes.
+func (s *State) Context() context.Context {
+	return s.ctx
+}
+
+// Names of the built-in rules.
+const (
+	RuleStripTracking     = "strip_tracking"      // filter the query with the host's query policy
+	RuleDropQuery         = "drop_query"          // remove the whole query
+	RuleDropFragment      = "drop_fragment"       // remove the fragment
+	RuleTrimTrailingSlash = "trim_trailing_slash" // remove slashes ending the path
+	RuleLowercaseScheme   = "lowercase_scheme"    // lowercase the scheme
+	RuleLowercaseHost     = "lowercase_host"      // lowercase the host
+	RuleLowercasePath     = "lowercase_path"      // lowercase the path, outside case sensitive prefixes
+	RuleForceScheme       = "force_scheme"        // apply the profile's scheme
+	RuleForceHost         = "force_host"          // map the host with the profile, or use the target host
+	RuleNormalize         = "normalize"           // apply the RFC 3986 normalizations
+	RuleResolve           = "resolve"             // follow HTTP redirects to the final URL
+	RuleRewritePath       = "rewrite_path"        // apply the first matching path rewrite of the overlay
+)
+
+var builtinRules = map[string]Rule{
+	RuleStripTracking:     RuleFunc(stripTracking),
+	RuleDropQuery:         RuleFunc(dropQuery),
+	RuleDropFragment:      RuleFunc(dropFragment),
+	RuleTrimTrailingSlash: RuleFunc(trimTrailingSlash),
+	RuleLowercaseScheme:   RuleFunc(lowercaseScheme),
+	RuleLowercaseHost:     RuleFunc(lowercaseHost),
+	RuleLowercasePath:     RuleFunc(lowercasePath),
+	RuleForceScheme:       RuleFunc(forceScheme),
+	RuleForceHost:         RuleFunc(forceHost),
+	RuleNormalize:         RuleFunc(normalize),
+	RuleResolve:           RuleFunc(resolve),
+	RuleRewritePath:       RuleFunc(rewritePath),
+}
+
+var (
+	canonicalPipeline   = []string{RuleStripTracking, RuleDropFragment, RuleTrimTrailingSlash}
+	redirectionPipeline = []string{RuleLowercaseScheme, RuleForceScheme, RuleForceHost, RuleLowercasePath, RuleRewritePath}
+)
+
+// pipelines are the rules each operation runs.
+var pipelines = map[Operation][]string{
+	OpCanonical:   canonicalPipeline,
+	OpRedirection: redirectionPipeline,
+	OpAll:         append(append([]string{}, canonicalPipeline...), redirectionPipeline...),
+	OpNormalize:   {RuleNormalize},
+	OpResolve:     {RuleResolve},
+}
+
+var ruleName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+func stripTracking(u *url.URL, s *State) error {
+	policy := s.query.policy(u.Host)
+	s.QueryPolicy = policy.name
+
+	u.RawQuery = policy.apply(u.RawQuery)
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+	}
+	return nil
+}
+
+func dropQuery(u *url.URL, _ *State) error {
+	u.RawQuery = ""
+	u.ForceQuery = false
+	return nil
+}
+
+func dropFragment(u *url.URL, _ *State) error {
+	u.Fragment = ""
+	u.RawFragment = ""
+	return nil
+}
+
+func trimTrailingSlash(u *url.URL, _ *State) error {
+	return setEscapedPath(u, strings.TrimRight(u.EscapedPath(), "/"))
+}
+
+func lowercaseScheme(u *url.URL, _ *State) error {
+	u.Scheme = strings.ToLower(u.Scheme)
+	return nil
+}
+
+func lowercaseHost(u *url.URL, _ *State) error {
+	u.Host = strings.ToLower(u.Host)
+	return nil
+}
+
+// lowercasePath works on the escaped path, so escapes such as %2F survive.
+func lowercasePath(u *url.URL, s *State) error {
+	return setEscapedPath(u, s.profile.lowerPath(u.Host, u.EscapedPath()))
+}
+
+func forceScheme(u *url.URL, s *State) error {
+	if s.profile.scheme != "" {
+		u.Scheme = s.profile.scheme
+	}
+	return nil
+}
+
+func forceHost(u *url.URL, s *State) error {
+	if s.TargetHost != "" {
+		u.Host = lowerASCIIHost(s.TargetHost)
+		return nil
+	}
+
+	u.Host = s.profile.lookup(u.Host)
+	return nil
+}
+
+// rewritePath applies the first path rewrite matching the host and path of u.
+func rewritePath(u *url.URL, s *State) error {
+	for _, rw := range s.rewrites {
+		if p, ok := rw.rewrite(u.Host, u.EscapedPath()); ok {
+			if u.Host != "" && p != "" && !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("rewritten path %q does not start with /", p)
+			}
+			return setEscapedPath(u, p)
+		}
+	}
+	return nil
+}
+
+// resolve replaces u with the URL its redirects end at.
+func resolve(u *url.URL, s *State) error {
+	chain, final, err := s.resolver.resolve(s.ctx, u)
+	s.Chain = chain
+	if err != nil {
+		return err
+	}
+
+	*u = *final
+	return nil
+}
+
+// setEscapedPath sets the path of u from its escaped form p, keeping the
+// escapes p uses.
+func setEscapedPath(u *url.URL, p string) error {
+	path, err := url.PathUnescape(p)
+	if err != nil {
+		return fmt.Errorf("path %q: %w", p, err)
+	}
+
+	u.Path, u.RawPath = path, p
+	return nil
+}
@@ -0,0 +1,209 @@
+package urlprocessor
+
+import (
+	"net"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"golang.org/x/text/unicode/norm"
+)
+
+// asciiHost converts the internationalized labels of host, which may carry a
+// port, to their punycode form as used in DNS. An ASCII host is returned as it
+// is, keeping its case.
+func asciiHost(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, ""
+	}
+
+	name, err = idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", err
+	}
+
+	if port != "" {
+		return net.JoinHostPort(name, port), nil
+	}
+	return name, nil
+}
+
+// lowerASCIIHost returns host in lowercase ASCII, converting internationalized
+// labels when it can. It is for hosts that have already been validated.
+func lowerASCIIHost(host string) string {
+	if ascii, err := asciiHost(host); err == nil {
+		host = ascii
+	}
+	return strings.ToLower(host)
+}
+
+// unicodeHost is the inverse of asciiHost, for display. Labels that are not
+// valid punycode are left as they are.
+func unicodeHost(host string) string {
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		name, port = host, ""
+	}
+
+	if unicode, err := idna.Display.ToUnicode(name); err == nil {
+		name = unicode
+	}
+
+	if port != "" {
+		return net.JoinHostPort(name, port)
+	}
+	return name
+}
+
+// unicodeURL returns u in its display form: the host in Unicode and the
+// percent-encoded UTF-8 of its path, query and fragment decoded. Escapes of
+// ASCII characters stay escaped, as they may be significant.
+func unicodeURL(u *url.URL) string {
+	var b strings.Builder
+
+	if u.Scheme != "" {
+		b.WriteString(u.Scheme)
+		b.WriteByte(':')
+	}
+
+	if u.Opaque != "" {
+		b.WriteString(u.Opaque)
+	} else {
+		if u.Host != "" || u.User != nil {
+			b.WriteString("//")
+			if u.User != nil {
+				b.WriteString(u.User.String())
+				b.WriteByte('@')
+			}
+			b.WriteString(unicodeHost(u.Host))
+		}
+		b.WriteString(decodeUTF8Escapes(u.EscapedPath()))
+	}
+
+	if u.ForceQuery || u.RawQuery != "" {
+		b.WriteByte('?')
+		b.WriteString(decodeUTF8Escapes(u.RawQuery))
+	}
+
+	if u.Fragment != "" {
+		b.WriteByte('#')
+		b.WriteString(decodeUTF8Escapes(u.EscapedFragment()))
+	}
+
+	return b.String()
+}
+
+// lowerPath lowercases the escaped path p with Unicode case mapping and puts
+// the result in Unicode normalization form C. Percent-encoded UTF-8 is
+// decoded to be lowercased and encoded again with uppercase hex digits, while
+// escapes of ASCII characters, such as %2F, are kept exactly as they are.
+func lowerPath(p string) string {
+	var b strings.Builder
+	b.Grow(len(p))
+
+	var run []byte // literal characters and decoded non-ASCII bytes
+	flush := func() {
+		if len(run) == 0 {
+			return
+		}
+		if utf8.Valid(run) {
+			writeEscaped(&b, norm.NFC.String(cases.Lower(language.Und).String(string(run))))
+		} else {
+			// Lowercase only the ASCII letters, leaving invalid bytes as they are.
+			for i, c := range run {
+				if 'A' <= c && c <= 'Z' {
+					run[i] = c + 'a' - 'A'
+				}
+			}
+			writeEscaped(&b, string(run))
+		}
+		run = run[:0]
+	}
+
+	for i := 0; i < len(p); i++ {
+		if p[i] == '%' && i+2 < len(p) && isHex(p[i+1]) && isHex(p[i+2]) {
+			if c := unhex(p[i+1])<<4 | unhex(p[i+2]); c >= utf8.RuneSelf {
+				run = append(run, c)
+				i += 2
+				continue
+			}
+
+			flush()
+			b.WriteString(p[i : i+3])
+			i += 2
+			continue
+		}
+
+		run = append(run, p[i])
+	}
+	flush()
+
+	return b.String()
+}
+
+// writeEscaped writes s, percent-encoding its non-ASCII bytes.
+func writeEscaped(b *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= utf8.RuneSelf {
+			b.WriteString(percentEncode(c))
+		} else {
+			b.WriteByte(c)
+		}
+	}
+}
+
+// decodeUTF8Escapes decodes the percent-encoded sequences in s that form
+// valid non-ASCII UTF-8, leaving every other escape alone.
+func decodeUTF8Escapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for i := 0; i < len(s); {
+		var seq []byte
+		j := i
+		for j+2 < len(s) && s[j] == '%' && isHex(s[j+1]) && isHex(s[j+2]) {
+			c := unhex(s[j+1])<<4 | unhex(s[j+2])
+			if c < utf8.RuneSelf {
+				break
+			}
+			seq = append(seq, c)
+			j += 3
+		}
+
+		if len(seq) == 0 {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+
+		if utf8.Valid(seq) {
+			b.Write(seq)
+		} else {
+			b.WriteString(s[i:j])
+		}
+		i = j
+	}
+
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
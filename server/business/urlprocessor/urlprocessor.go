@@ -1,14 +1,23 @@
 package urlprocessor
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"net/url"
-	"strings"
+	"slices"
+	"sort"
+	"sync/atomic"
 )
 
 var (
 	ErrInvalidOperation = errors.New("invalid operation type")
+	ErrInvalidURL       = errors.New("invalid URL")
+	ErrUnknownProfile   = errors.New("unknown profile")
+	ErrUnknownRule      = errors.New("unknown rule")
+	ErrResolve          = errors.New("could not resolve URL")
+	ErrForbiddenAddress = errors.New("forbidden address")
 )
 
 type Operation string
@@ -17,53 +26,183 @@ const (
 	OpCanonical   Operation = "canonical"
 	OpRedirection Operation = "redirection"
 	OpAll         Operation = "all"
+	OpNormalize   Operation = "normalize"
+	OpResolve     Operation = "resolve"
 )
 
-type URLProcessor struct{}
+type URLProcessor struct {
+	base     Config
+	resolver *resolver
+	rules    map[string]Rule
+	current  atomic.Pointer[snapshot]
+}
+
+// Result is a processed URL.
+type Result struct {
+	URL string
+
+	// QueryPolicy names the query policy canonicalization followed: the host
+	// key of the matching query rule, or DefaultQueryPolicyName. It is empty
+	// for operations that leave the query alone.
+	QueryPolicy string
 
-func New() *URLProcessor {
-	return &URLProcessor{}
+	// UnicodeURL is URL with its host in Unicode and its percent-encoded
+	// UTF-8 decoded, when Options.Unicode asked for it.
+	UnicodeURL string
+
+	// Trace lists every rule applied, in order, when Options.Explain asked
+	// for it.
+	Trace []Step
+
+	// Chain lists the requests made by the resolve rule, the last of which is
+	// for the URL the redirects end at.
+	Chain []Hop
 }
 
-func (p *URLProcessor) Process(rawURL string, op string) (string, error) {
-	parsed, err := url.Parse(rawURL)
+// New validates cfg and returns a processor that follows it.
+func New(cfg Config) (*URLProcessor, error) {
+	resolver, err := newResolver(cfg.Resolve)
 	if err != nil {
-		return "", fmt.Errorf("invalid URL: %w", err)
+		return nil, err
 	}
 
-	operation := Operation(op)
+	p := URLProcessor{
+		base:     Config{Default: cfg.Default, Profiles: maps.Clone(cfg.Profiles), Query: cfg.Query},
+		resolver: resolver,
+		rules:    make(map[string]Rule, len(builtinRules)+len(cfg.Rules)),
+	}
 
-	switch operation {
-	case OpCanonical:
-		return processCanonical(parsed), nil
-	case OpRedirection:
-		return processRedirection(parsed), nil
-	case OpAll:
-		canonicalized := processCanonical(parsed)
-		cleanedParsed, err := url.Parse(canonicalized)
-		if err != nil {
-			return "", fmt.Errorf("canonicalized URL parse failed: %w", err)
+	for name, rule := range builtinRules {
+		p.rules[name] = rule
+	}
+	for name, rule := range cfg.Rules {
+		if !ruleName.MatchString(name) {
+			return nil, fmt.Errorf("rule name %q must be made of lowercase letters, digits and underscores", name)
+		}
+		if _, ok := builtinRules[name]; ok {
+			return nil, fmt.Errorf("rule %q is built in", name)
+		}
+		if rule == nil {
+			return nil, fmt.Errorf("rule %q is nil", name)
 		}
-		return processRedirection(cleanedParsed), nil
-	default:
-		return "", fmt.Errorf("unsupported operation type")
+		p.rules[name] = rule
 	}
+
+	if _, ok := cfg.Profiles[""]; ok {
+		return nil, errors.New("profiles must be named")
+	}
+
+	if err := p.SetOverlay(Overlay{}); err != nil {
+		return nil, err
+	}
+
+	return &p, nil
 }
 
-func processCanonical(u *url.URL) string {
-	u.RawQuery = ""
-	u.Fragment = ""
-	u.Path = strings.TrimRight(u.Path, "/")
-	return u.String()
+// Profiles returns the names of the configured profiles, sorted.
+func (p *URLProcessor) Profiles() []string {
+	profiles := p.current.Load().profiles
+
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
-func processRedirection(u *url.URL) string {
-	u.Scheme = strings.ToLower(u.Scheme)
+// Rules returns the names of the rules pipelines may use, sorted.
+func (p *URLProcessor) Rules() []string {
+	names := make([]string, 0, len(p.rules))
+	for name := range p.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
-	// Force domain to www.byfood.com
-	u.Host = "www.byfood.com"
+// Pipeline returns the rules the operation op runs, in order.
+func Pipeline(op string) ([]string, error) {
+	rules, ok := pipelines[Operation(op)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidOperation, op)
+	}
+	return slices.Clone(rules), nil
+}
 
-	u.Path = strings.ToLower(u.Path)
+// Process runs the pipeline of the operation op on rawURL.
+func (p *URLProcessor) Process(ctx context.Context, rawURL string, op string, opts Options) (Result, error) {
+	rules, err := Pipeline(op)
+	if err != nil {
+		return Result{}, err
+	}
+	return p.Apply(ctx, rawURL, rules, opts)
+}
+
+// Apply runs the named rules on rawURL, in order. ctx bounds the rules that
+// make requests.
+func (p *URLProcessor) Apply(ctx context.Context, rawURL string, rules []string, opts Options) (Result, error) {
+	current := p.current.Load()
+
+	state := State{
+		TargetHost: opts.TargetHost,
+		ctx:        ctx,
+		profile:    current.defaultHosts,
+		query:      current.query,
+		rewrites:   current.rewrites,
+		resolver:   p.resolver,
+	}
+	if opts.Profile != "" {
+		var ok bool
+		if state.profile, ok = current.profiles[opts.Profile]; !ok {
+			return Result{}, fmt.Errorf("%w: %q", ErrUnknownProfile, opts.Profile)
+		}
+	}
+
+	pipeline := make([]Rule, len(rules))
+	for i, name := range rules {
+		rule, ok := p.rules[name]
+		if !ok {
+			return Result{}, fmt.Errorf("%w: %q", ErrUnknownRule, name)
+		}
+		pipeline[i] = rule
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Result{}, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+
+	// Internationalized hosts are handled in their punycode form throughout.
+	if u.Host, err = asciiHost(u.Host); err != nil {
+		return Result{}, fmt.Errorf("%w: host: %w", ErrInvalidURL, err)
+	}
+
+	var trace []Step
+	for i, rule := range pipeline {
+		var before url.URL
+		if opts.Explain {
+			before = *u
+		}
+
+		if err := rule.Apply(u, &state); err != nil {
+			return Result{}, fmt.Errorf("rule %s: %w", rules[i], err)
+		}
+
+		if opts.Explain {
+			trace = append(trace, Step{
+				Rule:    rules[i],
+				Before:  before.String(),
+				After:   u.String(),
+				Changed: changed(&before, u),
+			})
+		}
+	}
+
+	res := Result{URL: u.String(), QueryPolicy: state.QueryPolicy, Trace: trace, Chain: state.Chain}
+	if opts.Unicode {
+		res.UnicodeURL = unicodeURL(u)
+	}
 
-	return u.String()
+	return res, nil
 }
@@ -0,0 +1,59 @@
+package urlrule
+
+import (
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/google/uuid"
+)
+
+// Kind is what a rule changes about the URLs it applies to.
+type Kind string
+
+// Set of rule kinds.
+const (
+	KindHost  Kind = "host"  // redirect Host to TargetHost
+	KindQuery Kind = "query" // filter the query of URLs on Host with Query
+	KindPath  Kind = "path"  // rewrite paths on Host matching Pattern with Replacement
+)
+
+// Rule represents information about a stored URL rule. Which fields apply
+// depends on its Kind.
+type Rule struct {
+	ID          uuid.UUID
+	Kind        Kind
+	Host        string
+	TargetHost  string
+	Query       urlprocessor.QueryPolicy
+	Pattern     string
+	Replacement string
+	Priority    int
+	Description string
+	DateCreated time.Time
+	DateUpdated time.Time
+	Version     int
+}
+
+// NewRule holds data required to create a new rule.
+type NewRule struct {
+	Kind        Kind
+	Host        string
+	TargetHost  string
+	Query       urlprocessor.QueryPolicy
+	Pattern     string
+	Replacement string
+	Priority    int
+	Description string
+}
+
+// UpdateRule holds data required to update an existing rule. The kind of a
+// rule never changes.
+type UpdateRule struct {
+	Host        *string
+	TargetHost  *string
+	Query       *urlprocessor.QueryPolicy
+	Pattern     *string
+	Replacement *string
+	Priority    *int
+	Description *string
+}
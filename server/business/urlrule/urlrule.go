@@ -0,0 +1,474 @@
+// Package urlrule provides support for URL rules kept in the database: host
+// mappings, query policies and path rewrites the URL processor follows on top
+// of its configuration.
+package urlrule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/Babatunde50/book-crud/server/internal/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Babatunde50/book-crud/server/business/urlrule")
+
+// Set of error variables for CRUD operations.
+var (
+	ErrNotFound    = errors.New("url rule not found")
+	ErrConflict    = errors.New("a rule of this kind already exists for the host")
+	ErrInvalidRule = errors.New("invalid url rule")
+)
+
+// Lengths beyond which rule fields are refused.
+const (
+	maxHostLength        = 253 + len("*.")
+	maxPatternLength     = 1024
+	maxReplacementLength = 1024
+	maxDescriptionLength = 500
+)
+
+// FieldErrors maps the fields of an invalid rule to what is wrong with them.
+type FieldErrors map[string]string
+
+func (fe FieldErrors) Error() string {
+	fields := make([]string, 0, len(fe))
+	for field := range fe {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	msgs := make([]string, len(fields))
+	for i, field := range fields {
+		msgs[i] = field + ": " + fe[field]
+	}
+	return fmt.Sprintf("%s: %s", ErrInvalidRule, strings.Join(msgs, "; "))
+}
+
+func (fe FieldErrors) Unwrap() error {
+	return ErrInvalidRule
+}
+
+// Storer defines the behavior the urlrule package expects from the data store
+// layer.
+type Storer interface {
+	Create(ctx context.Context, rule Rule) error
+	Update(ctx context.Context, rule Rule) error
+	Delete(ctx context.Context, ruleID uuid.UUID) error
+	QueryByID(ctx context.Context, ruleID uuid.UUID) (Rule, error)
+	QueryAll(ctx context.Context) ([]Rule, error)
+}
+
+// Core manages the set of APIs for URL rule access, and keeps the URL
+// processor following the stored rules.
+type Core struct {
+	log       *slog.Logger
+	tran      database.Transactor
+	storer    Storer
+	processor *urlprocessor.URLProcessor
+
+	// mu serializes reloads, so that rules read later are always applied
+	// later.
+	mu       sync.Mutex
+	revision string
+}
+
+// NewCore constructs a core for URL rule access. Changes run through tran
+// together with a check that the stored rules still make a valid overlay for
+// processor, which follows them once Reload is called.
+func NewCore(log *slog.Logger, tran database.Transactor, storer Storer, processor *urlprocessor.URLProcessor) *Core {
+	return &Core{
+		log:       log,
+		tran:      tran,
+		storer:    storer,
+		processor: processor,
+	}
+}
+
+// Create adds a new rule and applies it.
+func (c *Core) Create(ctx context.Context, nr NewRule) (_ Rule, err error) {
+	ctx, span := tracer.Start(ctx, "urlrule.Create")
+	defer func() { tracing.End(span, err) }()
+
+	now := time.Now()
+
+	rule := Rule{
+		ID:          uuid.New(),
+		Kind:        nr.Kind,
+		Host:        strings.ToLower(nr.Host),
+		TargetHost:  nr.TargetHost,
+		Query:       nr.Query,
+		Pattern:     nr.Pattern,
+		Replacement: nr.Replacement,
+		Priority:    nr.Priority,
+		Description: nr.Description,
+		DateCreated: now,
+		DateUpdated: now,
+		Version:     1,
+	}
+
+	if err := Validate(rule); err != nil {
+		return Rule{}, err
+	}
+
+	err = c.change(ctx, func(ctx context.Context) error {
+		return c.storer.Create(ctx, rule)
+	})
+	if err != nil {
+		return Rule{}, fmt.Errorf("create: %w", err)
+	}
+
+	span.SetAttributes(ruleIDAttr(rule.ID))
+	c.log.InfoContext(ctx, "url rule created", "rule_id", rule.ID, "kind", rule.Kind, "host", rule.Host)
+
+	return rule, nil
+}
+
+// UpdateByID reads a rule and applies ur to it in a single transaction, then
+// applies the changed rule.
+func (c *Core) UpdateByID(ctx context.Context, ruleID uuid.UUID, ur UpdateRule) (_ Rule, err error) {
+	ctx, span := tracer.Start(ctx, "urlrule.UpdateByID", trace.WithAttributes(ruleIDAttr(ruleID)))
+	defer func() { tracing.End(span, err) }()
+
+	var updated Rule
+
+	err = c.change(ctx, func(ctx context.Context) error {
+		rule, err := c.storer.QueryByID(ctx, ruleID)
+		if err != nil {
+			return err
+		}
+
+		rule = apply(rule, ur)
+		rule.DateUpdated = time.Now()
+		rule.Version++
+
+		if err := Validate(rule); err != nil {
+			return err
+		}
+
+		if err := c.storer.Update(ctx, rule); err != nil {
+			return err
+		}
+
+		updated = rule
+		return nil
+	})
+	if err != nil {
+		return Rule{}, fmt.Errorf("update: id[%s]: %w", ruleID, err)
+	}
+
+	c.log.InfoContext(ctx, "url rule updated", "rule_id", updated.ID, "version", updated.Version)
+
+	return updated, nil
+}
+
+// Delete removes a rule and stops applying it.
+func (c *Core) Delete(ctx context.Context, ruleID uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "urlrule.Delete", trace.WithAttributes(ruleIDAttr(ruleID)))
+	defer func() { tracing.End(span, err) }()
+
+	err = c.change(ctx, func(ctx context.Context) error {
+		return c.storer.Delete(ctx, ruleID)
+	})
+	if err != nil {
+		return fmt.Errorf("delete: id[%s]: %w", ruleID, err)
+	}
+
+	c.log.InfoContext(ctx, "url rule deleted", "rule_id", ruleID)
+	return nil
+}
+
+// QueryByID finds a rule by its ID.
+func (c *Core) QueryByID(ctx context.Context, ruleID uuid.UUID) (_ Rule, err error) {
+	ctx, span := tracer.Start(ctx, "urlrule.QueryByID", trace.WithAttributes(ruleIDAttr(ruleID)))
+	defer func() { tracing.End(span, err) }()
+
+	rule, err := c.storer.QueryByID(ctx, ruleID)
+	if err != nil {
+		return Rule{}, fmt.Errorf("query: id[%s]: %w", ruleID, err)
+	}
+	return rule, nil
+}
+
+// QueryAll returns all rules, path rewrites in the order they are tried.
+func (c *Core) QueryAll(ctx context.Context) (_ []Rule, err error) {
+	ctx, span := tracer.Start(ctx, "urlrule.QueryAll")
+	defer func() { tracing.End(span, err) }()
+
+	rules, err := c.storer.QueryAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("query all: %w", err)
+	}
+	return rules, nil
+}
+
+// Preview returns a processor following the stored rules with a change made:
+// nr added, or in place of the rule with ID replaces when that is set; with
+// nr nil the rule replaced is only removed. Nothing is stored.
+func (c *Core) Preview(ctx context.Context, replaces uuid.UUID, nr *NewRule) (_ *urlprocessor.URLProcessor, err error) {
+	ctx, span := tracer.Start(ctx, "urlrule.Preview")
+	defer func() { tracing.End(span, err) }()
+
+	rules, err := c.storer.QueryAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("preview: %w", err)
+	}
+
+	var proposed Rule
+	if nr != nil {
+		proposed = Rule{
+			Kind:        nr.Kind,
+			Host:        strings.ToLower(nr.Host),
+			TargetHost:  nr.TargetHost,
+			Query:       nr.Query,
+			Pattern:     nr.Pattern,
+			Replacement: nr.Replacement,
+			Priority:    nr.Priority,
+			Description: nr.Description,
+			DateCreated: time.Now(),
+		}
+		if err := Validate(proposed); err != nil {
+			return nil, err
+		}
+	}
+
+	changed := make([]Rule, 0, len(rules)+1)
+	found := replaces == uuid.Nil
+	for _, rule := range rules {
+		if rule.ID == replaces && replaces != uuid.Nil {
+			// A replacement keeps the place of the rule among path rewrites.
+			proposed.ID, proposed.DateCreated = rule.ID, rule.DateCreated
+			found = true
+			continue
+		}
+		if nr != nil && rule.Kind == proposed.Kind && rule.Kind != KindPath && rule.Host == proposed.Host {
+			return nil, ErrConflict
+		}
+		changed = append(changed, rule)
+	}
+	if !found {
+		return nil, fmt.Errorf("preview: id[%s]: %w", replaces, ErrNotFound)
+	}
+	if nr != nil {
+		changed = append(changed, proposed)
+	}
+
+	p, err := c.processor.WithOverlay(toOverlay(changed))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidRule, err)
+	}
+	return p, nil
+}
+
+// Reload reads the stored rules and makes the processor follow them, when
+// they changed since the last reload. It reports whether they did.
+func (c *Core) Reload(ctx context.Context) (_ bool, err error) {
+	ctx, span := tracer.Start(ctx, "urlrule.Reload")
+	defer func() { tracing.End(span, err) }()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Reading in a transaction sends the query to the primary, so a reload
+	// never goes back to rules a lagging replica still has.
+	var rules []Rule
+	err = c.tran.WithinTran(ctx, func(ctx context.Context) error {
+		rules, err = c.storer.QueryAll(ctx)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("reload: %w", err)
+	}
+
+	revision := revisionOf(rules)
+	if revision == c.revision {
+		return false, nil
+	}
+
+	if err := c.processor.SetOverlay(toOverlay(rules)); err != nil {
+		return false, fmt.Errorf("reload: %w: %w", ErrInvalidRule, err)
+	}
+	c.revision = revision
+
+	span.SetAttributes(attribute.Int("urlrule.count", len(rules)))
+	c.log.InfoContext(ctx, "url rules reloaded", "count", len(rules))
+
+	return true, nil
+}
+
+// change runs write in a transaction that only commits if the stored rules
+// still make a valid overlay, then reloads them.
+func (c *Core) change(ctx context.Context, write func(ctx context.Context) error) error {
+	err := c.tran.WithinTran(ctx, func(ctx context.Context) error {
+		if err := write(ctx); err != nil {
+			return err
+		}
+
+		rules, err := c.storer.QueryAll(ctx)
+		if err != nil {
+			return err
+		}
+
+		if _, err := c.processor.WithOverlay(toOverlay(rules)); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRule, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The change is stored; a failed reload is retried by the next one.
+	if _, err := c.Reload(ctx); err != nil {
+		c.log.ErrorContext(ctx, "reloading url rules", "error", err)
+	}
+	return nil
+}
+
+// Validate checks that rule sets the fields of its kind, and only those, with
+// values the URL processor accepts. It returns FieldErrors.
+func Validate(rule Rule) error {
+	fe := FieldErrors{}
+
+	check := func(ok bool, field, msg string) {
+		if _, exists := fe[field]; !ok && !exists {
+			fe[field] = msg
+		}
+	}
+
+	check(len(rule.Host) <= maxHostLength, "host", "must not be more than 255 bytes long")
+	check(len(rule.TargetHost) <= maxHostLength, "target_host", "must not be more than 255 bytes long")
+	check(len(rule.Pattern) <= maxPatternLength, "pattern", "must not be more than 1024 bytes long")
+	check(len(rule.Replacement) <= maxReplacementLength, "replacement", "must not be more than 1024 bytes long")
+	check(len(rule.Description) <= maxDescriptionLength, "description", "must not be more than 500 bytes long")
+
+	hasQuery := len(rule.Query.Allow) > 0 || len(rule.Query.Deny) > 0 || rule.Query.DropAll
+
+	switch rule.Kind {
+	case KindHost:
+		check(rule.Host != "", "host", "must be provided")
+		check(rule.TargetHost != "", "target_host", "must be provided")
+		check(rule.TargetHost == "" || urlprocessor.ValidateHost(rule.TargetHost) == nil, "target_host", "must be a host name, optionally with a port")
+		check(!hasQuery, "query", "must not be given for host rules")
+		check(rule.Pattern == "", "pattern", "must not be given for host rules")
+		check(rule.Replacement == "", "replacement", "must not be given for host rules")
+	case KindQuery:
+		check(rule.Host != "", "host", "must be provided")
+		check(hasQuery, "query", "must allow, deny or drop parameters")
+		check(urlprocessor.ValidateQueryPolicy(rule.Query) == nil, "query", "must list parameter names, or prefixes ending in *")
+		check(rule.TargetHost == "", "target_host", "must not be given for query rules")
+		check(rule.Pattern == "", "pattern", "must not be given for query rules")
+		check(rule.Replacement == "", "replacement", "must not be given for query rules")
+	case KindPath:
+		check(rule.Pattern != "", "pattern", "must be provided")
+		if rule.Pattern != "" {
+			err := urlprocessor.ValidatePathRewrite(urlprocessor.PathRewrite{Pattern: rule.Pattern})
+			check(err == nil, "pattern", fmt.Sprint(err))
+			if err == nil {
+				err = urlprocessor.ValidatePathRewrite(urlprocessor.PathRewrite{Pattern: rule.Pattern, Replacement: rule.Replacement})
+				check(err == nil, "replacement", fmt.Sprint(err))
+			}
+		}
+		check(rule.TargetHost == "", "target_host", "must not be given for path rules")
+		check(!hasQuery, "query", "must not be given for path rules")
+	default:
+		fe["kind"] = "must be one of 'host', 'query' or 'path'"
+	}
+
+	if rule.Host != "" {
+		check(urlprocessor.ValidateSource(rule.Host) == nil, "host", "must be a host name or a *.domain pattern")
+	}
+
+	if len(fe) > 0 {
+		return fe
+	}
+	return nil
+}
+
+func apply(rule Rule, ur UpdateRule) Rule {
+	if ur.Host != nil {
+		rule.Host = strings.ToLower(*ur.Host)
+	}
+	if ur.TargetHost != nil {
+		rule.TargetHost = *ur.TargetHost
+	}
+	if ur.Query != nil {
+		rule.Query = *ur.Query
+	}
+	if ur.Pattern != nil {
+		rule.Pattern = *ur.Pattern
+	}
+	if ur.Replacement != nil {
+		rule.Replacement = *ur.Replacement
+	}
+	if ur.Priority != nil {
+		rule.Priority = *ur.Priority
+	}
+	if ur.Description != nil {
+		rule.Description = *ur.Description
+	}
+	return rule
+}
+
+// toOverlay converts rules to the overlay of the URL processor. Path rewrites
+// are tried by ascending priority, then in the order they were created.
+func toOverlay(rules []Rule) urlprocessor.Overlay {
+	o := urlprocessor.Overlay{
+		Hosts: map[string]string{},
+		Query: map[string]urlprocessor.QueryPolicy{},
+	}
+
+	var paths []Rule
+	for _, rule := range rules {
+		switch rule.Kind {
+		case KindHost:
+			o.Hosts[rule.Host] = rule.TargetHost
+		case KindQuery:
+			o.Query[rule.Host] = rule.Query
+		case KindPath:
+			paths = append(paths, rule)
+		}
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		if paths[i].Priority != paths[j].Priority {
+			return paths[i].Priority < paths[j].Priority
+		}
+		return paths[i].DateCreated.Before(paths[j].DateCreated)
+	})
+
+	for _, rule := range paths {
+		o.PathRewrites = append(o.PathRewrites, urlprocessor.PathRewrite{
+			Host:        rule.Host,
+			Pattern:     rule.Pattern,
+			Replacement: rule.Replacement,
+		})
+	}
+
+	return o
+}
+
+// revisionOf identifies a set of rules by the versions of its rules.
+func revisionOf(rules []Rule) string {
+	ids := make([]string, len(rules))
+	for i, rule := range rules {
+		ids[i] = fmt.Sprintf("%s@%d", rule.ID, rule.Version)
+	}
+	sort.Strings(ids)
+	return strings.Join(ids, ",")
+}
+
+func ruleIDAttr(ruleID uuid.UUID) attribute.KeyValue {
+	return attribute.String("urlrule.id", ruleID.String())
+}
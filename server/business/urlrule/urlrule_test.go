@@ -0,0 +1,246 @@
+package urlrule_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/business/urlrule"
+	"github.com/Babatunde50/book-crud/server/business/urlrule/urlruledb"
+	"github.com/Babatunde50/book-crud/server/internal/dbtest"
+	"github.com/google/uuid"
+)
+
+func Test_URLRule_CRUD(t *testing.T) {
+	c, err := dbtest.StartDB(t)
+	if err != nil {
+		t.Fatalf("starting test DB: %v", err)
+	}
+	defer dbtest.StopDB(c)
+
+	test := dbtest.NewTest(t, c)
+	defer func() {
+		if r := recover(); r != nil {
+			t.Log(r)
+			t.Error(string(debug.Stack()))
+		}
+		test.Teardown()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	processor, err := urlprocessor.New(urlprocessor.Config{
+		Default: urlprocessor.Profile{DefaultHost: "www.byfood.com"},
+	})
+	if err != nil {
+		t.Fatalf("creating url processor: %v", err)
+	}
+
+	core := urlrule.NewCore(slog.New(slog.NewTextHandler(io.Discard, nil)), test.DB, urlruledb.New(test.DB), processor)
+
+	redirect := func(rawURL string) string {
+		t.Helper()
+
+		res, err := processor.Process(ctx, rawURL, string(urlprocessor.OpRedirection), urlprocessor.Options{})
+		if err != nil {
+			t.Fatalf("\t\tShould be able to process %q: %s", rawURL, err)
+		}
+		return res.URL
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("Given the need to manage URL rules")
+
+	t.Log("\tWhen creating a host rule")
+	hostRule, err := core.Create(ctx, urlrule.NewRule{Kind: urlrule.KindHost, Host: "Old.Example", TargetHost: "new.example"})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a rule: %s", err)
+	}
+
+	if hostRule.Host != "old.example" {
+		t.Errorf("\t\tShould store the host in lower case: got %q", hostRule.Host)
+	}
+	if got := redirect("https://old.example/a"); got != "https://new.example/a" {
+		t.Errorf("\t\tShould apply the rule at once: got %q", got)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen creating a second host rule for the same host")
+	_, err = core.Create(ctx, urlrule.NewRule{Kind: urlrule.KindHost, Host: "old.example", TargetHost: "other.example"})
+	if !errors.Is(err, urlrule.ErrConflict) {
+		t.Errorf("\t\tExpected ErrConflict, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen creating an invalid rule")
+	_, err = core.Create(ctx, urlrule.NewRule{Kind: urlrule.KindPath, Pattern: `^/(a)$`, Replacement: "/$2", TargetHost: "x.example"})
+
+	var fe urlrule.FieldErrors
+	if !errors.As(err, &fe) {
+		t.Fatalf("\t\tExpected FieldErrors, got %v", err)
+	}
+	if _, ok := fe["replacement"]; !ok {
+		t.Errorf("\t\tShould refuse a reference to a missing group: got %v", fe)
+	}
+	if _, ok := fe["target_host"]; !ok {
+		t.Errorf("\t\tShould refuse fields of other kinds: got %v", fe)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen creating path rules")
+	_, err = core.Create(ctx, urlrule.NewRule{Kind: urlrule.KindPath, Pattern: `^/items/`, Replacement: "/catalog/", Priority: 10})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a rule: %s", err)
+	}
+	pathRule, err := core.Create(ctx, urlrule.NewRule{Kind: urlrule.KindPath, Host: "new.example", Pattern: `^/items/(\d+)$`, Replacement: "/products/$1"})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to create a rule: %s", err)
+	}
+
+	if got := redirect("https://old.example/items/42"); got != "https://new.example/products/42" {
+		t.Errorf("\t\tShould try the rule of lower priority first: got %q", got)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen previewing a change")
+	replacement := "/p/$1"
+	preview, err := core.Preview(ctx, pathRule.ID, &urlrule.NewRule{Kind: urlrule.KindPath, Host: "new.example", Pattern: pathRule.Pattern, Replacement: replacement})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to preview a change: %s", err)
+	}
+
+	res, err := preview.Process(ctx, "https://old.example/items/42", string(urlprocessor.OpRedirection), urlprocessor.Options{})
+	if err != nil || res.URL != "https://new.example/p/42" {
+		t.Errorf("\t\tShould follow the changed rule: got %q, %v", res.URL, err)
+	}
+	if got := redirect("https://old.example/items/42"); got != "https://new.example/products/42" {
+		t.Errorf("\t\tShould leave the processor alone: got %q", got)
+	}
+
+	if _, err := core.Preview(ctx, uuid.Nil, &urlrule.NewRule{Kind: urlrule.KindHost, Host: "old.example", TargetHost: "x.example"}); !errors.Is(err, urlrule.ErrConflict) {
+		t.Errorf("\t\tExpected ErrConflict, got %v", err)
+	}
+	if _, err := core.Preview(ctx, uuid.New(), nil); !errors.Is(err, urlrule.ErrNotFound) {
+		t.Errorf("\t\tExpected ErrNotFound, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen updating a rule")
+	updated, err := core.UpdateByID(ctx, pathRule.ID, urlrule.UpdateRule{Replacement: &replacement})
+	if err != nil {
+		t.Fatalf("\t\tShould be able to update a rule: %s", err)
+	}
+
+	if updated.Version != 2 {
+		t.Errorf("\t\tShould bump the version: got %d", updated.Version)
+	}
+	if got := redirect("https://old.example/items/42"); got != "https://new.example/p/42" {
+		t.Errorf("\t\tShould apply the update at once: got %q", got)
+	}
+
+	got, err := core.QueryByID(ctx, pathRule.ID)
+	if err != nil {
+		t.Fatalf("\t\tShould be able to query the rule: %s", err)
+	}
+	if got.Replacement != replacement || got.Version != 2 {
+		t.Errorf("\t\tShould store the update: got %+v", got)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen an update makes a rule invalid")
+	bad := "/$9"
+	if _, err := core.UpdateByID(ctx, pathRule.ID, urlrule.UpdateRule{Replacement: &bad}); !errors.Is(err, urlrule.ErrInvalidRule) {
+		t.Errorf("\t\tExpected ErrInvalidRule, got %v", err)
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen deleting a rule")
+	if err := core.Delete(ctx, hostRule.ID); err != nil {
+		t.Fatalf("\t\tShould be able to delete a rule: %s", err)
+	}
+
+	if got := redirect("https://old.example/a"); got != "https://www.byfood.com/a" {
+		t.Errorf("\t\tShould stop applying the rule at once: got %q", got)
+	}
+	if err := core.Delete(ctx, hostRule.ID); !errors.Is(err, urlrule.ErrNotFound) {
+		t.Errorf("\t\tExpected ErrNotFound, got %v", err)
+	}
+
+	rules, err := core.QueryAll(ctx)
+	if err != nil {
+		t.Fatalf("\t\tShould be able to query all rules: %s", err)
+	}
+	if len(rules) != 2 {
+		t.Errorf("\t\tGot %d rules, want 2", len(rules))
+	}
+
+	// ---------------------------------------------------------------------
+
+	t.Log("\tWhen reloading unchanged rules")
+	changed, err := core.Reload(ctx)
+	if err != nil {
+		t.Fatalf("\t\tShould be able to reload: %s", err)
+	}
+	if changed {
+		t.Errorf("\t\tShould report the rules as unchanged")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   urlrule.Rule
+		fields []string
+	}{
+		{"host", urlrule.Rule{Kind: urlrule.KindHost, Host: "*.old.example", TargetHost: "new.example:8080"}, nil},
+		{"query", urlrule.Rule{Kind: urlrule.KindQuery, Host: "shop.example", Query: urlprocessor.QueryPolicy{Allow: []string{"id", "page*"}}}, nil},
+		{"path on every host", urlrule.Rule{Kind: urlrule.KindPath, Pattern: `^/(?P<lang>en|ja)/`, Replacement: "/${lang}/"}, nil},
+		{"unknown kind", urlrule.Rule{Kind: "cookie"}, []string{"kind"}},
+		{"host without target", urlrule.Rule{Kind: urlrule.KindHost, Host: "old.example"}, []string{"target_host"}},
+		{"bad host", urlrule.Rule{Kind: urlrule.KindHost, Host: "old example", TargetHost: "new.example/a"}, []string{"host", "target_host"}},
+		{"empty query", urlrule.Rule{Kind: urlrule.KindQuery, Host: "shop.example"}, []string{"query"}},
+		{"query with pattern", urlrule.Rule{Kind: urlrule.KindQuery, Host: "shop.example", Query: urlprocessor.QueryPolicy{DropAll: true}, Pattern: "^/"}, []string{"pattern"}},
+		{"bad pattern", urlrule.Rule{Kind: urlrule.KindPath, Pattern: `^/(`}, []string{"pattern"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := urlrule.Validate(tc.rule)
+
+			var fields []string
+			var fe urlrule.FieldErrors
+			if errors.As(err, &fe) {
+				for field := range fe {
+					fields = append(fields, field)
+				}
+				sort.Strings(fields)
+			} else if err != nil {
+				t.Fatalf("got %v, want FieldErrors", err)
+			}
+
+			if len(fields) != len(tc.fields) {
+				t.Fatalf("got errors for %v, want %v", fields, tc.fields)
+			}
+			for i := range fields {
+				if fields[i] != tc.fields[i] {
+					t.Fatalf("got errors for %v, want %v", fields, tc.fields)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package urlruledb
+
+import (
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/business/urlrule"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// dbRule represents how a URL rule is stored in the database.
+type dbRule struct {
+	ID           uuid.UUID      `db:"id"`
+	Kind         string         `db:"kind"`
+	Host         string         `db:"host"`
+	TargetHost   string         `db:"target_host"`
+	QueryAllow   pq.StringArray `db:"query_allow"`
+	QueryDeny    pq.StringArray `db:"query_deny"`
+	QueryDropAll bool           `db:"query_drop_all"`
+	Pattern      string         `db:"pattern"`
+	Replacement  string         `db:"replacement"`
+	Priority     int            `db:"priority"`
+	Description  string         `db:"description"`
+	DateCreated  time.Time      `db:"date_created"`
+	DateUpdated  time.Time      `db:"date_updated"`
+	Version      int            `db:"version"`
+}
+
+// toCoreRule converts a dbRule to the core urlrule.Rule type.
+func toCoreRule(db dbRule) urlrule.Rule {
+	return urlrule.Rule{
+		ID:         db.ID,
+		Kind:       urlrule.Kind(db.Kind),
+		Host:       db.Host,
+		TargetHost: db.TargetHost,
+		Query: urlprocessor.QueryPolicy{
+			Allow:   db.QueryAllow,
+			Deny:    db.QueryDeny,
+			DropAll: db.QueryDropAll,
+		},
+		Pattern:     db.Pattern,
+		Replacement: db.Replacement,
+		Priority:    db.Priority,
+		Description: db.Description,
+		DateCreated: db.DateCreated,
+		DateUpdated: db.DateUpdated,
+		Version:     db.Version,
+	}
+}
+
+// toDBRule converts urlrule.Rule to the dbRule type for database storage.
+func toDBRule(rule urlrule.Rule) dbRule {
+	return dbRule{
+		ID:         rule.ID,
+		Kind:       string(rule.Kind),
+		Host:       rule.Host,
+		TargetHost: rule.TargetHost,
+		// The columns are NOT NULL, which a nil array would be stored as.
+		QueryAllow:   append(pq.StringArray{}, rule.Query.Allow...),
+		QueryDeny:    append(pq.StringArray{}, rule.Query.Deny...),
+		QueryDropAll: rule.Query.DropAll,
+		Pattern:      rule.Pattern,
+		Replacement:  rule.Replacement,
+		Priority:     rule.Priority,
+		Description:  rule.Description,
+		DateCreated:  rule.DateCreated,
+		DateUpdated:  rule.DateUpdated,
+		Version:      rule.Version,
+	}
+}
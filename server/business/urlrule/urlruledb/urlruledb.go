@@ -0,0 +1,144 @@
+package urlruledb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/Babatunde50/book-crud/server/business/urlrule"
+	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// codeUniqueViolation is the PostgreSQL error code for a duplicate key.
+const codeUniqueViolation = "23505"
+
+type Store struct {
+	db *database.DB
+}
+
+// New creates a new urlruledb store that satisfies the urlrule.Storer interface.
+func New(db *database.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create inserts a new rule into the database.
+func (s *Store) Create(ctx context.Context, rule urlrule.Rule) error {
+	const query = `-- name=urlruledb.Create
+		INSERT INTO url_rules (
+			id, kind, host, target_host, query_allow, query_deny, query_drop_all,
+			pattern, replacement, priority, description, date_created, date_updated, version
+		)
+		VALUES (
+			:id, :kind, :host, :target_host, :query_allow, :query_deny, :query_drop_all,
+			:pattern, :replacement, :priority, :description, :date_created, :date_updated, :version
+		)`
+
+	if _, err := s.db.NamedExecContext(ctx, query, toDBRule(rule)); err != nil {
+		return conflict(err)
+	}
+
+	return nil
+}
+
+// Update modifies an existing rule.
+func (s *Store) Update(ctx context.Context, rule urlrule.Rule) error {
+	const query = `-- name=urlruledb.Update
+		UPDATE url_rules SET
+			host = :host,
+			target_host = :target_host,
+			query_allow = :query_allow,
+			query_deny = :query_deny,
+			query_drop_all = :query_drop_all,
+			pattern = :pattern,
+			replacement = :replacement,
+			priority = :priority,
+			description = :description,
+			date_updated = :date_updated,
+			version = :version
+		WHERE id = :id AND version = :version - 1`
+
+	result, err := s.db.NamedExecContext(ctx, query, toDBRule(rule))
+	if err != nil {
+		return conflict(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return urlrule.ErrNotFound
+	}
+
+	return nil
+}
+
+// Delete removes a rule by its ID.
+func (s *Store) Delete(ctx context.Context, id uuid.UUID) error {
+	const query = `-- name=urlruledb.Delete
+		DELETE FROM url_rules WHERE id = $1`
+
+	result, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return urlrule.ErrNotFound
+	}
+
+	return nil
+}
+
+// QueryByID retrieves a rule by its ID, from a replica when one is available.
+func (s *Store) QueryByID(ctx context.Context, id uuid.UUID) (urlrule.Rule, error) {
+	const query = `-- name=urlruledb.QueryByID
+		SELECT * FROM url_rules WHERE id = $1`
+
+	var dbRule dbRule
+	if err := s.db.Reader(ctx).GetContext(ctx, &dbRule, query, id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return urlrule.Rule{}, urlrule.ErrNotFound
+		}
+
+		return urlrule.Rule{}, err
+	}
+
+	return toCoreRule(dbRule), nil
+}
+
+// QueryAll retrieves all rules, path rewrites in the order they are tried,
+// from a replica when one is available.
+func (s *Store) QueryAll(ctx context.Context) ([]urlrule.Rule, error) {
+	const query = `-- name=urlruledb.QueryAll
+		SELECT * FROM url_rules ORDER BY kind, priority, date_created, id`
+
+	var dbRules []dbRule
+	if err := s.db.Reader(ctx).SelectContext(ctx, &dbRules, query); err != nil {
+		return nil, err
+	}
+
+	rules := make([]urlrule.Rule, len(dbRules))
+	for i, dbRule := range dbRules {
+		rules[i] = toCoreRule(dbRule)
+	}
+
+	return rules, nil
+}
+
+// conflict reports a duplicate host as urlrule.ErrConflict.
+func conflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == codeUniqueViolation {
+		return urlrule.ErrConflict
+	}
+	return err
+}
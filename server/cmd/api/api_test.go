@@ -2,25 +2,46 @@ package main
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/Babatunde50/book-crud/server/business/book"
 	"github.com/Babatunde50/book-crud/server/business/book/bookdb"
+	"github.com/Babatunde50/book-crud/server/business/idempotency"
+	"github.com/Babatunde50/book-crud/server/business/idempotency/idempotencydb"
+	"github.com/Babatunde50/book-crud/server/business/shortlink"
+	"github.com/Babatunde50/book-crud/server/business/shortlink/shortlinkdb"
 	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/business/urlrule"
+	"github.com/Babatunde50/book-crud/server/business/urlrule/urlruledb"
 	"github.com/Babatunde50/book-crud/server/internal/database"
 	"github.com/Babatunde50/book-crud/server/internal/docker"
+	"github.com/Babatunde50/book-crud/server/internal/metrics"
+	"github.com/Babatunde50/book-crud/server/internal/ratelimit"
+	"github.com/Babatunde50/book-crud/server/internal/response"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type testApp struct {
+	app      *application
 	handler  http.Handler
 	teardown func()
 }
@@ -42,7 +63,7 @@ func setupTestApp(t *testing.T) *testApp {
 	var db *database.DB
 
 	for i := 0; i < 20; i++ {
-		db, err = database.New(dsn, true)
+		db, err = database.New(database.Config{DSN: dsn, Automigrate: true})
 		if err == nil {
 			break
 		}
@@ -55,12 +76,31 @@ func setupTestApp(t *testing.T) *testApp {
 	}
 
 	bookStore := bookdb.New(db)
-	bookCore := book.NewCore(bookStore)
 	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	bookCore := book.NewCore(logger, db, bookStore)
+	idempotencyCore := idempotency.NewCore(idempotencydb.New(db), time.Hour)
+
+	urlProcessorCore, err := urlprocessor.New(urlprocessor.Config{
+		Default: urlprocessor.Profile{DefaultHost: "www.byfood.com"},
+		Query:   urlprocessor.QueryRules{Default: urlprocessor.QueryPolicy{Deny: urlprocessor.TrackingParams}},
+	})
+	if err != nil {
+		t.Fatalf("could not create url processor: %v", err)
+	}
+
+	shortlinkCore, err := shortlink.NewCore(logger, shortlinkdb.New(db), urlProcessorCore, shortlink.Config{})
+	if err != nil {
+		t.Fatalf("could not create short links: %v", err)
+	}
+
+	urlRuleCore := urlrule.NewCore(logger, db, urlruledb.New(db), urlProcessorCore)
 
 	app := &application{
 		bookCore:         bookCore,
-		urlProcessorCore: urlprocessor.New(),
+		urlProcessorCore: urlProcessorCore,
+		idempotencyCore:  idempotencyCore,
+		shortlinkCore:    shortlinkCore,
+		urlRuleCore:      urlRuleCore,
 		logger:           logger,
 		db:               db,
 	}
@@ -68,6 +108,7 @@ func setupTestApp(t *testing.T) *testApp {
 	h := app.routes()
 
 	return &testApp{
+		app:     app,
 		handler: h,
 		teardown: func() {
 			db.Close()
@@ -503,6 +544,17 @@ func Test_ProcessURLHandler(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:           "valid - normalize",
+			payload:        `{"url":"HTTP://Example.COM:80/a/./b/../%7euser","operation":"normalize"}`,
+			expectedStatus: http.StatusOK,
+			assert: func(t *testing.T, body string) {
+				expected := `http://example.com/a/~user`
+				if !strings.Contains(body, expected) {
+					t.Errorf("expected %q in response body, got: %s", expected, body)
+				}
+			},
+		},
 		{
 			name:           "invalid url",
 			payload:        `{"url":"bad-url","operation":"canonical"}`,
@@ -556,6 +608,1722 @@ func Test_ProcessURLHandler(t *testing.T) {
 	}
 }
 
+func Test_IdempotentCreateBook(t *testing.T) {
+	t.Parallel()
+	test := setupTestApp(t)
+	defer test.teardown()
+
+	key := uuid.NewString()
+
+	tests := []struct {
+		name           string
+		payload        string
+		expectedStatus int
+		replayed       bool
+	}{
+		{
+			name:           "first request",
+			payload:        `{"title":"Working Effectively with Legacy Code","author":"Michael Feathers","year":2004}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "retry with same payload",
+			payload:        `{"title":"Working Effectively with Legacy Code","author":"Michael Feathers","year":2004}`,
+			expectedStatus: http.StatusCreated,
+			replayed:       true,
+		},
+		{
+			name:           "same key with different payload",
+			payload:        `{"title":"Other","author":"Someone","year":2004}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	var firstBody string
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/books", strings.NewReader(tc.payload))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Idempotency-Key", key)
+			w := httptest.NewRecorder()
+
+			test.handler.ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			if res.StatusCode != tc.expectedStatus {
+				t.Errorf("got status %d, want %d", res.StatusCode, tc.expectedStatus)
+			}
+
+			if replayed := res.Header.Get("Idempotent-Replayed") == "true"; replayed != tc.replayed {
+				t.Errorf("got replayed %t, want %t", replayed, tc.replayed)
+			}
+
+			body, _ := io.ReadAll(res.Body)
+			switch {
+			case firstBody == "":
+				firstBody = string(body)
+			case tc.replayed && string(body) != firstBody:
+				t.Errorf("replayed body differs from original: got %s, want %s", body, firstBody)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	res := httptest.NewRecorder()
+	test.handler.ServeHTTP(res, req)
+
+	if n := strings.Count(res.Body.String(), "Working Effectively with Legacy Code"); n != 1 {
+		t.Errorf("expected exactly one book to be created, found %d", n)
+	}
+}
+
+func Test_ShortLinks(t *testing.T) {
+	t.Parallel()
+	test := setupTestApp(t)
+	defer test.teardown()
+
+	post := func(payload string) (int, ShortLinkResponse) {
+		r := httptest.NewRequest(http.MethodPost, "/shortlinks", strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		test.handler.ServeHTTP(w, r)
+
+		var resp ShortLinkResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp
+	}
+
+	follow := func(code string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/s/"+code, nil)
+		w := httptest.NewRecorder()
+
+		test.handler.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	status, link := post(`{"url":"https://Example.com/food/?utm_source=news#menu"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d creating a link, want %d", status, http.StatusCreated)
+	}
+	if link.URL != "https://example.com/food" || link.ShortURL != "/s/"+link.Code || !link.Permanent {
+		t.Errorf("unexpected link: %+v", link)
+	}
+
+	status, again := post(`{"url":"https://example.com/food"}`)
+	if status != http.StatusOK || again.Code != link.Code {
+		t.Errorf("got status %d and code %q for the same canonical URL, want %d and %q", status, again.Code, http.StatusOK, link.Code)
+	}
+
+	res := follow(link.Code)
+	if res.StatusCode != http.StatusMovedPermanently || res.Header.Get("Location") != link.URL {
+		t.Errorf("got %d to %q, want %d to %q", res.StatusCode, res.Header.Get("Location"), http.StatusMovedPermanently, link.URL)
+	}
+
+	expiresAt := time.Now().Add(time.Hour).Format(time.RFC3339)
+	status, alias := post(`{"url":"https://example.com/sale","alias":"spring-sale","expires_at":"` + expiresAt + `"}`)
+	if status != http.StatusCreated || alias.Code != "spring-sale" || alias.Permanent {
+		t.Fatalf("got status %d and link %+v creating an alias", status, alias)
+	}
+
+	if res := follow("spring-sale"); res.StatusCode != http.StatusFound {
+		t.Errorf("got %d following an expiring link, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	if status, _ := post(`{"url":"https://example.com/other","alias":"spring-sale"}`); status != http.StatusConflict {
+		t.Errorf("got status %d reusing an alias, want %d", status, http.StatusConflict)
+	}
+
+	for _, payload := range []string{
+		`{"url":"javascript:alert(1)"}`,
+		`{"url":"https://example.com","alias":"a/b"}`,
+		`{"url":"https://example.com","expires_at":"2001-01-01T00:00:00Z"}`,
+	} {
+		if status, _ := post(payload); status != http.StatusUnprocessableEntity {
+			t.Errorf("got status %d for %s, want %d", status, payload, http.StatusUnprocessableEntity)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/shortlinks/"+link.Code, nil)
+	w := httptest.NewRecorder()
+	test.handler.ServeHTTP(w, r)
+
+	var stats ShortLinkResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decoding stats: %v", err)
+	}
+	if stats.Hits != 1 {
+		t.Errorf("got %d hits, want 1", stats.Hits)
+	}
+
+	if res := follow("missing"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("got %d following a missing link, want %d", res.StatusCode, http.StatusNotFound)
+	}
+}
+
+func Test_HealthProbes(t *testing.T) {
+	t.Parallel()
+	test := setupTestApp(t)
+	defer test.teardown()
+
+	tests := []struct {
+		name           string
+		path           string
+		draining       bool
+		expectedStatus int
+		assert         func(t *testing.T, body string)
+	}{
+		{
+			name:           "liveness",
+			path:           "/healthz",
+			expectedStatus: http.StatusOK,
+			assert: func(t *testing.T, body string) {
+				if !strings.Contains(body, `"status": "OK"`) {
+					t.Errorf("expected OK status, got: %s", body)
+				}
+			},
+		},
+		{
+			name:           "readiness",
+			path:           "/readyz",
+			expectedStatus: http.StatusOK,
+			assert: func(t *testing.T, body string) {
+				var resp ReadinessResponse
+				if err := json.Unmarshal([]byte(body), &resp); err != nil {
+					t.Fatalf("could not decode response: %v", err)
+				}
+				if len(resp.Checks) != 3 {
+					t.Errorf("got %d checks, want 3", len(resp.Checks))
+				}
+				for _, check := range resp.Checks {
+					if check.Status != "pass" {
+						t.Errorf("expected check %q to pass, got %q (%s)", check.Name, check.Status, check.Error)
+					}
+				}
+			},
+		},
+		{
+			name:           "readiness while draining",
+			path:           "/readyz",
+			draining:       true,
+			expectedStatus: http.StatusServiceUnavailable,
+			assert: func(t *testing.T, body string) {
+				if !strings.Contains(body, "shutting down") {
+					t.Errorf("expected shutdown check to fail, got: %s", body)
+				}
+			},
+		},
+		{
+			name:           "liveness while draining",
+			path:           "/healthz",
+			draining:       true,
+			expectedStatus: http.StatusOK,
+			assert:         func(t *testing.T, body string) {},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			test.app.draining.Store(tc.draining)
+
+			r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			w := httptest.NewRecorder()
+
+			test.handler.ServeHTTP(w, r)
+
+			if w.Code != tc.expectedStatus {
+				t.Errorf("got status %d, want %d", w.Code, tc.expectedStatus)
+			}
+
+			tc.assert(t, w.Body.String())
+		})
+	}
+}
+
+func Test_ProblemResponses(t *testing.T) {
+	t.Parallel()
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		legacy       bool
+		method       string
+		path         string
+		expectedType string
+		assert       func(t *testing.T, body string)
+	}{
+		{
+			name:         "not found",
+			method:       http.MethodGet,
+			path:         "/does-not-exist",
+			expectedType: "application/problem+json",
+			assert: func(t *testing.T, body string) {
+				var p response.Problem
+				if err := json.Unmarshal([]byte(body), &p); err != nil {
+					t.Fatalf("could not decode problem: %v", err)
+				}
+				if p.Type != "about:blank" || p.Status != http.StatusNotFound || p.Instance != "/does-not-exist" {
+					t.Errorf("unexpected problem: %+v", p)
+				}
+				if !strings.Contains(p.Detail, "could not be found") {
+					t.Errorf("expected detail to explain the error, got %q", p.Detail)
+				}
+			},
+		},
+		{
+			name:         "method not allowed",
+			method:       http.MethodPatch,
+			path:         "/books",
+			expectedType: "application/problem+json",
+			assert: func(t *testing.T, body string) {
+				if !strings.Contains(body, `"status": 405`) {
+					t.Errorf("expected status member in body, got: %s", body)
+				}
+			},
+		},
+		{
+			name:         "legacy not found",
+			legacy:       true,
+			method:       http.MethodGet,
+			path:         "/does-not-exist",
+			expectedType: "application/json",
+			assert: func(t *testing.T, body string) {
+				if !strings.Contains(body, `"Error": "The requested resource could not be found"`) {
+					t.Errorf("expected legacy error body, got: %s", body)
+				}
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			app := &application{logger: logger}
+
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.legacy {
+				r.Header.Set(errorFormatHeader, "legacy")
+			}
+			w := httptest.NewRecorder()
+
+			app.routes().ServeHTTP(w, r)
+
+			res := w.Result()
+			if got := res.Header.Get("Content-Type"); got != tc.expectedType {
+				t.Errorf("got Content-Type %q, want %q", got, tc.expectedType)
+			}
+
+			body, _ := io.ReadAll(res.Body)
+			tc.assert(t, string(body))
+		})
+	}
+}
+
+func Test_RequestID(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	h := app.routes()
+
+	tests := []struct {
+		name      string
+		requestID string
+		reused    bool
+	}{
+		{name: "client supplied id", requestID: "client-req-42", reused: true},
+		{name: "missing id", requestID: ""},
+		{name: "unsafe id", requestID: "bad id\r\nX-Injected: 1"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/does-not-exist", nil)
+			if tc.requestID != "" {
+				r.Header.Set("X-Request-ID", tc.requestID)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			got := res.Header.Get("X-Request-ID")
+
+			switch {
+			case tc.reused && got != tc.requestID:
+				t.Errorf("got X-Request-ID %q, want %q", got, tc.requestID)
+			case !tc.reused && uuid.Validate(got) != nil:
+				t.Errorf("expected a generated UUID, got %q", got)
+			}
+
+			var p response.Problem
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("could not decode problem: %v", err)
+			}
+			if p.RequestID != got {
+				t.Errorf("got request_id %q in body, want %q", p.RequestID, got)
+			}
+		})
+	}
+}
+
+// Test_Tracing swaps the global tracer provider and must not run in parallel.
+func Test_Tracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+
+	prevTP, prevProp := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer func() {
+		otel.SetTracerProvider(prevTP)
+		otel.SetTextMapPropagator(prevProp)
+	}()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	h := app.routes()
+
+	const parentTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	r := httptest.NewRequest(http.MethodGet, "/status", nil)
+	r.Header.Set("traceparent", "00-"+parentTraceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	spans := sr.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+
+	span := spans[0]
+	if span.Name() != "GET /status" {
+		t.Errorf("got span name %q, want %q", span.Name(), "GET /status")
+	}
+	if span.SpanKind() != trace.SpanKindServer {
+		t.Errorf("got span kind %s, want server", span.SpanKind())
+	}
+	if got := span.SpanContext().TraceID().String(); got != parentTraceID {
+		t.Errorf("got trace ID %s, want it continued from traceparent %s", got, parentTraceID)
+	}
+
+	attrs := map[string]string{}
+	for _, kv := range span.Attributes() {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["http.route"] != "/status" {
+		t.Errorf("got http.route %q, want %q", attrs["http.route"], "/status")
+	}
+	if attrs["http.response.status_code"] != "200" {
+		t.Errorf("got http.response.status_code %q, want %q", attrs["http.response.status_code"], "200")
+	}
+}
+
+func Test_Metrics(t *testing.T) {
+	t.Parallel()
+
+	app := &application{
+		logger:  slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics: metrics.New(),
+	}
+	h := app.routes()
+
+	for _, path := range []string{"/status", "/status", "/does-not-exist"} {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	for _, method := range []string{"FOO", "BAR"} {
+		r := httptest.NewRequest(method, "/does-not-exist", nil)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusOK)
+	}
+
+	body := w.Body.String()
+	for _, want := range []string{
+		`http_requests_total{method="GET",route="/status",status="200"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_requests_total{method="other",route="unmatched",status="404"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/status",status="200"} 2`,
+		`http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics output to contain %q", want)
+		}
+	}
+}
+
+func Test_RateLimit(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	app.config.limiter.enabled = true
+	app.config.limiter.idleTimeout = time.Minute
+
+	limiter := app.newRateLimiter(ratelimit.Policy{Rate: 1, Burst: 2})
+	h := app.rateLimit(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		expectedStatus int
+		remaining      string
+	}{
+		{name: "first request", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusNoContent, remaining: "1"},
+		{name: "second request", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusNoContent, remaining: "0"},
+		{name: "burst exhausted", remoteAddr: "10.0.0.1:1234", expectedStatus: http.StatusTooManyRequests, remaining: "0"},
+		{name: "other client", remoteAddr: "10.0.0.2:1234", expectedStatus: http.StatusNoContent, remaining: "1"},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/url/process", nil)
+		r.RemoteAddr = tc.remoteAddr
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		res := w.Result()
+		if res.StatusCode != tc.expectedStatus {
+			t.Errorf("%s: got status %d, want %d", tc.name, res.StatusCode, tc.expectedStatus)
+		}
+		if got := res.Header.Get("RateLimit-Remaining"); got != tc.remaining {
+			t.Errorf("%s: got RateLimit-Remaining %q, want %q", tc.name, got, tc.remaining)
+		}
+		if tc.expectedStatus == http.StatusTooManyRequests && res.Header.Get("Retry-After") != "1" {
+			t.Errorf("%s: got Retry-After %q, want %q", tc.name, res.Header.Get("Retry-After"), "1")
+		}
+	}
+}
+
+func Test_FollowShortLinkRateLimit(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	app.config.limiter.enabled = true
+	app.config.limiter.idleTimeout = time.Minute
+	app.config.limiter.shortlinks = ratelimit.Policy{Rate: 1, Burst: 1}
+
+	h := app.routes()
+
+	// Only the limiter matters here: the first request, which gets past it,
+	// fails for want of a short link store.
+	var statuses []int
+	for range 2 {
+		r := httptest.NewRequest(http.MethodGet, "/s/aZ3k9Qx", nil)
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+		statuses = append(statuses, w.Code)
+	}
+
+	if statuses[0] == http.StatusTooManyRequests || statuses[1] != http.StatusTooManyRequests {
+		t.Errorf("got statuses %v, want the second request limited", statuses)
+	}
+}
+
+func Test_CORS(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	app.config.cors.trustedOrigins = []string{"http://localhost:3000"}
+	app.config.cors.allowCredentials = true
+	app.config.cors.maxAge = time.Minute
+
+	h := app.routes()
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		origin         string
+		expectedStatus int
+		allowOrigin    string
+		allowMethods   string
+	}{
+		{
+			name:           "preflight from trusted origin",
+			method:         http.MethodOptions,
+			path:           "/books/123",
+			origin:         "http://localhost:3000",
+			expectedStatus: http.StatusNoContent,
+			allowOrigin:    "http://localhost:3000",
+			allowMethods:   "DELETE, GET, OPTIONS, PUT",
+		},
+		{
+			name:           "preflight from untrusted origin",
+			method:         http.MethodOptions,
+			path:           "/books",
+			origin:         "http://evil.example",
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "simple request from trusted origin",
+			method:         http.MethodGet,
+			path:           "/status",
+			origin:         "http://localhost:3000",
+			expectedStatus: http.StatusOK,
+			allowOrigin:    "http://localhost:3000",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			r.Header.Set("Origin", tc.origin)
+			if tc.method == http.MethodOptions {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPut)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			if res.StatusCode != tc.expectedStatus {
+				t.Errorf("got status %d, want %d", res.StatusCode, tc.expectedStatus)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Origin"); got != tc.allowOrigin {
+				t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, tc.allowOrigin)
+			}
+			if got := res.Header.Get("Access-Control-Allow-Methods"); got != tc.allowMethods {
+				t.Errorf("got Access-Control-Allow-Methods %q, want %q", got, tc.allowMethods)
+			}
+			if tc.allowOrigin != "" && res.Header.Get("Access-Control-Allow-Credentials") != "true" {
+				t.Errorf("expected credentials to be allowed")
+			}
+		})
+	}
+}
+
+func Test_CORSWildcard(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	app.config.cors.trustedOrigins = []string{"*"}
+	app.config.cors.allowCredentials = true
+
+	h := app.routes()
+
+	r := httptest.NewRequest(http.MethodGet, "/status", nil)
+	r.Header.Set("Origin", "http://evil.example")
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	res := w.Result()
+	if got := res.Header.Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got Access-Control-Allow-Origin %q, want %q", got, "*")
+	}
+	if got := res.Header.Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("got Access-Control-Allow-Credentials %q, want none for a wildcard origin", got)
+	}
+}
+
+func Test_ClientCertIdentity(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	app.config.limiter.enabled = true
+	app.config.limiter.idleTimeout = time.Minute
+
+	limiter := app.newRateLimiter(ratelimit.Policy{Rate: 1, Burst: 1})
+	h := app.identifyClient(app.rateLimit(limiter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, contextGetIdentity(r))
+	})))
+
+	withCert := func(cn string) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: cn}}}}}
+	}
+
+	tests := []struct {
+		name           string
+		state          *tls.ConnectionState
+		expectedStatus int
+		identity       string
+	}{
+		{name: "first client", state: withCert("alice"), expectedStatus: http.StatusOK, identity: "alice"},
+		{name: "second client from the same address", state: withCert("bob"), expectedStatus: http.StatusOK, identity: "bob"},
+		{name: "first client again", state: withCert("alice"), expectedStatus: http.StatusTooManyRequests},
+		{name: "no certificate", state: nil, expectedStatus: http.StatusOK, identity: ""},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/books", nil)
+		r.TLS = tc.state
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		res := w.Result()
+		if res.StatusCode != tc.expectedStatus {
+			t.Errorf("%s: got status %d, want %d", tc.name, res.StatusCode, tc.expectedStatus)
+			continue
+		}
+		if tc.expectedStatus == http.StatusOK && w.Body.String() != tc.identity {
+			t.Errorf("%s: got identity %q, want %q", tc.name, w.Body.String(), tc.identity)
+		}
+	}
+}
+
+func Test_ClientIP(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	if err := (prefixList{&app.config.http.trustedProxies}).Set("10.0.0.0/8 192.0.2.7"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		realIP     string
+		expectedIP string
+	}{
+		{name: "direct client", remoteAddr: "203.0.113.5:1234", expectedIP: "203.0.113.5"},
+		{name: "untrusted peer forging X-Forwarded-For", remoteAddr: "203.0.113.5:1234", forwarded: "198.51.100.1", expectedIP: "203.0.113.5"},
+		{name: "untrusted peer forging X-Real-IP", remoteAddr: "203.0.113.5:1234", realIP: "198.51.100.1", expectedIP: "203.0.113.5"},
+		{name: "trusted proxy", remoteAddr: "10.1.2.3:1234", forwarded: "198.51.100.1", expectedIP: "198.51.100.1"},
+		{name: "trusted proxy chain", remoteAddr: "192.0.2.7:1234", forwarded: "198.51.100.1, 10.0.0.9", expectedIP: "198.51.100.1"},
+		{name: "client prepending an address", remoteAddr: "10.1.2.3:1234", forwarded: "1.1.1.1, 198.51.100.1", expectedIP: "198.51.100.1"},
+		{name: "trusted proxy with X-Real-IP", remoteAddr: "10.1.2.3:1234", realIP: "198.51.100.1", expectedIP: "198.51.100.1"},
+		{name: "trusted proxy without headers", remoteAddr: "10.1.2.3:1234", expectedIP: "10.1.2.3"},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/books", nil)
+		r.RemoteAddr = tc.remoteAddr
+		if tc.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tc.forwarded)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+
+		if got := app.clientIP(r); got != tc.expectedIP {
+			t.Errorf("%s: got client IP %q, want %q", tc.name, got, tc.expectedIP)
+		}
+	}
+}
+
+func Test_URLRules_CRUD(t *testing.T) {
+	t.Parallel()
+	test := setupTestApp(t)
+	defer test.teardown()
+
+	test.app.config.admin.token = "s3cret"
+	h := test.app.routes()
+
+	send := func(method, path, payload string) (int, []byte) {
+		r := httptest.NewRequest(method, path, strings.NewReader(payload))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer s3cret")
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+		return w.Code, w.Body.Bytes()
+	}
+
+	process := func(rawURL string) string {
+		status, body := send(http.MethodPost, "/url/process", `{"url":"`+rawURL+`","operation":"redirection"}`)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d processing %s: %s", status, rawURL, body)
+		}
+
+		var resp URLResponse
+		_ = json.Unmarshal(body, &resp)
+		return resp.ProcessedURL
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/url/rules", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d without a token, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	status, body := send(http.MethodPost, "/url/rules", `{"kind":"path","host":"www.byfood.com","pattern":"^/items/(\\d+)$","replacement":"/products/$1"}`)
+	if status != http.StatusCreated {
+		t.Fatalf("got status %d creating a rule, want %d: %s", status, http.StatusCreated, body)
+	}
+
+	var rule URLRuleResponse
+	if err := json.Unmarshal(body, &rule); err != nil {
+		t.Fatalf("decoding rule: %v", err)
+	}
+	if got := process("https://byfood.com/items/42"); got != "https://www.byfood.com/products/42" {
+		t.Errorf("got %q, want the path rewritten", got)
+	}
+
+	key := uuid.NewString()
+	for i, wantReplayed := range []string{"", "true"} {
+		r := httptest.NewRequest(http.MethodPost, "/url/rules", strings.NewReader(`{"kind":"query","host":"shop.example.com","query":{"allow":["id"]}}`))
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Authorization", "Bearer s3cret")
+		r.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusCreated {
+			t.Fatalf("attempt %d: got status %d creating a rule with an idempotency key: %s", i+1, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Idempotent-Replayed"); got != wantReplayed {
+			t.Errorf("attempt %d: got Idempotent-Replayed %q, want %q", i+1, got, wantReplayed)
+		}
+	}
+
+	status, body = send(http.MethodPost, "/url/rules/dry-run", `{"id":"`+rule.ID.String()+`","rule":{"kind":"path","host":"www.byfood.com","pattern":"^/items/(\\d+)$","replacement":"/p/$1"},"urls":["https://byfood.com/items/42","https://byfood.com/about"],"operation":"redirection"}`)
+	if status != http.StatusOK {
+		t.Fatalf("got status %d for a dry run, want %d: %s", status, http.StatusOK, body)
+	}
+
+	var dryRun URLRuleDryRunResponse
+	if err := json.Unmarshal(body, &dryRun); err != nil {
+		t.Fatalf("decoding dry run: %v", err)
+	}
+	if len(dryRun.Results) != 2 || !dryRun.Results[0].Changed || dryRun.Results[1].Changed {
+		t.Fatalf("unexpected dry run: %s", body)
+	}
+	if got := dryRun.Results[0].Proposed.ProcessedURL; got != "https://www.byfood.com/p/42" {
+		t.Errorf("got proposed %q, want the changed rewrite", got)
+	}
+	if got := process("https://byfood.com/items/42"); got != "https://www.byfood.com/products/42" {
+		t.Errorf("got %q after a dry run, want the stored rule", got)
+	}
+
+	status, _ = send(http.MethodPut, "/url/rules/"+rule.ID.String(), `{"replacement":"/p/$1"}`)
+	if status != http.StatusOK {
+		t.Errorf("got status %d updating a rule, want %d", status, http.StatusOK)
+	}
+	if got := process("https://byfood.com/items/42"); got != "https://www.byfood.com/p/42" {
+		t.Errorf("got %q, want the updated rewrite", got)
+	}
+
+	for _, tc := range []struct {
+		payload string
+		status  int
+	}{
+		{`{"kind":"host","host":"old.example","target_host":"new.example"}`, http.StatusCreated},
+		{`{"kind":"host","host":"old.example","target_host":"other.example"}`, http.StatusConflict},
+		{`{"kind":"path","pattern":"^/(a)$","replacement":"/$2"}`, http.StatusUnprocessableEntity},
+		{`{"kind":"cookie"}`, http.StatusUnprocessableEntity},
+	} {
+		if status, body := send(http.MethodPost, "/url/rules", tc.payload); status != tc.status {
+			t.Errorf("got status %d for %s, want %d: %s", status, tc.payload, tc.status, body)
+		}
+	}
+
+	status, body = send(http.MethodGet, "/url/rules", "")
+	var rules []URLRuleResponse
+	if err := json.Unmarshal(body, &rules); status != http.StatusOK || err != nil || len(rules) != 3 {
+		t.Errorf("got status %d and %s listing rules, want 3 rules", status, body)
+	}
+
+	if status, _ := send(http.MethodDelete, "/url/rules/"+rule.ID.String(), ""); status != http.StatusNoContent {
+		t.Errorf("got status %d deleting a rule, want %d", status, http.StatusNoContent)
+	}
+	if got := process("https://byfood.com/items/42"); got != "https://www.byfood.com/items/42" {
+		t.Errorf("got %q, want the rewrite gone", got)
+	}
+	if status, _ := send(http.MethodGet, "/url/rules/"+rule.ID.String(), ""); status != http.StatusNotFound {
+		t.Errorf("got status %d for a deleted rule, want %d", status, http.StatusNotFound)
+	}
+}
+
+func Test_AdminLogLevel(t *testing.T) {
+	t.Parallel()
+
+	app := &application{logger: slog.New(slog.NewTextHandler(io.Discard, nil)), logLevel: new(slog.LevelVar)}
+	app.config.admin.token = "s3cret"
+
+	h := app.routes()
+
+	tests := []struct {
+		name           string
+		method         string
+		token          string
+		body           string
+		expectedStatus int
+		expectedLevel  slog.Level
+	}{
+		{name: "missing token", method: http.MethodGet, expectedStatus: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPut, token: "guess", body: `{"level":"error"}`, expectedStatus: http.StatusUnauthorized},
+		{name: "show level", method: http.MethodGet, token: "s3cret", expectedStatus: http.StatusOK},
+		{name: "invalid level", method: http.MethodPut, token: "s3cret", body: `{"level":"loud"}`, expectedStatus: http.StatusUnprocessableEntity},
+		{name: "change level", method: http.MethodPut, token: "s3cret", body: `{"level":"warn"}`, expectedStatus: http.StatusOK, expectedLevel: slog.LevelWarn},
+	}
+
+	for _, tc := range tests {
+		r := httptest.NewRequest(tc.method, "/admin/log-level", strings.NewReader(tc.body))
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		res := w.Result()
+		if res.StatusCode != tc.expectedStatus {
+			t.Errorf("%s: got status %d, want %d", tc.name, res.StatusCode, tc.expectedStatus)
+		}
+		if res.StatusCode == http.StatusUnauthorized && res.Header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: missing WWW-Authenticate header", tc.name)
+		}
+		if got := app.logLevel.Level(); got != tc.expectedLevel {
+			t.Errorf("%s: got level %s, want %s", tc.name, got, tc.expectedLevel)
+		}
+	}
+}
+
+func Test_AccessLogSampling(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	app := &application{logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	h := app.logAccess(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	for _, path := range []string{"/ok", "/ok", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if n := strings.Count(buf.String(), "msg=access"); n != 1 {
+		t.Errorf("got %d access records with sampling off, want only the error: %s", n, buf.String())
+	}
+
+	buf.Reset()
+	app.config.log.accessSampleRatio = 1
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+
+	if n := strings.Count(buf.String(), "msg=access"); n != 1 {
+		t.Errorf("got %d access records with every request sampled, want 1", n)
+	}
+}
+
+func Test_LoadConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+
+	yamlFile := filepath.Join(dir, "config.yaml")
+	writeFile(t, yamlFile, `
+http:
+  port: 9000
+  read_timeout: 3s
+db:
+  dsn: file:secret@db:5432/books
+  max_open_conns: 50
+cors:
+  trusted_origins:
+    - https://a.example
+    - https://b.example
+`)
+
+	tomlFile := filepath.Join(dir, "config.toml")
+	writeFile(t, tomlFile, `
+[db]
+dsn = "toml:secret@db:5432/books"
+max_idle_conns = 5
+`)
+
+	badFile := filepath.Join(dir, "bad.yaml")
+	writeFile(t, badFile, `
+http:
+  port: 0
+  colour: blue
+`)
+
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+
+	t.Run("precedence", func(t *testing.T) {
+		cfg, _, err := loadConfig(
+			[]string{"-config", yamlFile, "-http-port", "9100"},
+			env(map[string]string{"BOOKCRUD_HTTP_PORT": "9200", "BOOKCRUD_DB_MAX_OPEN_CONNS": "60"}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if cfg.http.port != 9100 {
+			t.Errorf("got http port %d, want flag value 9100", cfg.http.port)
+		}
+		if cfg.db.pool.MaxOpenConns != 60 {
+			t.Errorf("got max open conns %d, want env value 60", cfg.db.pool.MaxOpenConns)
+		}
+		if cfg.http.readTimeout != 3*time.Second {
+			t.Errorf("got read timeout %s, want file value 3s", cfg.http.readTimeout)
+		}
+		if cfg.http.writeTimeout != 10*time.Second {
+			t.Errorf("got write timeout %s, want default 10s", cfg.http.writeTimeout)
+		}
+		if cfg.shutdown.drainDelay != 5*time.Second {
+			t.Errorf("got drain delay %s, want default 5s", cfg.shutdown.drainDelay)
+		}
+		if got := strings.Join(cfg.cors.trustedOrigins, " "); got != "https://a.example https://b.example" {
+			t.Errorf("got trusted origins %q", got)
+		}
+	})
+
+	t.Run("toml file from environment", func(t *testing.T) {
+		cfg, _, err := loadConfig(nil, env(map[string]string{"BOOKCRUD_CONFIG": tomlFile}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if cfg.db.dsn != "toml:secret@db:5432/books" || cfg.db.pool.MaxIdleConns != 5 {
+			t.Errorf("got dsn %q and max idle conns %d", cfg.db.dsn, cfg.db.pool.MaxIdleConns)
+		}
+	})
+
+	t.Run("all errors reported", func(t *testing.T) {
+		_, _, err := loadConfig(
+			[]string{"-config", badFile, "-tls-cert-file", "server.crt", "-tls-min-version", "1.0"},
+			env(map[string]string{"BOOKCRUD_OTEL_SAMPLE_RATIO": "2", "BOOKCRUD_CORS_MAX_AGE": "soon"}),
+		)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+
+		for _, want := range []string{
+			`unknown setting "http-colour"`,
+			"BOOKCRUD_CORS_MAX_AGE: cors-max-age",
+			"invalid db-dsn",
+			"invalid http-port",
+			"invalid otel-sample-ratio",
+			"invalid tls-key-file",
+			"invalid tls-min-version",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q does not mention %q", err, want)
+			}
+		}
+	})
+
+	t.Run("wildcard origin with credentials", func(t *testing.T) {
+		_, _, err := loadConfig(
+			[]string{"-db-dsn", "u:p@db/books", "-cors-trusted-origins", "*"},
+			env(nil),
+		)
+		if err == nil || !strings.Contains(err.Error(), "invalid cors-trusted-origins") {
+			t.Errorf("got error %v, want invalid cors-trusted-origins", err)
+		}
+
+		_, _, err = loadConfig(
+			[]string{"-db-dsn", "u:p@db/books", "-cors-trusted-origins", "*", "-cors-allow-credentials=false"},
+			env(nil),
+		)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("secrets redacted", func(t *testing.T) {
+		_, fs, err := loadConfig([]string{"-config", yamlFile}, env(nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var buf bytes.Buffer
+		printConfig(&buf, fs)
+
+		if strings.Contains(buf.String(), "secret") {
+			t.Errorf("printed config leaks the password:\n%s", buf.String())
+		}
+		if !strings.Contains(buf.String(), `admin-token=""`) {
+			t.Errorf("printed config should show an unset admin token as empty:\n%s", buf.String())
+		}
+		if !strings.Contains(buf.String(), `db-dsn="file:xxxxx@db:5432/books"`) {
+			t.Errorf("printed config is missing the redacted DSN:\n%s", buf.String())
+		}
+	})
+}
+
+func Test_LimitBody(t *testing.T) {
+	t.Parallel()
+
+	urlProcessorCore, err := urlprocessor.New(urlprocessor.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		urlProcessorCore: urlProcessorCore,
+	}
+	app.config.http.maxBodyBytes = 16
+
+	h := app.routes()
+
+	r := httptest.NewRequest(http.MethodPost, "/url/process", strings.NewReader(`{"url":"https://example.com/a-rather-long-path","operation":"all"}`))
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	res := w.Result()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	if !strings.Contains(string(body), "Body must not be larger than 16 bytes") {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	// Batches have a limit of their own instead of http-max-body-bytes.
+	r = httptest.NewRequest(http.MethodPost, urlBatchPath+"?operation=canonical", strings.NewReader("https://example.com/a-rather-long-path\n"))
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	if body := w.Body.String(); w.Code != http.StatusOK || strings.Contains(body, "larger than") {
+		t.Errorf("got status %d and body %s for a batch over http-max-body-bytes", w.Code, body)
+	}
+}
+
+func Test_BatchProblem(t *testing.T) {
+	t.Parallel()
+
+	if got := batchProblem(http.StatusBadRequest, "").Detail; got != "" {
+		t.Errorf("got detail %q for an empty message", got)
+	}
+	if got := batchProblem(http.StatusBadRequest, "bad url").Detail; got != "Bad url" {
+		t.Errorf("got detail %q, want %q", got, "Bad url")
+	}
+}
+
+func Test_URLProfiles(t *testing.T) {
+	t.Parallel()
+
+	profiles := filepath.Join(t.TempDir(), "profiles.yaml")
+	writeFile(t, profiles, `
+tabelog:
+  hosts:
+    "*.tabelog.com": tabelog.com
+  scheme: https
+`)
+
+	cfg, _, err := loadConfig([]string{
+		"-db-dsn=user:pass@localhost/db",
+		"-url-hosts=*.byfood.com=www.byfood.com",
+		"-url-default-host=",
+		"-url-case-sensitive-paths=www.byfood.com=/share/",
+		"-url-profiles-file=" + profiles,
+	}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upCfg, err := cfg.urlProcessorConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	urlProcessorCore, err := urlprocessor.New(upCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		urlProcessorCore: urlProcessorCore,
+	}
+	h := app.routes()
+
+	tests := []struct {
+		name       string
+		payload    string
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "wildcard subdomain",
+			payload:    `{"url":"http://blog.byfood.com/Tours","operation":"redirection"}`,
+			wantStatus: http.StatusOK,
+			want:       `"http://www.byfood.com/tours"`,
+		},
+		{
+			name:       "unmapped host is kept",
+			payload:    `{"url":"http://example.com/Tours","operation":"redirection"}`,
+			wantStatus: http.StatusOK,
+			want:       `"http://example.com/tours"`,
+		},
+		{
+			name:       "profile",
+			payload:    `{"url":"http://www.tabelog.com/Tokyo","operation":"redirection","profile":"tabelog"}`,
+			wantStatus: http.StatusOK,
+			want:       `"https://tabelog.com/tokyo"`,
+		},
+		{
+			name:       "target host",
+			payload:    `{"url":"http://blog.byfood.com/a","operation":"all","target_host":"staging.byfood.com"}`,
+			wantStatus: http.StatusOK,
+			want:       `"http://staging.byfood.com/a"`,
+		},
+		{
+			name:       "case sensitive path",
+			payload:    `{"url":"http://blog.byfood.com/Share/AbC","operation":"redirection"}`,
+			wantStatus: http.StatusOK,
+			want:       `"http://www.byfood.com/share/AbC"`,
+		},
+		{
+			name:       "internationalized host",
+			payload:    `{"url":"http://Bücher.example/Straße","operation":"redirection","unicode":true}`,
+			wantStatus: http.StatusOK,
+			want:       `"unicode_url": "http://bücher.example/straße"`,
+		},
+		{
+			name:       "invalid internationalized host",
+			payload:    `{"url":"http://a\u200db.example/","operation":"redirection"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       "url",
+		},
+		{
+			name:       "unknown profile",
+			payload:    `{"url":"http://byfood.com/a","operation":"redirection","profile":"missing"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       "profile",
+		},
+		{
+			name:       "invalid target host",
+			payload:    `{"url":"http://byfood.com/a","operation":"redirection","target_host":"bad/host"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       "target_host",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/url/process", strings.NewReader(tc.payload))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+
+			if res.StatusCode != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.StatusCode, tc.wantStatus, body)
+			}
+			if !strings.Contains(string(body), tc.want) {
+				t.Errorf("expected %q in body %s", tc.want, body)
+			}
+		})
+	}
+}
+
+func Test_URLQueryPolicy(t *testing.T) {
+	t.Parallel()
+
+	policies := filepath.Join(t.TempDir(), "query.toml")
+	writeFile(t, policies, `
+["shop.example.com"]
+allow = ["id"]
+`)
+
+	cfg, _, err := loadConfig([]string{
+		"-db-dsn=user:pass@localhost/db",
+		"-url-query-file=" + policies,
+	}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upCfg, err := cfg.urlProcessorConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	urlProcessorCore, err := urlprocessor.New(upCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		urlProcessorCore: urlProcessorCore,
+	}
+	h := app.routes()
+
+	tests := []struct {
+		name    string
+		payload string
+		want    URLResponse
+	}{
+		{
+			name:    "default policy strips tracking params",
+			payload: `{"url":"https://example.com/a?utm_source=news&id=42&fbclid=x&b=1","operation":"canonical"}`,
+			want:    URLResponse{ProcessedURL: "https://example.com/a?b=1&id=42", QueryPolicy: "default"},
+		},
+		{
+			name:    "host policy",
+			payload: `{"url":"https://shop.example.com/item?ref=home&id=42","operation":"all"}`,
+			want:    URLResponse{ProcessedURL: "https://www.byfood.com/item?id=42", QueryPolicy: "shop.example.com"},
+		},
+		{
+			name:    "no policy for redirection",
+			payload: `{"url":"https://shop.example.com/item?ref=home","operation":"redirection"}`,
+			want:    URLResponse{ProcessedURL: "https://www.byfood.com/item?ref=home"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/url/process", strings.NewReader(tc.payload))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+			}
+
+			var got URLResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_URLRules(t *testing.T) {
+	t.Parallel()
+
+	urlProcessorCore, err := urlprocessor.New(urlprocessor.Config{
+		Default: urlprocessor.Profile{DefaultHost: "www.byfood.com"},
+		Query:   urlprocessor.QueryRules{Default: urlprocessor.QueryPolicy{Deny: urlprocessor.TrackingParams}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		urlProcessorCore: urlProcessorCore,
+	}
+	h := app.routes()
+
+	tests := []struct {
+		name       string
+		payload    string
+		wantStatus int
+		want       string
+	}{
+		{
+			name:       "pipeline",
+			payload:    `{"url":"https://BYFOOD.com/Food?utm_source=x&id=1#top","rules":["strip_tracking","lowercase_path","force_host"]}`,
+			wantStatus: http.StatusOK,
+			want:       `"https://www.byfood.com/food?id=1#top"`,
+		},
+		{
+			name:       "unknown rule",
+			payload:    `{"url":"https://byfood.com/","rules":["strip_tracking","nope"]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       `"rules"`,
+		},
+		{
+			name:       "empty pipeline",
+			payload:    `{"url":"https://byfood.com/","rules":[]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       `"rules"`,
+		},
+		{
+			name:       "operation and rules",
+			payload:    `{"url":"https://byfood.com/","operation":"all","rules":["normalize"]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			want:       `"operation"`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/url/process", strings.NewReader(tc.payload))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			body, _ := io.ReadAll(res.Body)
+			if res.StatusCode != tc.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", res.StatusCode, tc.wantStatus, body)
+			}
+			if !strings.Contains(string(body), tc.want) {
+				t.Errorf("body %s does not contain %s", body, tc.want)
+			}
+		})
+	}
+}
+
+func Test_URLExplain(t *testing.T) {
+	t.Parallel()
+
+	urlProcessorCore, err := urlprocessor.New(urlprocessor.Config{
+		Default: urlprocessor.Profile{DefaultHost: "www.byfood.com"},
+		Query:   urlprocessor.QueryRules{Default: urlprocessor.QueryPolicy{Deny: urlprocessor.TrackingParams}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		urlProcessorCore: urlProcessorCore,
+	}
+	h := app.routes()
+
+	tests := []struct {
+		name    string
+		payload string
+		want    []URLStep
+	}{
+		{
+			name:    "operation",
+			payload: `{"url":"https://byfood.com/a/?utm_source=x#top","operation":"canonical","explain":true}`,
+			want: []URLStep{
+				{Rule: "strip_tracking", Before: "https://byfood.com/a/?utm_source=x#top", After: "https://byfood.com/a/#top", Changed: []string{"query"}},
+				{Rule: "drop_fragment", Before: "https://byfood.com/a/#top", After: "https://byfood.com/a/", Changed: []string{"fragment"}},
+				{Rule: "trim_trailing_slash", Before: "https://byfood.com/a/", After: "https://byfood.com/a", Changed: []string{"path"}},
+			},
+		},
+		{
+			name:    "rules",
+			payload: `{"url":"https://byfood.com/A","rules":["force_host","lowercase_host"],"explain":true}`,
+			want: []URLStep{
+				{Rule: "force_host", Before: "https://byfood.com/A", After: "https://www.byfood.com/A", Changed: []string{"host"}},
+				{Rule: "lowercase_host", Before: "https://www.byfood.com/A", After: "https://www.byfood.com/A", Changed: []string{}},
+			},
+		},
+		{
+			name:    "not asked for",
+			payload: `{"url":"https://byfood.com/A","operation":"all"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/url/process", strings.NewReader(tc.payload))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("got status %d, want %d", res.StatusCode, http.StatusOK)
+			}
+
+			var got URLResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Trace, tc.want) {
+				t.Errorf("got trace %+v, want %+v", got.Trace, tc.want)
+			}
+		})
+	}
+}
+
+func Test_URLBatch(t *testing.T) {
+	t.Parallel()
+
+	urlProcessorCore, err := urlprocessor.New(urlprocessor.Config{
+		Default: urlprocessor.Profile{DefaultHost: "www.byfood.com"},
+		Query:   urlprocessor.QueryRules{Default: urlprocessor.QueryPolicy{Deny: urlprocessor.TrackingParams}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		urlProcessorCore: urlProcessorCore,
+	}
+	app.config.urlProcessor.batchWorkers = 4
+	app.config.urlProcessor.batchMaxBytes = 4096
+	h := app.routes()
+
+	post := func(t *testing.T, target, contentType, body string) (*http.Response, []URLBatchResult) {
+		t.Helper()
+
+		r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+
+		h.ServeHTTP(w, r)
+
+		res := w.Result()
+		if res.StatusCode != http.StatusOK {
+			return res, nil
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Fatalf("got content type %q", ct)
+		}
+
+		var results []URLBatchResult
+		dec := json.NewDecoder(res.Body)
+		for dec.More() {
+			var result URLBatchResult
+			if err := dec.Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, result)
+		}
+		return res, results
+	}
+
+	t.Run("plain text in input order", func(t *testing.T) {
+		var body strings.Builder
+		for i := range 100 {
+			fmt.Fprintf(&body, "https://BYFOOD.com/%d?utm_source=x\n", i)
+		}
+
+		_, results := post(t, "/url/process/batch?operation=all", "text/plain", body.String())
+		if len(results) != 100 {
+			t.Fatalf("got %d results, want 100", len(results))
+		}
+		for i, result := range results {
+			want := fmt.Sprintf("https://www.byfood.com/%d", i)
+			if result.Line != i+1 || result.URLResponse == nil || result.ProcessedURL != want {
+				t.Fatalf("result %d is %+v, want line %d with %q", i, result, i+1, want)
+			}
+		}
+	})
+
+	t.Run("ndjson with failing lines", func(t *testing.T) {
+		body := `"https://BYFOOD.com/a/"
+
+{"url":"https://byfood.com/B","operation":"redirection"}
+{"url":
+{"url":"https://byfood.com/c","operation":"bogus"}
+{"url":"https://byfood.com/d","rules":["nope"]}
+{"url":"https://byfood.com/e","operation":"resolve"}
+{"url":"https://byfood.com/f","rules":["normalize","resolve"]}
+`
+		_, results := post(t, "/url/process/batch?operation=canonical", "application/x-ndjson", body)
+
+		want := []struct {
+			line   int
+			url    string
+			status int
+		}{
+			{line: 1, url: "https://BYFOOD.com/a"},
+			{line: 3, url: "https://www.byfood.com/b"},
+			{line: 4, status: http.StatusBadRequest},
+			{line: 5, status: http.StatusUnprocessableEntity},
+			{line: 6, status: http.StatusUnprocessableEntity},
+			{line: 7, status: http.StatusBadRequest},
+			{line: 8, status: http.StatusBadRequest},
+		}
+		if len(results) != len(want) {
+			t.Fatalf("got %d results, want %d: %+v", len(results), len(want), results)
+		}
+		for i, w := range want {
+			got := results[i]
+			if got.Line != w.line {
+				t.Errorf("result %d is for line %d, want %d", i, got.Line, w.line)
+			}
+			if w.status != 0 {
+				if got.Error == nil || got.Error.Status != w.status {
+					t.Errorf("line %d: got error %+v, want status %d", w.line, got.Error, w.status)
+				}
+				continue
+			}
+			if got.Error != nil || got.URLResponse == nil || got.ProcessedURL != w.url {
+				t.Errorf("line %d: got %+v, want %q", w.line, got, w.url)
+			}
+		}
+	})
+
+	t.Run("json array", func(t *testing.T) {
+		body := `["https://byfood.com/A", {"url":"https://byfood.com/b/#x","operation":"canonical","explain":true}]`
+		_, results := post(t, "/url/process/batch?operation=redirection&unicode=true", "application/json", body)
+
+		if len(results) != 2 {
+			t.Fatalf("got %d results, want 2: %+v", len(results), results)
+		}
+		if results[0].ProcessedURL != "https://www.byfood.com/a" || results[0].UnicodeURL != "https://www.byfood.com/a" {
+			t.Errorf("first result is %+v", results[0])
+		}
+		if results[1].ProcessedURL != "https://byfood.com/b" || len(results[1].Trace) != 3 {
+			t.Errorf("second result is %+v", results[1])
+		}
+	})
+
+	t.Run("malformed array ends the batch", func(t *testing.T) {
+		_, results := post(t, "/url/process/batch?operation=all", "application/json", `["https://byfood.com/a", }`)
+
+		if len(results) != 2 || results[0].ProcessedURL != "https://www.byfood.com/a" {
+			t.Fatalf("got %+v", results)
+		}
+		if last := results[1]; last.Line != 0 || last.Error == nil || last.Error.Status != http.StatusBadRequest {
+			t.Errorf("last result is %+v, want a batch error", last)
+		}
+	})
+
+	t.Run("body too large ends the batch", func(t *testing.T) {
+		body := strings.Repeat("https://byfood.com/a\n", 500)
+		_, results := post(t, "/url/process/batch?operation=all", "text/plain", body)
+
+		last := results[len(results)-1]
+		if last.Error == nil || !strings.Contains(last.Error.Detail, "4096 bytes") {
+			t.Errorf("last result is %+v, want a body size error", last)
+		}
+	})
+
+	t.Run("errors before the batch starts", func(t *testing.T) {
+		tests := []struct {
+			target      string
+			contentType string
+			body        string
+			want        int
+		}{
+			{"/url/process/batch", "application/xml", "<urls/>", http.StatusUnsupportedMediaType},
+			{"/url/process/batch", "application/json", `{"url":"https://byfood.com"}`, http.StatusBadRequest},
+			{"/url/process/batch?unicode=maybe", "text/plain", "https://byfood.com", http.StatusBadRequest},
+			{"/url/process/batch?operation=all&rules=normalize", "text/plain", "https://byfood.com", http.StatusBadRequest},
+			{"/url/process/batch?operation=resolve", "text/plain", "https://byfood.com", http.StatusBadRequest},
+			{"/url/process/batch?rules=normalize,resolve", "text/plain", "https://byfood.com", http.StatusBadRequest},
+		}
+
+		for _, tc := range tests {
+			res, _ := post(t, tc.target, tc.contentType, tc.body)
+			if res.StatusCode != tc.want {
+				t.Errorf("%s %s: got status %d, want %d", tc.target, tc.contentType, res.StatusCode, tc.want)
+			}
+		}
+	})
+}
+
+func Test_URLResolve(t *testing.T) {
+	t.Parallel()
+
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/internal":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer target.Close()
+
+	cfg, _, err := loadConfig([]string{
+		"-db-dsn=user:pass@localhost/db",
+		"-url-resolve-allow-networks=127.0.0.0/8 ::1",
+		"-url-resolve-timeout=2s",
+	}, func(string) string { return "" })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upCfg, err := cfg.urlProcessorConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	urlProcessorCore, err := urlprocessor.New(upCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		logger:           slog.New(slog.NewTextHandler(io.Discard, nil)),
+		urlProcessorCore: urlProcessorCore,
+	}
+	h := app.routes()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		payload    string
+		wantStatus int
+		want       URLResponse
+	}{
+		{
+			name:       "redirect chain",
+			payload:    fmt.Sprintf(`{"url":%q,"operation":"resolve"}`, target.URL+"/old"),
+			wantStatus: http.StatusOK,
+			want: URLResponse{
+				ProcessedURL: target.URL + "/new",
+				Chain: []URLHop{
+					{URL: target.URL + "/old", Method: http.MethodHead, Status: http.StatusMovedPermanently},
+					{URL: target.URL + "/new", Method: http.MethodHead, Status: http.StatusOK},
+				},
+			},
+		},
+		{
+			name:       "redirect to a link-local address",
+			payload:    fmt.Sprintf(`{"url":%q,"operation":"resolve"}`, target.URL+"/internal"),
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "unreachable",
+			payload:    fmt.Sprintf(`{"url":%q,"operation":"resolve"}`, closed.URL),
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/url/process", strings.NewReader(tc.payload))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			if res.StatusCode != tc.wantStatus {
+				t.Fatalf("got status %d, want %d", res.StatusCode, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var got URLResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(name, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func testCreateBook(test *testApp, bk book.NewBook) (*book.Book, error) {
 	payload := fmt.Sprintf(`{"title":"%s","author":"%s","year":%d}`, bk.Title, bk.Author, bk.Year)
 	r := httptest.NewRequest(http.MethodPost, "/books", bytes.NewReader([]byte(payload)))
@@ -0,0 +1,675 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/shortlink"
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/Babatunde50/book-crud/server/internal/logging"
+	"github.com/Babatunde50/book-crud/server/internal/ratelimit"
+	"github.com/Babatunde50/book-crud/server/internal/tlsconfig"
+	"github.com/Babatunde50/book-crud/server/internal/tracing"
+	"github.com/Babatunde50/book-crud/server/internal/validator"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix is prepended to a flag name, upper-cased with dashes turned into
+// underscores, to find the environment variable that sets it. For example
+// -db-dsn is read from BOOKCRUD_DB_DSN.
+const envPrefix = "BOOKCRUD_"
+
+type config struct {
+	baseURL string
+	log     struct {
+		format            string
+		level             string
+		accessSampleRatio float64
+	}
+	admin struct {
+		token string
+	}
+	http struct {
+		port           int
+		readTimeout    time.Duration
+		writeTimeout   time.Duration
+		idleTimeout    time.Duration
+		maxBodyBytes   int64
+		trustedProxies []netip.Prefix
+	}
+	tls struct {
+		certFile       string
+		keyFile        string
+		clientCAFile   string
+		clientAuth     string
+		minVersion     string
+		cipherSuites   []string
+		reloadInterval time.Duration
+		http2          bool
+	}
+	shutdown struct {
+		timeout    time.Duration
+		drainDelay time.Duration
+	}
+	db struct {
+		dsn                  string
+		automigrate          bool
+		pool                 database.Pool
+		replicaDSNs          []string
+		replicaPool          database.Pool
+		replicaCheckInterval time.Duration
+		readYourWrites       bool
+		queryTimeout         time.Duration
+		slowQueryThreshold   time.Duration
+	}
+	limiter struct {
+		enabled     bool
+		idleTimeout time.Duration
+		books       ratelimit.Policy
+		url         ratelimit.Policy
+		shortlinks  ratelimit.Policy
+	}
+	cors struct {
+		trustedOrigins   []string
+		allowCredentials bool
+		maxAge           time.Duration
+	}
+	idempotency struct {
+		ttl time.Duration
+	}
+	urlProcessor struct {
+		hosts              []string
+		defaultHost        string
+		scheme             string
+		caseSensitivePaths []string
+		profilesFile       string
+		queryAllow         []string
+		queryDeny          []string
+		queryFile          string
+		batchWorkers       int
+		batchMaxBytes      int64
+		resolveMaxHops     int
+		resolveTimeout     time.Duration
+		resolveMethod      string
+		resolveAllow       []string
+		rulesReload        time.Duration
+	}
+	shortlink struct {
+		codeLength int
+		rules      []string
+	}
+	tracing struct {
+		exporter     string
+		otlpEndpoint string
+		otlpInsecure bool
+		sampleRatio  float64
+	}
+
+	// Command line only.
+	file        string
+	showVersion bool
+	printConfig bool
+}
+
+// cliOnlyFlags are never read from the config file or the environment.
+var cliOnlyFlags = []string{"config", "version", "print-config"}
+
+// secretFlags maps flags holding credentials to the function that redacts
+// their value for printing.
+var secretFlags = map[string]func(string) string{
+	"db-dsn":          redactDSN,
+	"db-replica-dsns": redactDSNs,
+	"admin-token":     func(string) string { return "xxxxx" },
+}
+
+func newFlagSet(cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.file, "config", "", "path to a YAML or TOML config file (env BOOKCRUD_CONFIG)")
+	fs.BoolVar(&cfg.showVersion, "version", false, "display version and exit")
+	fs.BoolVar(&cfg.printConfig, "print-config", false, "print the effective configuration, with secrets redacted, and exit")
+
+	fs.StringVar(&cfg.baseURL, "base-url", "http://localhost:4748", "base URL for the application")
+
+	fs.StringVar(&cfg.log.format, "log-format", logging.FormatText, "log output format (text|json|logfmt)")
+	fs.StringVar(&cfg.log.level, "log-level", "debug", "minimum log level (debug|info|warn|error)")
+	fs.Float64Var(&cfg.log.accessSampleRatio, "log-access-sample-ratio", 1, "fraction of successful requests written to the access log (0-1); errors are always logged")
+
+	fs.StringVar(&cfg.admin.token, "admin-token", "", "bearer token for the /admin and /url/rules endpoints; they are disabled when empty")
+
+	fs.IntVar(&cfg.http.port, "http-port", 4748, "port to listen on for HTTP requests")
+	fs.DurationVar(&cfg.http.readTimeout, "http-read-timeout", 5*time.Second, "maximum duration for reading an entire request")
+	fs.DurationVar(&cfg.http.writeTimeout, "http-write-timeout", 10*time.Second, "maximum duration before timing out writes of a response")
+	fs.DurationVar(&cfg.http.idleTimeout, "http-idle-timeout", time.Minute, "maximum time to wait for the next request on a keep-alive connection")
+	fs.Int64Var(&cfg.http.maxBodyBytes, "http-max-body-bytes", defaultMaxBodyBytes, "maximum size of a request body in bytes")
+	fs.Var(prefixList{&cfg.http.trustedProxies}, "http-trusted-proxies", "proxies whose X-Forwarded-For and X-Real-IP headers are believed, space separated addresses or CIDR prefixes")
+
+	fs.StringVar(&cfg.tls.certFile, "tls-cert-file", "", "PEM certificate (chain) to serve HTTPS with; TLS is off when empty")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key-file", "", "PEM private key for -tls-cert-file")
+	fs.StringVar(&cfg.tls.clientCAFile, "tls-client-ca-file", "", "PEM bundle of CAs that sign client certificates")
+	fs.StringVar(&cfg.tls.clientAuth, "tls-client-auth", tlsconfig.ClientAuthNone, "client certificate policy (none|optional|require)")
+	fs.StringVar(&cfg.tls.minVersion, "tls-min-version", "1.2", "minimum TLS version (1.2|1.3)")
+	fs.Var(stringList{&cfg.tls.cipherSuites}, "tls-cipher-suites", "TLS 1.2 cipher suites, space separated IANA names (default: Go's secure defaults)")
+	fs.DurationVar(&cfg.tls.reloadInterval, "tls-reload-interval", 30*time.Second, "how often certificate files are checked for rotation")
+	fs.BoolVar(&cfg.tls.http2, "tls-http2", true, "offer HTTP/2 to TLS clients")
+
+	fs.DurationVar(&cfg.shutdown.timeout, "shutdown-timeout", 30*time.Second, "time allowed for in-flight requests to finish on shutdown")
+	fs.DurationVar(&cfg.shutdown.drainDelay, "shutdown-drain-delay", 5*time.Second, "time to keep serving with /readyz failing before shutting down")
+
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "postgreSQL DSN (user:pass@host:port/db?sslmode=disable)")
+	fs.BoolVar(&cfg.db.automigrate, "db-automigrate", true, "run migrations on startup")
+	poolFlags(fs, &cfg.db.pool, "db", "primary")
+
+	fs.Var(stringList{&cfg.db.replicaDSNs}, "db-replica-dsns", "read replica DSNs, space separated")
+	poolFlags(fs, &cfg.db.replicaPool, "db-replica", "each replica")
+	fs.DurationVar(&cfg.db.replicaCheckInterval, "db-replica-check-interval", database.DefaultReplicaCheckInterval, "how often replicas are health-checked")
+	fs.BoolVar(&cfg.db.readYourWrites, "db-read-your-writes", true, "send a request's reads to the primary once it has written")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", database.DefaultQueryTimeout, "maximum duration of a single database statement")
+	fs.DurationVar(&cfg.db.slowQueryThreshold, "db-slow-query-threshold", database.DefaultSlowQueryThreshold, "log database statements taking at least this long")
+
+	fs.BoolVar(&cfg.limiter.enabled, "ratelimit-enabled", true, "enable per-client rate limiting")
+	fs.DurationVar(&cfg.limiter.idleTimeout, "ratelimit-idle-timeout", 3*time.Minute, "forget clients idle for longer than this")
+	fs.Float64Var(&cfg.limiter.books.Rate, "ratelimit-books-rps", 10, "rate limiter tokens per second for /books routes")
+	fs.IntVar(&cfg.limiter.books.Burst, "ratelimit-books-burst", 20, "rate limiter burst for /books routes")
+	fs.Float64Var(&cfg.limiter.url.Rate, "ratelimit-url-rps", 2, "rate limiter tokens per second for /url and /shortlinks routes")
+	fs.IntVar(&cfg.limiter.url.Burst, "ratelimit-url-burst", 4, "rate limiter burst for /url and /shortlinks routes")
+	fs.Float64Var(&cfg.limiter.shortlinks.Rate, "ratelimit-shortlinks-follow-rps", 10, "rate limiter tokens per second for following short links")
+	fs.IntVar(&cfg.limiter.shortlinks.Burst, "ratelimit-shortlinks-follow-burst", 20, "rate limiter burst for following short links")
+
+	fs.Var(stringList{&cfg.cors.trustedOrigins}, "cors-trusted-origins", "trusted CORS origins, space separated")
+	fs.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", true, "allow credentialed CORS requests from trusted origins")
+	fs.DurationVar(&cfg.cors.maxAge, "cors-max-age", 10*time.Minute, "how long browsers may cache preflight responses")
+
+	fs.DurationVar(&cfg.idempotency.ttl, "idempotency-ttl", 24*time.Hour, "how long responses to requests with an Idempotency-Key are replayed")
+
+	fs.Var(stringList{&cfg.urlProcessor.hosts}, "url-hosts", "redirection host mapping, space separated source=target pairs; a source may be *.domain")
+	fs.StringVar(&cfg.urlProcessor.defaultHost, "url-default-host", "www.byfood.com", "host redirection uses for hosts missing from -url-hosts; empty keeps them")
+	fs.StringVar(&cfg.urlProcessor.scheme, "url-scheme", "", "scheme redirection enforces (http|https); empty keeps the URL's")
+	fs.Var(stringList{&cfg.urlProcessor.caseSensitivePaths}, "url-case-sensitive-paths", "host=/prefix pairs, space separated, after which redirection keeps the case of the path")
+	fs.StringVar(&cfg.urlProcessor.profilesFile, "url-profiles-file", "", "YAML or TOML file of named redirection profiles requests may select")
+	cfg.urlProcessor.queryDeny = slices.Clone(urlprocessor.TrackingParams)
+	fs.Var(stringList{&cfg.urlProcessor.queryAllow}, "url-query-allow", "query parameters canonicalization keeps, space separated; empty keeps all but -url-query-deny")
+	fs.Var(stringList{&cfg.urlProcessor.queryDeny}, "url-query-deny", "query parameters canonicalization removes, space separated; name* matches a prefix")
+	fs.StringVar(&cfg.urlProcessor.queryFile, "url-query-file", "", "YAML or TOML file of per-host query policies")
+	fs.IntVar(&cfg.urlProcessor.batchWorkers, "url-batch-workers", defaultURLBatchWorkers, "URLs a batch request processes concurrently")
+	fs.Int64Var(&cfg.urlProcessor.batchMaxBytes, "url-batch-max-bytes", defaultURLBatchMaxBytes, "maximum size of a batch request body in bytes, instead of -http-max-body-bytes")
+	fs.IntVar(&cfg.urlProcessor.resolveMaxHops, "url-resolve-max-hops", urlprocessor.DefaultResolveMaxHops, "redirects the resolve operation follows before giving up")
+	fs.DurationVar(&cfg.urlProcessor.resolveTimeout, "url-resolve-timeout", urlprocessor.DefaultResolveTimeout, "maximum duration of the requests resolving a URL")
+	fs.StringVar(&cfg.urlProcessor.resolveMethod, "url-resolve-method", urlprocessor.DefaultResolveMethod, "method the resolve operation requests with (HEAD|GET); HEAD falls back to GET")
+	fs.Var(stringList{&cfg.urlProcessor.resolveAllow}, "url-resolve-allow-networks", "private networks the resolve operation may reach anyway, space separated CIDR prefixes")
+	fs.DurationVar(&cfg.urlProcessor.rulesReload, "url-rules-reload-interval", 30*time.Second, "how often URL rules stored in the database are checked for changes made by other instances")
+
+	fs.IntVar(&cfg.shortlink.codeLength, "shortlink-code-length", shortlink.DefaultCodeLength, "length of generated short link codes")
+	cfg.shortlink.rules = slices.Clone(shortlink.DefaultRules)
+	fs.Var(stringList{&cfg.shortlink.rules}, "shortlink-rules", "URL processor rules canonicalizing URLs before they are shortened, space separated")
+
+	fs.StringVar(&cfg.tracing.exporter, "otel-exporter", tracing.ExporterNone, "trace exporter (none|stdout|otlp)")
+	fs.StringVar(&cfg.tracing.otlpEndpoint, "otel-otlp-endpoint", "localhost:4318", "host:port of the OTLP/HTTP trace collector")
+	fs.BoolVar(&cfg.tracing.otlpInsecure, "otel-otlp-insecure", true, "send traces to the OTLP collector over plain HTTP")
+	fs.Float64Var(&cfg.tracing.sampleRatio, "otel-sample-ratio", 1, "fraction of new traces to sample (0-1)")
+
+	return fs
+}
+
+func poolFlags(fs *flag.FlagSet, pool *database.Pool, prefix, name string) {
+	fs.IntVar(&pool.MaxOpenConns, prefix+"-max-open-conns", database.DefaultMaxOpenConns, "maximum number of open connections to the "+name+" database")
+	fs.IntVar(&pool.MaxIdleConns, prefix+"-max-idle-conns", database.DefaultMaxIdleConns, "maximum number of idle connections to the "+name+" database")
+	fs.DurationVar(&pool.ConnMaxIdleTime, prefix+"-conn-max-idle-time", database.DefaultConnMaxIdleTime, "close "+name+" database connections idle for longer than this")
+	fs.DurationVar(&pool.ConnMaxLifetime, prefix+"-conn-max-lifetime", database.DefaultConnMaxLifetime, "close "+name+" database connections older than this")
+}
+
+// loadConfig builds the configuration from, in increasing order of
+// precedence, flag defaults, the config file, BOOKCRUD_* environment
+// variables and command line flags. Every problem found is reported in the
+// returned error rather than just the first one.
+func loadConfig(args []string, getenv func(string) string) (config, *flag.FlagSet, error) {
+	var cfg config
+	fs := newFlagSet(&cfg)
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, fs, err
+	}
+
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if cfg.file == "" {
+		cfg.file = getenv(envPrefix + "CONFIG")
+	}
+
+	var errs []error
+
+	set := func(source, name, value string) {
+		if explicit[name] {
+			return
+		}
+		if err := fs.Set(name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", source, name, err))
+		}
+	}
+
+	if cfg.file != "" {
+		values, err := readConfigFile(cfg.file)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, name := range sortedKeys(values) {
+			switch {
+			case fs.Lookup(name) == nil || slices.Contains(cliOnlyFlags, name):
+				errs = append(errs, fmt.Errorf("%s: unknown setting %q", cfg.file, name))
+			default:
+				set(cfg.file, name, values[name])
+			}
+		}
+	}
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(cliOnlyFlags, f.Name) {
+			return
+		}
+		if value, ok := lookupEnv(getenv, envName(f.Name)); ok {
+			set(envName(f.Name), f.Name, value)
+		}
+	})
+
+	errs = append(errs, cfg.validate()...)
+
+	return cfg, fs, errors.Join(errs...)
+}
+
+func (cfg config) validate() []error {
+	var v validator.Validator
+
+	if u, err := url.Parse(cfg.baseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		v.AddFieldError("base-url", "must be an absolute URL")
+	}
+
+	validFormats := []string{logging.FormatText, logging.FormatJSON, logging.FormatLogfmt}
+	v.CheckField(slices.Contains(validFormats, cfg.log.format), "log-format", "must be one of 'text', 'json' or 'logfmt'")
+	if _, err := logging.ParseLevel(cfg.log.level); err != nil {
+		v.AddFieldError("log-level", err.Error())
+	}
+	v.CheckField(cfg.log.accessSampleRatio >= 0 && cfg.log.accessSampleRatio <= 1, "log-access-sample-ratio", "must be between 0 and 1")
+
+	v.CheckField(cfg.http.port > 0 && cfg.http.port <= 65535, "http-port", "must be between 1 and 65535")
+	v.CheckField(cfg.http.readTimeout > 0, "http-read-timeout", "must be greater than zero")
+	v.CheckField(cfg.http.writeTimeout > 0, "http-write-timeout", "must be greater than zero")
+	v.CheckField(cfg.http.idleTimeout > 0, "http-idle-timeout", "must be greater than zero")
+	v.CheckField(cfg.http.maxBodyBytes > 0, "http-max-body-bytes", "must be greater than zero")
+
+	v.CheckField((cfg.tls.certFile == "") == (cfg.tls.keyFile == ""), "tls-key-file", "tls-cert-file and tls-key-file must be set together")
+	if _, err := tlsconfig.ParseMinVersion(cfg.tls.minVersion); err != nil {
+		v.AddFieldError("tls-min-version", err.Error())
+	}
+	if _, err := tlsconfig.ParseCipherSuites(cfg.tls.cipherSuites); err != nil {
+		v.AddFieldError("tls-cipher-suites", err.Error())
+	}
+	if _, err := tlsconfig.ParseClientAuth(cfg.tls.clientAuth); err != nil {
+		v.AddFieldError("tls-client-auth", err.Error())
+	}
+	if cfg.tls.clientAuth != "" && cfg.tls.clientAuth != tlsconfig.ClientAuthNone {
+		v.CheckField(cfg.tls.certFile != "", "tls-client-auth", "requires tls-cert-file and tls-key-file")
+		v.CheckField(cfg.tls.clientCAFile != "", "tls-client-ca-file", "must be provided when tls-client-auth is not 'none'")
+	}
+	v.CheckField(cfg.tls.reloadInterval > 0, "tls-reload-interval", "must be greater than zero")
+
+	v.CheckField(cfg.shutdown.timeout > 0, "shutdown-timeout", "must be greater than zero")
+	v.CheckField(cfg.shutdown.drainDelay >= 0, "shutdown-drain-delay", "must not be negative")
+
+	v.CheckField(cfg.db.dsn != "", "db-dsn", "must be provided")
+	checkPool(&v, cfg.db.pool, "db")
+	checkPool(&v, cfg.db.replicaPool, "db-replica")
+	for _, dsn := range cfg.db.replicaDSNs {
+		if _, err := url.Parse("postgres://" + dsn); err != nil {
+			v.AddFieldError("db-replica-dsns", "must be DSNs like user:pass@host:port/db")
+		}
+	}
+	v.CheckField(cfg.db.replicaCheckInterval > 0, "db-replica-check-interval", "must be greater than zero")
+	v.CheckField(cfg.db.queryTimeout > 0, "db-query-timeout", "must be greater than zero")
+	v.CheckField(cfg.db.slowQueryThreshold > 0, "db-slow-query-threshold", "must be greater than zero")
+
+	v.CheckField(cfg.limiter.idleTimeout > 0, "ratelimit-idle-timeout", "must be greater than zero")
+	v.CheckField(cfg.limiter.books.Rate >= 0, "ratelimit-books-rps", "must not be negative")
+	v.CheckField(cfg.limiter.books.Burst >= 0, "ratelimit-books-burst", "must not be negative")
+	v.CheckField(cfg.limiter.url.Rate >= 0, "ratelimit-url-rps", "must not be negative")
+	v.CheckField(cfg.limiter.url.Burst >= 0, "ratelimit-url-burst", "must not be negative")
+	v.CheckField(cfg.limiter.shortlinks.Rate >= 0, "ratelimit-shortlinks-follow-rps", "must not be negative")
+	v.CheckField(cfg.limiter.shortlinks.Burst >= 0, "ratelimit-shortlinks-follow-burst", "must not be negative")
+
+	for _, origin := range cfg.cors.trustedOrigins {
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			v.AddFieldError("cors-trusted-origins", fmt.Sprintf("%q is not an origin like https://example.com", origin))
+		}
+	}
+	v.CheckField(!cfg.cors.allowCredentials || !slices.Contains(cfg.cors.trustedOrigins, "*"), "cors-trusted-origins", "must not contain '*' when cors-allow-credentials is true")
+	v.CheckField(cfg.cors.maxAge >= 0, "cors-max-age", "must not be negative")
+
+	v.CheckField(cfg.idempotency.ttl > 0, "idempotency-ttl", "must be greater than zero")
+
+	if _, err := urlprocessor.ParseHosts(cfg.urlProcessor.hosts); err != nil {
+		v.AddFieldError("url-hosts", err.Error())
+	}
+	if cfg.urlProcessor.defaultHost != "" {
+		if err := urlprocessor.ValidateHost(cfg.urlProcessor.defaultHost); err != nil {
+			v.AddFieldError("url-default-host", err.Error())
+		}
+	}
+	if err := urlprocessor.ValidateScheme(cfg.urlProcessor.scheme); err != nil {
+		v.AddFieldError("url-scheme", err.Error())
+	}
+	if _, err := urlprocessor.ParseCaseSensitivePaths(cfg.urlProcessor.caseSensitivePaths); err != nil {
+		v.AddFieldError("url-case-sensitive-paths", err.Error())
+	}
+	v.CheckField(cfg.urlProcessor.batchWorkers > 0, "url-batch-workers", "must be greater than zero")
+	v.CheckField(cfg.urlProcessor.batchMaxBytes > 0, "url-batch-max-bytes", "must be greater than zero")
+	v.CheckField(cfg.urlProcessor.resolveMaxHops > 0, "url-resolve-max-hops", "must be greater than zero")
+	v.CheckField(cfg.urlProcessor.resolveTimeout > 0, "url-resolve-timeout", "must be greater than zero")
+	if err := urlprocessor.ValidateResolveMethod(cfg.urlProcessor.resolveMethod); err != nil {
+		v.AddFieldError("url-resolve-method", err.Error())
+	}
+	if _, err := urlprocessor.ParseNetworks(cfg.urlProcessor.resolveAllow); err != nil {
+		v.AddFieldError("url-resolve-allow-networks", err.Error())
+	}
+	v.CheckField(cfg.urlProcessor.rulesReload > 0, "url-rules-reload-interval", "must be greater than zero")
+
+	v.CheckField(cfg.shortlink.codeLength >= 4 && cfg.shortlink.codeLength <= 32, "shortlink-code-length", "must be between 4 and 32")
+	v.CheckField(len(cfg.shortlink.rules) > 0, "shortlink-rules", "must not be empty")
+
+	validExporters := []string{tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP}
+	v.CheckField(slices.Contains(validExporters, cfg.tracing.exporter), "otel-exporter", "must be one of 'none', 'stdout' or 'otlp'")
+	v.CheckField(cfg.tracing.exporter != tracing.ExporterOTLP || cfg.tracing.otlpEndpoint != "", "otel-otlp-endpoint", "must be provided when otel-exporter is 'otlp'")
+	v.CheckField(cfg.tracing.sampleRatio >= 0 && cfg.tracing.sampleRatio <= 1, "otel-sample-ratio", "must be between 0 and 1")
+
+	var errs []error
+	for _, name := range sortedKeys(v.FieldErrors) {
+		errs = append(errs, fmt.Errorf("invalid %s: %s", name, v.FieldErrors[name]))
+	}
+	return errs
+}
+
+func checkPool(v *validator.Validator, pool database.Pool, prefix string) {
+	v.CheckField(pool.MaxOpenConns > 0, prefix+"-max-open-conns", "must be greater than zero")
+	v.CheckField(pool.MaxIdleConns > 0, prefix+"-max-idle-conns", "must be greater than zero")
+	v.CheckField(pool.MaxIdleConns <= pool.MaxOpenConns, prefix+"-max-idle-conns", "must not exceed "+prefix+"-max-open-conns")
+	v.CheckField(pool.ConnMaxIdleTime > 0, prefix+"-conn-max-idle-time", "must be greater than zero")
+	v.CheckField(pool.ConnMaxLifetime > 0, prefix+"-conn-max-lifetime", "must be greater than zero")
+}
+
+// printConfig writes the effective value of every setting, with secrets
+// redacted.
+func printConfig(w io.Writer, fs *flag.FlagSet) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if slices.Contains(cliOnlyFlags, f.Name) {
+			return
+		}
+
+		value := f.Value.String()
+		if redact, ok := secretFlags[f.Name]; ok && value != "" {
+			value = redact(value)
+		}
+
+		fmt.Fprintf(w, "%s=%q\n", f.Name, value)
+	})
+}
+
+// readConfigFile reads a YAML or TOML file, chosen by extension, and
+// flattens nested tables into flag names: {db: {max_open_conns: 10}} sets
+// -db-max-open-conns.
+func readConfigFile(path string) (map[string]string, error) {
+	var raw map[string]any
+	if err := decodeFile(path, &raw); err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	flatten(values, "", raw)
+	return values, nil
+}
+
+// decodeFile unmarshals the YAML or TOML file at path, chosen by extension,
+// into v.
+func decodeFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, v)
+	case ".toml":
+		err = toml.Unmarshal(data, v)
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q (use .yaml, .yml or .toml)", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// urlProfile is a redirection profile as written in -url-profiles-file, keyed
+// by its name:
+//
+//	tabelog:
+//	  hosts:
+//	    "*.tabelog.com": tabelog.com
+//	  default_host: tabelog.com
+//	  scheme: https
+//	  case_sensitive_paths:
+//	    tabelog.com: [/share/]
+type urlProfile struct {
+	Hosts              map[string]string   `yaml:"hosts" toml:"hosts"`
+	DefaultHost        string              `yaml:"default_host" toml:"default_host"`
+	Scheme             string              `yaml:"scheme" toml:"scheme"`
+	CaseSensitivePaths map[string][]string `yaml:"case_sensitive_paths" toml:"case_sensitive_paths"`
+}
+
+// urlQueryPolicy is a query policy as written in -url-query-file, keyed by
+// host or *.domain pattern:
+//
+//	shop.example.com:
+//	  allow: [id, page]
+//	static.example.com:
+//	  drop_all: true
+type urlQueryPolicy struct {
+	Allow   []string `yaml:"allow" toml:"allow"`
+	Deny    []string `yaml:"deny" toml:"deny"`
+	DropAll bool     `yaml:"drop_all" toml:"drop_all"`
+}
+
+// urlProcessorConfig builds the URL processor configuration: the default
+// profile and query policy from the -url-* flags, named profiles from
+// -url-profiles-file and per-host query policies from -url-query-file.
+func (cfg config) urlProcessorConfig() (urlprocessor.Config, error) {
+	hosts, err := urlprocessor.ParseHosts(cfg.urlProcessor.hosts)
+	if err != nil {
+		return urlprocessor.Config{}, err
+	}
+	caseSensitivePaths, err := urlprocessor.ParseCaseSensitivePaths(cfg.urlProcessor.caseSensitivePaths)
+	if err != nil {
+		return urlprocessor.Config{}, err
+	}
+	allowedNetworks, err := urlprocessor.ParseNetworks(cfg.urlProcessor.resolveAllow)
+	if err != nil {
+		return urlprocessor.Config{}, err
+	}
+
+	upCfg := urlprocessor.Config{
+		Default: urlprocessor.Profile{
+			Hosts:       hosts,
+			DefaultHost: cfg.urlProcessor.defaultHost,
+			Scheme:      cfg.urlProcessor.scheme,
+
+			CaseSensitivePaths: caseSensitivePaths,
+		},
+		Query: urlprocessor.QueryRules{
+			Default: urlprocessor.QueryPolicy{
+				Allow: cfg.urlProcessor.queryAllow,
+				Deny:  cfg.urlProcessor.queryDeny,
+			},
+		},
+		Resolve: urlprocessor.ResolveConfig{
+			MaxHops:         cfg.urlProcessor.resolveMaxHops,
+			Timeout:         cfg.urlProcessor.resolveTimeout,
+			Method:          cfg.urlProcessor.resolveMethod,
+			AllowedNetworks: allowedNetworks,
+		},
+	}
+
+	if cfg.urlProcessor.profilesFile != "" {
+		var profiles map[string]urlProfile
+		if err := decodeFile(cfg.urlProcessor.profilesFile, &profiles); err != nil {
+			return urlprocessor.Config{}, err
+		}
+
+		upCfg.Profiles = make(map[string]urlprocessor.Profile, len(profiles))
+		for name, p := range profiles {
+			upCfg.Profiles[name] = urlprocessor.Profile(p)
+		}
+	}
+
+	if cfg.urlProcessor.queryFile != "" {
+		var policies map[string]urlQueryPolicy
+		if err := decodeFile(cfg.urlProcessor.queryFile, &policies); err != nil {
+			return urlprocessor.Config{}, err
+		}
+
+		upCfg.Query.Hosts = make(map[string]urlprocessor.QueryPolicy, len(policies))
+		for host, p := range policies {
+			upCfg.Query.Hosts[host] = urlprocessor.QueryPolicy(p)
+		}
+	}
+
+	return upCfg, nil
+}
+
+func flatten(dst map[string]string, prefix string, value any) {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, child := range v {
+			name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+			if prefix != "" {
+				name = prefix + "-" + name
+			}
+			flatten(dst, name, child)
+		}
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		dst[prefix] = strings.Join(items, " ")
+	default:
+		dst[prefix] = fmt.Sprint(v)
+	}
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func lookupEnv(getenv func(string) string, name string) (string, bool) {
+	value := getenv(name)
+	return value, value != ""
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// redactDSN hides the password in a user:pass@host/db DSN.
+func redactDSN(dsn string) string {
+	u, err := url.Parse("postgres://" + dsn)
+	if err != nil || u.User == nil {
+		return "xxxxx"
+	}
+
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+
+	return strings.TrimPrefix(u.String(), "postgres://")
+}
+
+func redactDSNs(dsns string) string {
+	fields := strings.Fields(dsns)
+	for i, dsn := range fields {
+		fields[i] = redactDSN(dsn)
+	}
+	return strings.Join(fields, " ")
+}
+
+// stringList is a flag.Value holding a space separated list.
+type stringList struct {
+	values *[]string
+}
+
+func (l stringList) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, " ")
+}
+
+func (l stringList) Set(value string) error {
+	*l.values = strings.Fields(value)
+	return nil
+}
+
+// prefixList is a flag.Value holding a space separated list of CIDR
+// prefixes. A bare address stands for a prefix of just that address.
+type prefixList struct {
+	values *[]netip.Prefix
+}
+
+func (l prefixList) String() string {
+	if l.values == nil {
+		return ""
+	}
+
+	fields := make([]string, len(*l.values))
+	for i, prefix := range *l.values {
+		fields[i] = prefix.String()
+	}
+	return strings.Join(fields, " ")
+}
+
+func (l prefixList) Set(value string) error {
+	var prefixes []netip.Prefix
+
+	for _, field := range strings.Fields(value) {
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(field)
+			if addrErr != nil {
+				return fmt.Errorf("%q is not an address or a CIDR prefix", field)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	*l.values = prefixes
+	return nil
+}
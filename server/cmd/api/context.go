@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+type contextKey string
+
+const (
+	identityContextKey = contextKey("identity")
+	routeContextKey    = contextKey("route")
+)
+
+// routeInfo is filled in by withRoute once httprouter has matched a request,
+// so that middleware running outside the router can see the route pattern.
+type routeInfo struct {
+	pattern string
+}
+
+func contextSetIdentity(r *http.Request, identity string) *http.Request {
+	ctx := context.WithValue(r.Context(), identityContextKey, identity)
+	return r.WithContext(ctx)
+}
+
+func contextGetIdentity(r *http.Request) string {
+	identity, _ := r.Context().Value(identityContextKey).(string)
+	return identity
+}
+
+func contextSetRouteInfo(r *http.Request, info *routeInfo) *http.Request {
+	ctx := context.WithValue(r.Context(), routeContextKey, info)
+	return r.WithContext(ctx)
+}
+
+func contextGetRouteInfo(r *http.Request) *routeInfo {
+	info, _ := r.Context().Value(routeContextKey).(*routeInfo)
+	return info
+}
+
+// clientKey identifies the caller of a request: the authenticated identity
+// when there is one, and the client IP address otherwise.
+func (app *application) clientKey(r *http.Request) string {
+	if identity := contextGetIdentity(r); identity != "" {
+		return "identity:" + identity
+	}
+	return "ip:" + app.clientIP(r)
+}
+
+// clientIP returns the address of the client that sent a request. The
+// X-Forwarded-For and X-Real-IP headers are only believed when the peer is
+// one of the http-trusted-proxies, since anyone else can forge them.
+// X-Forwarded-For is read from the nearest hop backwards, skipping trusted
+// proxies, so that addresses the client prepended itself are never used.
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !app.isTrustedProxy(peer) {
+		return host
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			host = hop.Unmap().String()
+			if !app.isTrustedProxy(hop) {
+				break
+			}
+		}
+		return host
+	}
+
+	if realIP, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return realIP.Unmap().String()
+	}
+
+	return host
+}
+
+func (app *application) isTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return slices.ContainsFunc(app.config.http.trustedProxies, func(prefix netip.Prefix) bool {
+		return prefix.Contains(addr)
+	})
+}
@@ -19,6 +19,92 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/log-level": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Reports the minimum level of records currently logged",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Show the log level",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LogLevel"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Changes the minimum level of logged records until the next restart or SIGHUP",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Change the log level",
+                "parameters": [
+                    {
+                        "description": "New level: debug, info, warn or error",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.LogLevel"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LogLevel"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
         "/books": {
             "get": {
                 "produces": [
@@ -41,10 +127,7 @@ const docTemplate = `{
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     }
                 }
@@ -70,6 +153,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.NewBookRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key that makes retries of this request safe",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -82,17 +171,25 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "422": {
                         "description": "Unprocessable Entity",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
                         }
                     }
                 }
@@ -126,28 +223,19 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     }
                 }
@@ -191,34 +279,25 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "422": {
                         "description": "Unprocessable Entity",
                         "schema": {
-                            "$ref": "#/definitions/validator.Validator"
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     }
                 }
@@ -244,23 +323,37 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Reports that the process is up and able to serve HTTP requests",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -271,9 +364,85 @@ const docTemplate = `{
                 }
             }
         },
-        "/url/process": {
+        "/readyz": {
+            "get": {
+                "description": "Reports whether the server should receive traffic: the database answers, migrations are up to date and the server is not shutting down",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness probe",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReadinessResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ReadinessResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/s/{code}": {
+            "get": {
+                "description": "Redirects to the URL of a short link and counts the hit: with 301 for links that never expire and 302 for links that do. Responses are not cached, so every hit is counted.",
+                "tags": [
+                    "shortlinks"
+                ],
+                "summary": "Follow a short link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Code of the short link",
+                        "name": "code",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "301": {
+                        "description": "Moved Permanently"
+                    },
+                    "302": {
+                        "description": "Found"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/shortlinks": {
             "post": {
-                "description": "Canonicalize or redirect-normalize a URL",
+                "description": "Shortens the canonical form of a URL: normalized, with tracking parameters and the fragment removed. A link without an alias or expiry is shared by every request for the same canonical URL, which gets the existing link back with status 200.",
                 "consumes": [
                     "application/json"
                 ],
@@ -281,125 +450,959 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "url"
+                    "shortlinks"
                 ],
-                "summary": "Process a URL",
+                "summary": "Create a short link",
                 "parameters": [
                     {
-                        "description": "URL payload",
+                        "description": "URL to shorten",
                         "name": "payload",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/main.URLRequest"
+                            "$ref": "#/definitions/main.ShortLinkRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key that makes retries of this request safe",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.URLResponse"
+                            "$ref": "#/definitions/main.ShortLinkResponse"
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.ShortLinkResponse"
                         }
                     },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "422": {
                         "description": "Unprocessable Entity",
                         "schema": {
-                            "$ref": "#/definitions/validator.Validator"
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/response.Problem"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "book.Book": {
-            "type": "object",
-            "properties": {
-                "author": {
-                    "type": "string"
-                },
-                "dateCreated": {
-                    "type": "string"
-                },
-                "dateUpdated": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "string"
-                },
-                "title": {
-                    "type": "string"
-                },
-                "version": {
-                    "type": "integer"
-                },
-                "year": {
-                    "type": "integer"
-                }
-            }
         },
-        "main.BookResponse": {
-            "type": "object",
-            "properties": {
-                "author": {
-                    "type": "string"
-                },
-                "date_created": {
-                    "type": "string"
-                },
-                "date_updated": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "string"
-                },
-                "title": {
-                    "type": "string"
-                },
-                "year": {
-                    "type": "integer"
-                }
-            }
+        "/shortlinks/{code}": {
+            "get": {
+                "description": "Reports where a short link redirects and how often it was followed",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "shortlinks"
+                ],
+                "summary": "Show a short link",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Code of the short link",
+                        "name": "code",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ShortLinkResponse"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/url/process": {
+            "post": {
+                "description": "Canonicalize, redirect-normalize or RFC 3986 normalize a URL, resolve where its HTTP redirects end, or run it through an ordered pipeline of named rules instead of an operation. explain returns a trace of every rule applied. Redirection maps the host with the default profile, the named profile, or target_host when given.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "url"
+                ],
+                "summary": "Process a URL",
+                "parameters": [
+                    {
+                        "description": "URL payload",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key that makes retries of this request safe",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.URLResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/url/process/batch": {
+            "post": {
+                "description": "Processes many URLs, sent as a JSON array, NDJSON or plain text with one URL per line. Entries are strings or URLRequest objects; the query parameters set the operation, rules and options of entries that do not set their own. Results stream back as NDJSON in input order, one URLBatchResult per entry. An entry that fails carries its error and the batch goes on; a result without a line reports an error that ended the batch.",
+                "consumes": [
+                    "application/json",
+                    "application/x-ndjson",
+                    "text/plain"
+                ],
+                "produces": [
+                    "application/x-ndjson"
+                ],
+                "tags": [
+                    "url"
+                ],
+                "summary": "Process a batch of URLs",
+                "parameters": [
+                    {
+                        "description": "URLs or URL requests",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    {
+                        "enum": [
+                            "canonical",
+                            "redirection",
+                            "all",
+                            "normalize"
+                        ],
+                        "type": "string",
+                        "description": "operation for entries without one",
+                        "name": "operation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated rules for entries without an operation",
+                        "name": "rules",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "profile for entries without one",
+                        "name": "profile",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "target host for entries without one",
+                        "name": "target_host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "return the Unicode form of every URL",
+                        "name": "unicode",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "return a trace for every URL",
+                        "name": "explain",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.URLBatchResult"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/url/rules": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Lists the stored URL rules by kind, path rules in the order they are tried",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "url-rules"
+                ],
+                "summary": "List URL rules",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.URLRuleResponse"
+                            }
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Stores a host mapping, query policy or path rewrite the URL processor follows on top of its configuration, from the next URL processed on. Host and query rules win over configured ones for the same host; there is at most one of each per host.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "url-rules"
+                ],
+                "summary": "Create a URL rule",
+                "parameters": [
+                    {
+                        "description": "Rule to store",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRuleRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Key that makes retries of this request safe",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRuleResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/url/rules/dry-run": {
+            "post": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Processes URLs with the stored rules and with them changed, without storing anything: rule added, or in place of the rule with the given id; id alone removes that rule. Every URL reports both outcomes and whether they differ.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "url-rules"
+                ],
+                "summary": "Try out a change to the URL rules",
+                "parameters": [
+                    {
+                        "description": "Change and URLs to try it on",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRuleDryRunRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRuleDryRunResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/url/rules/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "url-rules"
+                ],
+                "summary": "Show a URL rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rule ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRuleResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Changes the fields of a rule that the payload sets and applies the changed rule. The kind of a rule never changes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "url-rules"
+                ],
+                "summary": "Update a URL rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rule ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Fields to change",
+                        "name": "payload",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRuleUpdateRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.URLRuleResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "AdminToken": []
+                    }
+                ],
+                "description": "Removes a rule and stops applying it",
+                "tags": [
+                    "url-rules"
+                ],
+                "summary": "Delete a URL rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Rule ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/response.Problem"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "book.Book": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "dateCreated": {
+                    "type": "string"
+                },
+                "dateUpdated": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                },
+                "year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.BookResponse": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "date_created": {
+                    "type": "string"
+                },
+                "date_updated": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.HealthCheck": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "latency_ms": {
+                    "type": "number"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.LogLevel": {
+            "type": "object",
+            "properties": {
+                "level": {
+                    "type": "string",
+                    "example": "INFO"
+                }
+            }
+        },
+        "main.NewBookRequest": {
+            "type": "object",
+            "properties": {
+                "author": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "year": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.ReadinessResponse": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.HealthCheck"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
         },
-        "main.NewBookRequest": {
+        "main.ShortLinkRequest": {
             "type": "object",
             "properties": {
-                "author": {
+                "alias": {
+                    "type": "string",
+                    "example": "spring-sale"
+                },
+                "expires_at": {
                     "type": "string"
                 },
-                "title": {
+                "url": {
+                    "type": "string",
+                    "example": "https://www.byfood.com/food-experiences?utm_source=x"
+                }
+            }
+        },
+        "main.ShortLinkResponse": {
+            "type": "object",
+            "properties": {
+                "alias": {
+                    "type": "boolean"
+                },
+                "code": {
+                    "type": "string",
+                    "example": "aZ3k9Qx"
+                },
+                "date_created": {
                     "type": "string"
                 },
-                "year": {
+                "expires_at": {
+                    "type": "string"
+                },
+                "hits": {
+                    "type": "integer"
+                },
+                "permanent": {
+                    "type": "boolean"
+                },
+                "short_url": {
+                    "type": "string",
+                    "example": "http://localhost:4748/s/aZ3k9Qx"
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://www.byfood.com/food-experiences"
+                }
+            }
+        },
+        "main.URLBatchResult": {
+            "type": "object",
+            "properties": {
+                "chain": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.URLHop"
+                    }
+                },
+                "error": {
+                    "$ref": "#/definitions/response.Problem"
+                },
+                "line": {
                     "type": "integer"
+                },
+                "processed_url": {
+                    "type": "string"
+                },
+                "query_policy": {
+                    "type": "string"
+                },
+                "trace": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.URLStep"
+                    }
+                },
+                "unicode_url": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.URLHop": {
+            "type": "object",
+            "properties": {
+                "method": {
+                    "type": "string",
+                    "example": "HEAD"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 301
+                },
+                "url": {
+                    "type": "string",
+                    "example": "http://byfood.com/"
+                }
+            }
+        },
+        "main.URLOutcome": {
+            "type": "object",
+            "properties": {
+                "chain": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.URLHop"
+                    }
+                },
+                "error": {
+                    "$ref": "#/definitions/response.Problem"
+                },
+                "processed_url": {
+                    "type": "string"
+                },
+                "query_policy": {
+                    "type": "string"
+                },
+                "trace": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.URLStep"
+                    }
+                },
+                "unicode_url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.URLQueryPolicy": {
+            "type": "object",
+            "properties": {
+                "allow": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "id",
+                        "page"
+                    ]
+                },
+                "deny": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "utm_*"
+                    ]
+                },
+                "drop_all": {
+                    "type": "boolean"
                 }
             }
         },
         "main.URLRequest": {
             "type": "object",
             "properties": {
+                "explain": {
+                    "type": "boolean"
+                },
                 "operation": {
                     "type": "string"
                 },
+                "profile": {
+                    "type": "string"
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "strip_tracking",
+                        "lowercase_path",
+                        "force_host"
+                    ]
+                },
+                "target_host": {
+                    "type": "string"
+                },
+                "unicode": {
+                    "type": "boolean"
+                },
                 "url": {
                     "type": "string"
                 }
@@ -408,8 +1411,216 @@ const docTemplate = `{
         "main.URLResponse": {
             "type": "object",
             "properties": {
+                "chain": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.URLHop"
+                    }
+                },
                 "processed_url": {
                     "type": "string"
+                },
+                "query_policy": {
+                    "type": "string"
+                },
+                "trace": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.URLStep"
+                    }
+                },
+                "unicode_url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.URLRuleDryRunRequest": {
+            "type": "object",
+            "properties": {
+                "explain": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "operation": {
+                    "type": "string"
+                },
+                "rule": {
+                    "$ref": "#/definitions/main.URLRuleRequest"
+                },
+                "rules": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "urls": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "https://www.byfood.com/items/42"
+                    ]
+                }
+            }
+        },
+        "main.URLRuleDryRunResponse": {
+            "type": "object",
+            "properties": {
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.URLRuleDryRunResult"
+                    }
+                }
+            }
+        },
+        "main.URLRuleDryRunResult": {
+            "type": "object",
+            "properties": {
+                "changed": {
+                    "type": "boolean"
+                },
+                "current": {
+                    "$ref": "#/definitions/main.URLOutcome"
+                },
+                "proposed": {
+                    "$ref": "#/definitions/main.URLOutcome"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.URLRuleRequest": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string",
+                    "example": "www.byfood.com"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "path"
+                },
+                "pattern": {
+                    "type": "string",
+                    "example": "^/items/(\\d+)$"
+                },
+                "priority": {
+                    "type": "integer"
+                },
+                "query": {
+                    "$ref": "#/definitions/main.URLQueryPolicy"
+                },
+                "replacement": {
+                    "type": "string",
+                    "example": "/products/$1"
+                },
+                "target_host": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.URLRuleResponse": {
+            "type": "object",
+            "properties": {
+                "date_created": {
+                    "type": "string"
+                },
+                "date_updated": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string",
+                    "example": "www.byfood.com"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "kind": {
+                    "type": "string",
+                    "example": "path"
+                },
+                "pattern": {
+                    "type": "string",
+                    "example": "^/items/(\\d+)$"
+                },
+                "priority": {
+                    "type": "integer"
+                },
+                "query": {
+                    "$ref": "#/definitions/main.URLQueryPolicy"
+                },
+                "replacement": {
+                    "type": "string",
+                    "example": "/products/$1"
+                },
+                "target_host": {
+                    "type": "string"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.URLRuleUpdateRequest": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "host": {
+                    "type": "string"
+                },
+                "pattern": {
+                    "type": "string"
+                },
+                "priority": {
+                    "type": "integer"
+                },
+                "query": {
+                    "$ref": "#/definitions/main.URLQueryPolicy"
+                },
+                "replacement": {
+                    "type": "string"
+                },
+                "target_host": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.URLStep": {
+            "type": "object",
+            "properties": {
+                "after": {
+                    "type": "string",
+                    "example": "https://www.byfood.com/food"
+                },
+                "before": {
+                    "type": "string",
+                    "example": "https://BYFOOD.com/food"
+                },
+                "changed": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "host"
+                    ]
+                },
+                "rule": {
+                    "type": "string",
+                    "example": "force_host"
                 }
             }
         },
@@ -427,23 +1638,54 @@ const docTemplate = `{
                 }
             }
         },
-        "validator.Validator": {
+        "response.InvalidParam": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "response.Problem": {
             "type": "object",
             "properties": {
-                "errors": {
+                "detail": {
+                    "type": "string"
+                },
+                "instance": {
+                    "type": "string"
+                },
+                "invalid_params": {
                     "type": "array",
                     "items": {
-                        "type": "string"
+                        "$ref": "#/definitions/response.InvalidParam"
                     }
                 },
-                "fieldErrors": {
-                    "type": "object",
-                    "additionalProperties": {
-                        "type": "string"
-                    }
+                "request_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "AdminToken": {
+            "description": "\"Bearer\" followed by the -admin-token value",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
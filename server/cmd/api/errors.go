@@ -5,12 +5,25 @@ import (
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/Babatunde50/book-crud/server/internal/requestid"
 	"github.com/Babatunde50/book-crud/server/internal/response"
 	"github.com/Babatunde50/book-crud/server/internal/validator"
 )
 
+// Problem type URIs for errors that carry more meaning than their status
+// code. They are relative references, resolved against the request URL.
+const (
+	problemTypeValidation          = "/problems/validation-error"
+	problemTypeRateLimited         = "/problems/rate-limited"
+	problemTypeIdempotencyConflict = "/problems/idempotency-conflict"
+	problemTypeIdempotencyReused   = "/problems/idempotency-key-reused"
+)
+
 func (app *application) reportServerError(r *http.Request, err error) {
 	var (
 		message = err.Error()
@@ -20,19 +33,46 @@ func (app *application) reportServerError(r *http.Request, err error) {
 	)
 
 	requestAttrs := slog.Group("request", "method", method, "url", url)
-	app.logger.Error(message, requestAttrs, "trace", trace)
+	app.logger.ErrorContext(r.Context(), message, requestAttrs, "trace", trace)
 }
 
-func (app *application) errorMessage(w http.ResponseWriter, r *http.Request, status int, message string, headers http.Header) {
-	message = strings.ToUpper(message[:1]) + message[1:]
+// errorFormatHeader lets a client that still expects the {"Error": "..."} and
+// {"FieldErrors": {...}} bodies of earlier versions ask for them by sending
+// the value "legacy". Every other client gets application/problem+json.
+const errorFormatHeader = "X-Error-Format"
+
+func wantsLegacyErrors(r *http.Request) bool {
+	return strings.EqualFold(strings.TrimSpace(r.Header.Get(errorFormatHeader)), "legacy")
+}
+
+// problem writes an application/problem+json error response, or the
+// {"Error": "..."} body older clients expect when the request asks for legacy
+// errors.
+func (app *application) problem(w http.ResponseWriter, r *http.Request, problem response.Problem, headers http.Header) {
+	var err error
+
+	w.Header().Add("Vary", errorFormatHeader)
+
+	if wantsLegacyErrors(r) {
+		err = response.JSONWithHeaders(w, problem.Status, map[string]string{"Error": problem.Detail}, headers)
+	} else {
+		problem.Instance = r.URL.Path
+		problem.RequestID = requestid.FromContext(r.Context())
+		err = response.ProblemJSON(w, problem, headers)
+	}
 
-	err := response.JSONWithHeaders(w, status, map[string]string{"Error": message}, headers)
 	if err != nil {
 		app.reportServerError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
 	}
 }
 
+func (app *application) errorMessage(w http.ResponseWriter, r *http.Request, status int, message string, headers http.Header) {
+	message = strings.ToUpper(message[:1]) + message[1:]
+
+	app.problem(w, r, response.NewProblem(status, message), headers)
+}
+
 func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
 	app.reportServerError(r, err)
 
@@ -55,8 +95,75 @@ func (app *application) badRequest(w http.ResponseWriter, r *http.Request, err e
 }
 
 func (app *application) failedValidation(w http.ResponseWriter, r *http.Request, v validator.Validator) {
-	err := response.JSON(w, http.StatusUnprocessableEntity, v)
-	if err != nil {
-		app.serverError(w, r, err)
+	if wantsLegacyErrors(r) {
+		w.Header().Add("Vary", errorFormatHeader)
+
+		err := response.JSON(w, http.StatusUnprocessableEntity, v)
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.problem(w, r, validationProblem(v), nil)
+}
+
+func validationProblem(v validator.Validator) response.Problem {
+	problem := response.NewProblem(http.StatusUnprocessableEntity, "The request contains invalid parameters")
+	problem.Type = problemTypeValidation
+
+	if len(v.Errors) > 0 {
+		problem.Detail = strings.Join(v.Errors, "; ")
 	}
+
+	for name, reason := range v.FieldErrors {
+		problem.InvalidParams = append(problem.InvalidParams, response.InvalidParam{Name: name, Reason: reason})
+	}
+	sort.Slice(problem.InvalidParams, func(i, j int) bool {
+		return problem.InvalidParams[i].Name < problem.InvalidParams[j].Name
+	})
+
+	return problem
+}
+
+func (app *application) badGateway(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorMessage(w, r, http.StatusBadGateway, err.Error(), nil)
+}
+
+func (app *application) unsupportedMediaType(w http.ResponseWriter, r *http.Request, supported []string) {
+	message := fmt.Sprintf("Content-Type must be one of %s", strings.Join(supported, ", "))
+	app.errorMessage(w, r, http.StatusUnsupportedMediaType, message, nil)
+}
+
+func (app *application) rateLimitExceeded(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	headers := make(http.Header)
+	headers.Set("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+
+	problem := response.NewProblem(http.StatusTooManyRequests, "Rate limit exceeded, retry later")
+	problem.Type = problemTypeRateLimited
+
+	app.problem(w, r, problem, headers)
+}
+
+func (app *application) idempotencyConflict(w http.ResponseWriter, r *http.Request) {
+	problem := response.NewProblem(http.StatusConflict, "A request with this Idempotency-Key is still being processed, retry later")
+	problem.Type = problemTypeIdempotencyConflict
+
+	app.problem(w, r, problem, nil)
+}
+
+func (app *application) idempotencyKeyReused(w http.ResponseWriter, r *http.Request) {
+	problem := response.NewProblem(http.StatusUnprocessableEntity, "This Idempotency-Key was already used with a different request payload")
+	problem.Type = problemTypeIdempotencyReused
+
+	app.problem(w, r, problem, nil)
+}
+
+func (app *application) invalidAuthenticationToken(w http.ResponseWriter, r *http.Request) {
+	headers := make(http.Header)
+	headers.Set("WWW-Authenticate", "Bearer")
+
+	problem := response.NewProblem(http.StatusUnauthorized, "Invalid or missing authentication token")
+
+	app.problem(w, r, problem, headers)
 }
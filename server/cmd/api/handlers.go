@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -10,6 +11,8 @@ import (
 
 	"github.com/Babatunde50/book-crud/server/business/book"
 	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/Babatunde50/book-crud/server/internal/logging"
 	"github.com/Babatunde50/book-crud/server/internal/request"
 	"github.com/Babatunde50/book-crud/server/internal/response"
 	"github.com/Babatunde50/book-crud/server/internal/validator"
@@ -18,7 +21,13 @@ import (
 	"github.com/julienschmidt/httprouter"
 )
 
-func (app *application) status(w http.ResponseWriter, r *http.Request) {
+// @Summary      Liveness probe
+// @Description  Reports that the process is up and able to serve HTTP requests
+// @Tags         health
+// @Produce      json
+// @Success      200 {object} map[string]string
+// @Router       /healthz [get]
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
 
 	data := map[string]string{
 		"status":   "OK",
@@ -34,6 +43,95 @@ func (app *application) status(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// @Summary      Readiness probe
+// @Description  Reports whether the server should receive traffic: the database answers, migrations are up to date and the server is not shutting down
+// @Tags         health
+// @Produce      json
+// @Success      200 {object} ReadinessResponse
+// @Failure      503 {object} ReadinessResponse
+// @Router       /readyz [get]
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := []HealthCheck{
+		app.runHealthCheck(r, "shutdown", "server is shutting down", func() error {
+			if app.draining.Load() {
+				return errors.New("server is shutting down")
+			}
+			return nil
+		}),
+		app.runHealthCheck(r, "database", "database is unreachable", func() error {
+			ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+			defer cancel()
+
+			return app.db.PingContext(ctx)
+		}),
+		app.runHealthCheck(r, "migrations", "database schema is not up to date", func() error {
+			ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+			defer cancel()
+
+			return app.checkSchemaVersion(ctx)
+		}),
+	}
+
+	resp := ReadinessResponse{Status: healthStatusPass, Checks: checks}
+	status := http.StatusOK
+
+	for _, check := range checks {
+		if check.Status != healthStatusPass {
+			resp.Status = healthStatusFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+
+	err := response.JSON(w, status, resp)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) checkSchemaVersion(ctx context.Context) error {
+	expected, err := database.ExpectedSchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	current, dirty, err := app.db.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("migration %d is dirty", current)
+	case current != expected:
+		return fmt.Errorf("schema is at version %d, expected %d", current, expected)
+	}
+
+	return nil
+}
+
+// runHealthCheck runs a readiness check. A failing check reports reason,
+// since the probe is unauthenticated; the underlying error is only logged.
+func (app *application) runHealthCheck(r *http.Request, name, reason string, check func() error) HealthCheck {
+	start := time.Now()
+	err := check()
+
+	hc := HealthCheck{
+		Name:      name,
+		Status:    healthStatusPass,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+
+	if err != nil {
+		hc.Status = healthStatusFail
+		hc.Error = reason
+		app.logger.WarnContext(r.Context(), "readiness check failed", "check", name, "error", err)
+	}
+
+	return hc
+}
+
 func validateBookRequest(br NewBookRequest) validator.Validator {
 	var v validator.Validator
 
@@ -59,9 +157,12 @@ func validateBookRequest(br NewBookRequest) validator.Validator {
 // @Tags         books
 // @Produce json
 // @Param book body NewBookRequest true "Book data"
+// @Param Idempotency-Key header string false "Key that makes retries of this request safe"
 // @Success 201 {object} book.Book
-// @Failure 400 {object} map[string]string
-// @Failure 422 {object} map[string]interface{}
+// @Failure 400 {object} response.Problem
+// @Failure 409 {object} response.Problem
+// @Failure 422 {object} response.Problem
+// @Failure 429 {object} response.Problem
 // @Router /books [post]
 func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request) {
 	var input NewBookRequest
@@ -104,7 +205,7 @@ func (app *application) createBookHandler(w http.ResponseWriter, r *http.Request
 // @Tags         books
 // @Produce      json
 // @Success      200 {array} BookResponse
-// @Failure      500 {object} map[string]string
+// @Failure      500 {object} response.Problem
 // @Router       /books [get]
 func (app *application) listBooksHandler(w http.ResponseWriter, r *http.Request) {
 	books, err := app.bookCore.QueryAll(r.Context())
@@ -125,9 +226,9 @@ func (app *application) listBooksHandler(w http.ResponseWriter, r *http.Request)
 // @Produce      json
 // @Param        id path string true "Book ID (UUID)"
 // @Success      200 {object} BookResponse
-// @Failure      400 {object} map[string]string
-// @Failure      404 {object} map[string]string
-// @Failure      500 {object} map[string]string
+// @Failure      400 {object} response.Problem
+// @Failure      404 {object} response.Problem
+// @Failure      500 {object} response.Problem
 // @Router       /books/{id} [get]
 func (app *application) showBookHandler(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
@@ -184,10 +285,10 @@ func validateUpdateBookRequest(br UpdateBookRequest) validator.Validator {
 // @Param        id   path string              true "Book ID (UUID)"
 // @Param        book body UpdateBookRequest   true "Partial fields to update"
 // @Success      200 {object} BookResponse
-// @Failure      400 {object} map[string]string
-// @Failure      404 {object} map[string]string
-// @Failure      422 {object} validator.Validator
-// @Failure      500 {object} map[string]string
+// @Failure      400 {object} response.Problem
+// @Failure      404 {object} response.Problem
+// @Failure      422 {object} response.Problem
+// @Failure      500 {object} response.Problem
 // @Router       /books/{id} [put]
 func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
@@ -211,33 +312,13 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Step 1: Fetch the existing book
-	existing, err := app.bookCore.QueryByID(r.Context(), id)
-	if err != nil {
-		switch {
-		case errors.Is(err, book.ErrNotFound):
-			app.notFound(w, r)
-		default:
-			app.serverError(w, r, err)
-		}
-		return
-	}
-
-	// Step 2: Prepare the update
-	updates := book.UpdateBook{}
-
-	if input.Title != nil {
-		updates.Title = input.Title
-	}
-	if input.Author != nil {
-		updates.Author = input.Author
-	}
-	if input.Year != nil {
-		updates.Year = input.Year
+	updates := book.UpdateBook{
+		Title:  input.Title,
+		Author: input.Author,
+		Year:   input.Year,
 	}
 
-	// Step 3: Apply update
-	updated, err := app.bookCore.Update(r.Context(), existing, updates)
+	updated, err := app.bookCore.UpdateByID(r.Context(), id, updates)
 	if err != nil {
 		switch {
 		case errors.Is(err, book.ErrNotFound):
@@ -258,9 +339,9 @@ func (app *application) updateBookHandler(w http.ResponseWriter, r *http.Request
 // @Tags         books
 // @Param        id path string true "Book ID (UUID)"
 // @Success      204
-// @Failure      400 {object} map[string]string
-// @Failure      404 {object} map[string]string
-// @Failure      500 {object} map[string]string
+// @Failure      400 {object} response.Problem
+// @Failure      404 {object} response.Problem
+// @Failure      500 {object} response.Problem
 // @Router       /books/{id} [delete]
 func (app *application) deleteBookHandler(w http.ResponseWriter, r *http.Request) {
 	params := httprouter.ParamsFromContext(r.Context())
@@ -296,28 +377,44 @@ func validateURLRequest(input URLRequest) validator.Validator {
 		v.AddFieldError("url", "must be a valid URL")
 	}
 
-	// Normalize and validate operation
-	op := strings.ToLower(input.Operation)
-	validOps := map[string]bool{
-		"canonical":   true,
-		"redirection": true,
-		"all":         true,
+	// Either an operation or a pipeline of rules
+	if input.Rules != nil {
+		v.CheckField(input.Operation == "", "operation", "must not be given with rules")
+		v.CheckField(len(input.Rules) > 0, "rules", "must not be empty")
+	} else {
+		// Normalize and validate operation
+		op := strings.ToLower(input.Operation)
+		validOps := map[string]bool{
+			"canonical":   true,
+			"redirection": true,
+			"all":         true,
+			"normalize":   true,
+			"resolve":     true,
+		}
+		v.CheckField(validOps[op], "operation", "must be one of 'canonical', 'redirection', 'all', 'normalize', or 'resolve'")
+	}
+
+	if input.TargetHost != "" {
+		v.CheckField(urlprocessor.ValidateHost(input.TargetHost) == nil, "target_host", "must be a host name, optionally with a port")
 	}
-	v.CheckField(validOps[op], "operation", "must be one of 'canonical', 'redirection', or 'all'")
 
 	return v
 }
 
 // @Summary      Process a URL
-// @Description  Canonicalize or redirect-normalize a URL
+// @Description  Canonicalize, redirect-normalize or RFC 3986 normalize a URL, resolve where its HTTP redirects end, or run it through an ordered pipeline of named rules instead of an operation. explain returns a trace of every rule applied. Redirection maps the host with the default profile, the named profile, or target_host when given.
 // @Tags         url
 // @Accept       json
 // @Produce      json
 // @Param        payload body URLRequest true "URL payload"
+// @Param        Idempotency-Key header string false "Key that makes retries of this request safe"
 // @Success      200 {object} URLResponse
-// @Failure      400 {object} map[string]string
-// @Failure      422 {object} validator.Validator
-// @Failure      500 {object} map[string]string
+// @Failure      400 {object} response.Problem
+// @Failure      409 {object} response.Problem
+// @Failure      422 {object} response.Problem
+// @Failure      429 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Failure      502 {object} response.Problem
 // @Router       /url/process [post]
 func (app *application) processURLHandler(w http.ResponseWriter, r *http.Request) {
 	var input URLRequest
@@ -328,25 +425,136 @@ func (app *application) processURLHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	v := validateURLRequest(input)
-	if v.HasErrors() {
+	resp, v, err := app.processURL(r.Context(), app.urlProcessorCore, input)
+	switch {
+	case v.HasErrors():
 		app.failedValidation(w, r, v)
 		return
+	case errors.Is(err, urlprocessor.ErrInvalidOperation):
+		app.badRequest(w, r, err)
+		return
+	case errors.Is(err, urlprocessor.ErrResolve):
+		app.badGateway(w, r, err)
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
 	}
 
-	result, err := app.urlProcessorCore.Process(input.URL, input.Operation)
+	err = response.JSON(w, http.StatusOK, resp)
 	if err != nil {
-		switch {
-		case errors.Is(err, urlprocessor.ErrInvalidOperation):
-			app.badRequest(w, r, err)
-		default:
-			app.serverError(w, r, err)
+		app.serverError(w, r, err)
+	}
+}
+
+// processURL validates input and runs it through the URL processor p. Invalid
+// input, including unknown profiles and rules, is reported in the validator;
+// any other failure as an error.
+func (app *application) processURL(ctx context.Context, p *urlprocessor.URLProcessor, input URLRequest) (URLResponse, validator.Validator, error) {
+	v := validateURLRequest(input)
+	if v.HasErrors() {
+		return URLResponse{}, v, nil
+	}
+
+	opts := urlprocessor.Options{
+		Profile:    input.Profile,
+		TargetHost: input.TargetHost,
+		Unicode:    input.Unicode,
+		Explain:    input.Explain,
+	}
+
+	var (
+		result urlprocessor.Result
+		err    error
+	)
+	if input.Rules != nil {
+		result, err = p.Apply(ctx, input.URL, input.Rules, opts)
+	} else {
+		result, err = p.Process(ctx, input.URL, input.Operation, opts)
+	}
+	switch {
+	case errors.Is(err, urlprocessor.ErrUnknownRule):
+		v.AddFieldError("rules", fmt.Sprintf("must name rules among %s", strings.Join(p.Rules(), ", ")))
+		return URLResponse{}, v, nil
+	case errors.Is(err, urlprocessor.ErrUnknownProfile):
+		v.AddFieldError("profile", "must be a configured profile")
+		return URLResponse{}, v, nil
+	case errors.Is(err, urlprocessor.ErrInvalidURL):
+		v.AddFieldError("url", "must be a valid URL")
+		return URLResponse{}, v, nil
+	case errors.Is(err, urlprocessor.ErrForbiddenAddress):
+		v.AddFieldError("url", "must not lead to a private, loopback or link-local address")
+		return URLResponse{}, v, nil
+	case err != nil:
+		return URLResponse{}, v, err
+	}
+
+	resp := URLResponse{ProcessedURL: result.URL, UnicodeURL: result.UnicodeURL, QueryPolicy: result.QueryPolicy}
+	for _, step := range result.Trace {
+		changed := step.Changed
+		if changed == nil {
+			changed = []string{}
 		}
+		resp.Trace = append(resp.Trace, URLStep{Rule: step.Rule, Before: step.Before, After: step.After, Changed: changed})
+	}
+	for _, hop := range result.Chain {
+		resp.Chain = append(resp.Chain, URLHop{URL: hop.URL, Method: hop.Method, Status: hop.Status})
+	}
+
+	return resp, v, nil
+}
+
+// @Summary      Show the log level
+// @Description  Reports the minimum level of records currently logged
+// @Tags         admin
+// @Produce      json
+// @Security     AdminToken
+// @Success      200 {object} LogLevel
+// @Failure      401 {object} response.Problem
+// @Router       /admin/log-level [get]
+func (app *application) showLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	err := response.JSON(w, http.StatusOK, LogLevel{Level: app.logLevel.Level().String()})
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// @Summary      Change the log level
+// @Description  Changes the minimum level of logged records until the next restart or SIGHUP
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        payload body LogLevel true "New level: debug, info, warn or error"
+// @Success      200 {object} LogLevel
+// @Failure      400 {object} response.Problem
+// @Failure      401 {object} response.Problem
+// @Failure      422 {object} response.Problem
+// @Router       /admin/log-level [put]
+func (app *application) updateLogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	var input LogLevel
+
+	err := request.DecodeJSONStrict(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
 		return
 	}
 
-	resp := URLResponse{ProcessedURL: result}
-	err = response.JSON(w, http.StatusOK, resp)
+	var v validator.Validator
+
+	level, err := logging.ParseLevel(input.Level)
+	v.CheckField(err == nil, "level", "must be one of 'debug', 'info', 'warn' or 'error'")
+
+	if v.HasErrors() {
+		app.failedValidation(w, r, v)
+		return
+	}
+
+	previous := app.logLevel.Level()
+	app.logLevel.Set(level)
+	app.logger.WarnContext(r.Context(), "log level changed", "from", previous, "to", level, "by", "admin endpoint")
+
+	err = response.JSON(w, http.StatusOK, LogLevel{Level: level.String()})
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -1,19 +1,31 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Babatunde50/book-crud/server/business/book"
 	"github.com/Babatunde50/book-crud/server/business/book/bookdb"
+	"github.com/Babatunde50/book-crud/server/business/idempotency"
+	"github.com/Babatunde50/book-crud/server/business/idempotency/idempotencydb"
+	"github.com/Babatunde50/book-crud/server/business/shortlink"
+	"github.com/Babatunde50/book-crud/server/business/shortlink/shortlinkdb"
 	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/business/urlrule"
+	"github.com/Babatunde50/book-crud/server/business/urlrule/urlruledb"
 	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/Babatunde50/book-crud/server/internal/logging"
+	"github.com/Babatunde50/book-crud/server/internal/metrics"
+	"github.com/Babatunde50/book-crud/server/internal/tracing"
 	"github.com/Babatunde50/book-crud/server/internal/version"
-	"github.com/lmittmann/tint"
 )
 
 // @title           Book Crud API
@@ -27,10 +39,46 @@ import (
 // @host      localhost:4748
 // @BasePath  /
 // @schemes   http
+
+// @securityDefinitions.apikey  AdminToken
+// @in                          header
+// @name                        Authorization
+// @description                 "Bearer" followed by the -admin-token value
 func main() {
-	logger := slog.New(tint.NewHandler(os.Stdout, &tint.Options{Level: slog.LevelDebug}))
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:], os.Getenv, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, fs, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "loading configuration:\n%v\n", err)
+		os.Exit(2)
+	}
+
+	if cfg.showVersion {
+		fmt.Printf("version: %s\n", version.Get())
+		return
+	}
+
+	if cfg.printConfig {
+		printConfig(os.Stdout, fs)
+		return
+	}
+
+	// Both were checked by loadConfig.
+	level, _ := logging.ParseLevel(cfg.log.level)
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(level)
+	logger, _ := logging.New(os.Stdout, cfg.log.format, logLevel)
 
-	err := run(logger)
+	err = run(cfg, logger, logLevel)
 	if err != nil {
 		trace := string(debug.Stack())
 		logger.Error(err.Error(), "trace", trace)
@@ -38,58 +86,111 @@ func main() {
 	}
 }
 
-type config struct {
-	baseURL  string
-	httpPort int
-	db       struct {
-		dsn         string
-		automigrate bool
-	}
-}
-
 type application struct {
 	config           config
 	logger           *slog.Logger
+	logLevel         *slog.LevelVar
 	wg               sync.WaitGroup
+	draining         atomic.Bool
 	db               *database.DB
 	bookCore         *book.Core
 	urlProcessorCore *urlprocessor.URLProcessor
+	idempotencyCore  *idempotency.Core
+	shortlinkCore    *shortlink.Core
+	urlRuleCore      *urlrule.Core
+	metrics          *metrics.Metrics
 }
 
-func run(logger *slog.Logger) error {
-	var cfg config
-
-	flag.StringVar(&cfg.baseURL, "base-url", "http://localhost:4748", "base URL for the application")
-	flag.IntVar(&cfg.httpPort, "http-port", 4748, "port to listen on for HTTP requests")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "user:pass@localhost:5432/db", "postgreSQL DSN")
-	flag.BoolVar(&cfg.db.automigrate, "db-automigrate", true, "run migrations on startup")
+func run(cfg config, logger *slog.Logger, logLevel *slog.LevelVar) error {
+	shutdownTracing, err := tracing.Init(context.Background(), tracing.Config{
+		Exporter:       cfg.tracing.exporter,
+		OTLPEndpoint:   cfg.tracing.otlpEndpoint,
+		OTLPInsecure:   cfg.tracing.otlpInsecure,
+		SampleRatio:    cfg.tracing.sampleRatio,
+		ServiceName:    "book-crud-api",
+		ServiceVersion: version.Get(),
+		Stdout:         os.Stdout,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("shutting down tracing", "error", err)
+		}
+	}()
+
+	appMetrics := metrics.New()
+
+	db, err := database.New(database.Config{
+		DSN:                  cfg.db.dsn,
+		Automigrate:          cfg.db.automigrate,
+		Pool:                 cfg.db.pool,
+		ReplicaDSNs:          cfg.db.replicaDSNs,
+		ReplicaPool:          cfg.db.replicaPool,
+		ReplicaCheckInterval: cfg.db.replicaCheckInterval,
+		QueryTimeout:         cfg.db.queryTimeout,
+		SlowQueryThreshold:   cfg.db.slowQueryThreshold,
+		Logger:               logger,
+		Registerer:           appMetrics.Registerer(),
+	})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
 
-	showVersion := flag.Bool("version", false, "display version and exit")
+	for _, pool := range append([]*database.DB{db}, db.Replicas()...) {
+		if err := appMetrics.RegisterDB(pool.Name(), pool.DB.DB); err != nil {
+			return err
+		}
+	}
 
-	flag.Parse()
+	bookStore := bookdb.New(db)
+	bookCore := book.NewCore(logger, db, bookStore)
 
-	if *showVersion {
-		fmt.Printf("version: %s\n", version.Get())
-		return nil
+	urlProcessorConfig, err := cfg.urlProcessorConfig()
+	if err != nil {
+		return err
 	}
 
-	db, err := database.New(cfg.db.dsn, cfg.db.automigrate)
+	urlProcessorCore, err := urlprocessor.New(urlProcessorConfig)
 	if err != nil {
-		return err
+		return fmt.Errorf("url processor: %w", err)
 	}
-	defer db.Close()
 
-	bookStore := bookdb.New(db)
-	bookCore := book.NewCore(bookStore)
+	urlRuleStore := urlruledb.New(db)
+	urlRuleCore := urlrule.NewCore(logger, db, urlRuleStore, urlProcessorCore)
+
+	if _, err := urlRuleCore.Reload(context.Background()); err != nil {
+		return fmt.Errorf("loading url rules: %w", err)
+	}
+
+	idempotencyStore := idempotencydb.New(db)
+	idempotencyCore := idempotency.NewCore(idempotencyStore, cfg.idempotency.ttl)
 
-	urlProcessorCore := urlprocessor.New()
+	shortlinkStore := shortlinkdb.New(db)
+	shortlinkCore, err := shortlink.NewCore(logger, shortlinkStore, urlProcessorCore, shortlink.Config{
+		Rules:      cfg.shortlink.rules,
+		CodeLength: cfg.shortlink.codeLength,
+	})
+	if err != nil {
+		return fmt.Errorf("short links: %w", err)
+	}
 
 	app := &application{
 		config:           cfg,
 		logger:           logger,
+		logLevel:         logLevel,
 		db:               db,
 		bookCore:         bookCore,
 		urlProcessorCore: urlProcessorCore,
+		idempotencyCore:  idempotencyCore,
+		shortlinkCore:    shortlinkCore,
+		urlRuleCore:      urlRuleCore,
+		metrics:          appMetrics,
 	}
 
 	return app.serveHTTP()
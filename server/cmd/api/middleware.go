@@ -1,15 +1,136 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
+	"math/rand/v2"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/Babatunde50/book-crud/server/business/idempotency"
+	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/Babatunde50/book-crud/server/internal/ratelimit"
+	"github.com/Babatunde50/book-crud/server/internal/requestid"
 	"github.com/Babatunde50/book-crud/server/internal/response"
+	"github.com/Babatunde50/book-crud/server/internal/tlsconfig"
 
-	"github.com/tomasen/realip"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/Babatunde50/book-crud/server/cmd/api")
+
+// requestID makes sure every request carries an ID, reusing the one sent by
+// the client when it is well formed, and echoes it in the response.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+
+		ctx := requestid.NewContext(r.Context(), id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// traceRequests starts a server span for every request, continuing the trace
+// described by an incoming traceparent header when there is one. The span is
+// renamed after the matched route by withRoute.
+func (app *application) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.URLScheme(scheme),
+				semconv.ServerAddress(r.Host),
+				semconv.ClientAddress(app.clientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		mw := response.NewMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(mw.StatusCode))
+		if mw.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(mw.StatusCode))
+		}
+	})
+}
+
+// withRoute records the route pattern a request was matched against on the
+// trace span and for recordMetrics, and tracks requests in flight per route.
+func (app *application) withRoute(pattern string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + pattern)
+		span.SetAttributes(semconv.HTTPRoute(pattern))
+
+		if info := contextGetRouteInfo(r); info != nil {
+			info.pattern = pattern
+		}
+
+		if app.metrics != nil {
+			done := app.metrics.TrackInFlight(r.Method, pattern)
+			defer done()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordMetrics counts requests and their latency, labelled by the route
+// pattern rather than the raw URL to keep the number of series bounded.
+func (app *application) recordMetrics(next http.Handler) http.Handler {
+	if app.metrics == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		info := &routeInfo{}
+		r = contextSetRouteInfo(r, info)
+
+		mw := response.NewMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
+
+		route := info.pattern
+		if route == "" {
+			route = "unmatched"
+		}
+
+		app.metrics.ObserveRequest(r.Method, route, mw.StatusCode, time.Since(start))
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
@@ -23,22 +144,312 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 	})
 }
 
+// identifyClient records the identity in a verified TLS client certificate, so
+// that rate limits and idempotency keys apply per client rather than per IP.
+func (app *application) identifyClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if identity := tlsconfig.Identity(r.TLS); identity != "" {
+			r = contextSetIdentity(r, identity)
+			trace.SpanFromContext(r.Context()).SetAttributes(semconv.EnduserID(identity))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logAccess writes one "access" record per request. Only a
+// log-access-sample-ratio fraction of successful requests is logged; client
+// and server errors always are.
 func (app *application) logAccess(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		mw := response.NewMetricsResponseWriter(w)
 		next.ServeHTTP(mw, r)
 
+		if mw.StatusCode < http.StatusBadRequest && rand.Float64() >= app.config.log.accessSampleRatio {
+			return
+		}
+
 		var (
-			ip     = realip.FromRequest(r)
+			ip     = app.clientIP(r)
 			method = r.Method
 			url    = r.URL.String()
 			proto  = r.Proto
 		)
 
 		userAttrs := slog.Group("user", "ip", ip)
+		if identity := contextGetIdentity(r); identity != "" {
+			userAttrs = slog.Group("user", "ip", ip, "identity", identity)
+		}
 		requestAttrs := slog.Group("request", "method", method, "url", url, "proto", proto)
 		responseAttrs := slog.Group("response", "status", mw.StatusCode, "size", mw.BytesCount)
 
-		app.logger.Info("access", userAttrs, requestAttrs, responseAttrs)
+		app.logger.InfoContext(r.Context(), "access", userAttrs, requestAttrs, responseAttrs)
+	})
+}
+
+// requireAdminToken only lets requests through that carry the configured
+// admin token as a bearer token.
+func (app *application) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || app.config.admin.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(app.config.admin.token)) != 1 {
+			app.invalidAuthenticationToken(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newRateLimiter returns nil when rate limiting is switched off or the policy
+// does not limit anything, in which case rateLimit is a no-op.
+func (app *application) newRateLimiter(policy ratelimit.Policy) *ratelimit.Limiter {
+	if !app.config.limiter.enabled || !policy.Enabled() {
+		return nil
+	}
+	return ratelimit.New(policy, app.config.limiter.idleTimeout)
+}
+
+func (app *application) rateLimit(limiter *ratelimit.Limiter, next http.Handler) http.Handler {
+	if limiter == nil {
+		return next
+	}
+
+	policy := limiter.Policy()
+	policyHeader := fmt.Sprintf("%d;w=%d", policy.Burst, ceilSeconds(policy.Window()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := limiter.Allow(app.clientKey(r))
+
+		w.Header().Set("RateLimit-Policy", policyHeader)
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+
+		if !res.Allowed {
+			app.rateLimitExceeded(w, r, res.RetryAfter)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// corsExposedHeaders lists the response headers browser clients are allowed
+// to read on cross-origin responses.
+var corsExposedHeaders = strings.Join([]string{
+	requestid.Header,
+	"ETag",
+	"Link",
+	"Location",
+	"X-Total-Count",
+	"Retry-After",
+	"RateLimit-Limit",
+	"RateLimit-Remaining",
+	"RateLimit-Reset",
+	"RateLimit-Policy",
+}, ", ")
+
+// enableCORS lets trusted origins read responses. A "*" trusted origin is
+// answered with a literal "*", which browsers never combine with
+// credentials, so credentials are only ever allowed for listed origins.
+func (app *application) enableCORS(next http.Handler) http.Handler {
+	wildcard := slices.Contains(app.config.cors.trustedOrigins, "*")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		switch {
+		case origin == "":
+		case wildcard:
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		case app.isTrustedOrigin(origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+
+			if app.config.cors.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+
+		next.ServeHTTP(w, r)
 	})
 }
+
+// preflight answers OPTIONS requests for any registered route. httprouter has
+// already set the Allow header to the methods registered for the path by the
+// time it calls this handler.
+func (app *application) preflight(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Access-Control-Request-Method") != "" && app.isTrustedOrigin(r.Header.Get("Origin")) {
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+
+		w.Header().Set("Access-Control-Allow-Methods", w.Header().Get("Allow"))
+
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			w.Header().Set("Access-Control-Allow-Headers", requested)
+		}
+
+		if app.config.cors.maxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(app.config.cors.maxAge.Seconds())))
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) isTrustedOrigin(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	return slices.Contains(app.config.cors.trustedOrigins, "*") || slices.Contains(app.config.cors.trustedOrigins, origin)
+}
+
+// defaultMaxBodyBytes applies when no http-max-body-bytes limit is configured.
+const defaultMaxBodyBytes = 1_048_576
+
+// limitBody caps the size of request bodies at limit bytes. Reads past the
+// limit fail with an *http.MaxBytesError.
+func (app *application) limitBody(limit int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readYourWrites routes a request's reads to the primary database once it has
+// written, so that it never reads data older than its own writes from a
+// lagging replica.
+func (app *application) readYourWrites(next http.Handler) http.Handler {
+	if !app.config.db.readYourWrites {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := database.WithReadYourWrites(r.Context())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+const (
+	maxIdempotencyKeyLength = 255
+	idempotencyStoreTimeout = 5 * time.Second
+)
+
+// idempotent makes retries of a request carrying an Idempotency-Key header
+// safe. The first response for a key is stored and replayed for later
+// requests from the same client with the same key and payload.
+func (app *application) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || app.idempotencyCore == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(key) > maxIdempotencyKeyLength {
+			app.badRequest(w, r, fmt.Errorf("idempotency key must not be longer than %d characters", maxIdempotencyKeyLength))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			}
+			app.badRequest(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		principal := app.clientKey(r)
+		fingerprint := requestFingerprint(r, body)
+
+		replay, err := app.idempotencyCore.Begin(r.Context(), key, principal, fingerprint)
+		if err != nil {
+			switch {
+			case errors.Is(err, idempotency.ErrInProgress):
+				app.idempotencyConflict(w, r)
+			case errors.Is(err, idempotency.ErrFingerprintMismatch):
+				app.idempotencyKeyReused(w, r)
+			default:
+				app.serverError(w, r, err)
+			}
+			return
+		}
+
+		if replay != nil {
+			for name, values := range replay.Header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(replay.StatusCode)
+			w.Write(replay.Body)
+			return
+		}
+
+		// The stored response must survive the client going away, which is
+		// precisely the situation a retry recovers from.
+		storeCtx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), idempotencyStoreTimeout)
+		defer cancel()
+
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if err := app.idempotencyCore.Release(storeCtx, key, principal); err != nil {
+				app.reportServerError(r, err)
+			}
+		}()
+
+		before := w.Header().Clone()
+		cw := response.NewCaptureResponseWriter(w)
+
+		next.ServeHTTP(cw, r)
+
+		if cw.StatusCode >= http.StatusInternalServerError {
+			return
+		}
+
+		resp := idempotency.Response{
+			StatusCode: cw.StatusCode,
+			Header:     headersAddedSince(before, w.Header()),
+			Body:       cw.Body.Bytes(),
+		}
+
+		if err := app.idempotencyCore.Complete(storeCtx, key, principal, resp); err != nil {
+			app.reportServerError(r, err)
+			return
+		}
+		completed = true
+	})
+}
+
+// requestFingerprint identifies a request by its method, target and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", r.Method, r.URL.RequestURI())
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// headersAddedSince returns the headers that were set or changed after the
+// before snapshot was taken, which leaves out per-request headers written by
+// outer middleware.
+func headersAddedSince(before, after http.Header) map[string][]string {
+	added := make(map[string][]string)
+	for name, values := range after {
+		if !slices.Equal(before[name], values) {
+			added[name] = slices.Clone(values)
+		}
+	}
+	return added
+}
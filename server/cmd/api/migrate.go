@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/Babatunde50/book-crud/server/internal/database"
+	"github.com/Babatunde50/book-crud/server/internal/logging"
+)
+
+const migrateUsage = `usage: api migrate <command> [flags]
+
+commands:
+  up           apply every pending migration
+  down N       roll back the N most recent migrations
+  goto V       migrate up or down to version V
+  force V      mark version V as applied and clean, without running anything
+  version      print the current version
+  create NAME  write empty up and down files for a new migration
+
+Flags and environment variables are the same as when serving, for example
+-db-dsn or BOOKCRUD_DB_DSN. create takes -dir instead (default assets/migrations).
+`
+
+// migrateArgs is the number of arguments each migrate command takes.
+var migrateArgs = map[string]int{
+	"up":      0,
+	"down":    1,
+	"goto":    1,
+	"force":   1,
+	"version": 0,
+	"create":  1,
+}
+
+// runMigrate implements the migrate subcommand. args are the arguments after
+// "migrate".
+func runMigrate(args []string, getenv func(string) string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	command := args[0]
+	n, ok := migrateArgs[command]
+	if !ok || len(args) < n+1 {
+		return errors.New(migrateUsage)
+	}
+	operands, flags := args[1:n+1], args[n+1:]
+
+	if command == "create" {
+		return createMigration(operands[0], flags, stdout)
+	}
+
+	var number int
+	if n == 1 {
+		var err error
+		if number, err = strconv.Atoi(operands[0]); err != nil || number < 0 {
+			return fmt.Errorf("%s: %q is not a migration count or version", command, operands[0])
+		}
+	}
+
+	cfg, _, err := loadConfig(flags, getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return fmt.Errorf("loading configuration:\n%w", err)
+	}
+
+	level, _ := logging.ParseLevel(cfg.log.level)
+	logger, _ := logging.New(os.Stderr, cfg.log.format, level)
+
+	migrator, err := database.NewMigrator(cfg.db.dsn)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx, number)
+	case "goto":
+		err = migrator.Goto(ctx, uint(number))
+	case "force":
+		err = migrator.Force(ctx, number)
+	}
+
+	switch {
+	case errors.Is(err, database.ErrNoChange):
+		logger.Info("no migrations to apply")
+	case err != nil:
+		return fmt.Errorf("migrate %s: %w", command, err)
+	}
+
+	version, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return fmt.Errorf("reading migration version: %w", err)
+	}
+
+	if command == "version" {
+		fmt.Fprintln(stdout, formatVersion(version, dirty))
+		return nil
+	}
+
+	logger.Info("migrated database", slog.String("command", command), slog.String("version", formatVersion(version, dirty)))
+	return nil
+}
+
+func createMigration(name string, args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("migrate create", flag.ContinueOnError)
+	dir := fs.String("dir", "assets/migrations", "directory holding the migration files")
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+
+	up, down, err := database.CreateMigration(*dir, name)
+	if err != nil {
+		return fmt.Errorf("migrate create: %w", err)
+	}
+
+	fmt.Fprintln(stdout, up)
+	fmt.Fprintln(stdout, down)
+	return nil
+}
+
+func formatVersion(version uint, dirty bool) string {
+	if dirty {
+		return fmt.Sprintf("%d (dirty)", version)
+	}
+	return strconv.FormatUint(uint64(version), 10)
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/Babatunde50/book-crud/server/business/book"
+	"github.com/Babatunde50/book-crud/server/internal/response"
 	"github.com/google/uuid"
 )
 
@@ -55,11 +56,188 @@ func toBooksResponse(books []book.Book) []BookResponse {
 	return bookResponses
 }
 
+// URLRequest asks for a URL to be processed, either by an operation or by an
+// ordered pipeline of named rules. Profile selects a named redirection
+// profile, and TargetHost redirects to the given host whatever the profile
+// maps the URL's host to. Unicode asks for the display form of the result as
+// well, and Explain for a trace of every rule applied.
 type URLRequest struct {
-	URL       string `json:"url"`
-	Operation string `json:"operation"`
+	URL        string   `json:"url"`
+	Operation  string   `json:"operation,omitempty"`
+	Rules      []string `json:"rules,omitempty" example:"strip_tracking,lowercase_path,force_host"`
+	Profile    string   `json:"profile,omitempty"`
+	TargetHost string   `json:"target_host,omitempty"`
+	Unicode    bool     `json:"unicode,omitempty"`
+	Explain    bool     `json:"explain,omitempty"`
 }
 
+// URLResponse carries the processed URL, with an internationalized host in
+// punycode, and when asked for its Unicode form and trace. QueryPolicy names
+// the query policy followed when the query was filtered, and Chain lists the
+// requests made to resolve it.
 type URLResponse struct {
-	ProcessedURL string `json:"processed_url"`
+	ProcessedURL string    `json:"processed_url"`
+	UnicodeURL   string    `json:"unicode_url,omitempty"`
+	QueryPolicy  string    `json:"query_policy,omitempty"`
+	Trace        []URLStep `json:"trace,omitempty"`
+	Chain        []URLHop  `json:"chain,omitempty"`
+}
+
+// URLStep is a rule applied to the URL, with the URL before and after it and
+// the components it changed.
+type URLStep struct {
+	Rule    string   `json:"rule" example:"force_host"`
+	Before  string   `json:"before" example:"https://BYFOOD.com/food"`
+	After   string   `json:"after" example:"https://www.byfood.com/food"`
+	Changed []string `json:"changed" example:"host"`
+}
+
+// URLHop is a request the resolve operation made, and the status it got.
+type URLHop struct {
+	URL    string `json:"url" example:"http://byfood.com/"`
+	Method string `json:"method" example:"HEAD"`
+	Status int    `json:"status" example:"301"`
+}
+
+// URLBatchResult is a line of a batch response: the result for the entry at
+// Line of the input, 1-based, or the problem that failed it. A result without
+// a line reports a problem that ended the batch.
+type URLBatchResult struct {
+	Line int    `json:"line,omitempty"`
+	URL  string `json:"url,omitempty"`
+	*URLResponse
+	Error *response.Problem `json:"error,omitempty"`
+}
+
+// ShortLinkRequest asks for a short link to URL. Alias sets the code instead
+// of a generated one, and ExpiresAt ends the redirect.
+type ShortLinkRequest struct {
+	URL       string     `json:"url" example:"https://www.byfood.com/food-experiences?utm_source=x"`
+	Alias     string     `json:"alias,omitempty" example:"spring-sale"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ShortLinkResponse describes a short link: ShortURL redirects to the
+// canonical form of the URL it was made for. Links that do not expire
+// redirect permanently.
+type ShortLinkResponse struct {
+	Code        string     `json:"code" example:"aZ3k9Qx"`
+	ShortURL    string     `json:"short_url" example:"http://localhost:4748/s/aZ3k9Qx"`
+	URL         string     `json:"url" example:"https://www.byfood.com/food-experiences"`
+	Alias       bool       `json:"alias"`
+	Permanent   bool       `json:"permanent"`
+	Hits        int64      `json:"hits"`
+	DateCreated time.Time  `json:"date_created"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+// URLRuleRequest describes a stored URL rule. Kind picks what it does: a host
+// rule redirects Host to TargetHost, a query rule filters the query of URLs
+// on Host with Query, and a path rule rewrites paths matching Pattern, on
+// Host or every host, with Replacement, which may refer to capture groups as
+// $1 or ${name}. Path rules are tried by ascending Priority.
+type URLRuleRequest struct {
+	Kind        string          `json:"kind" example:"path"`
+	Host        string          `json:"host,omitempty" example:"www.byfood.com"`
+	TargetHost  string          `json:"target_host,omitempty"`
+	Query       *URLQueryPolicy `json:"query,omitempty"`
+	Pattern     string          `json:"pattern,omitempty" example:"^/items/(\\d+)$"`
+	Replacement string          `json:"replacement,omitempty" example:"/products/$1"`
+	Priority    int             `json:"priority,omitempty"`
+	Description string          `json:"description,omitempty"`
+}
+
+// URLRuleUpdateRequest changes the fields of a URL rule it sets. The kind of
+// a rule never changes.
+type URLRuleUpdateRequest struct {
+	Host        *string         `json:"host,omitempty"`
+	TargetHost  *string         `json:"target_host,omitempty"`
+	Query       *URLQueryPolicy `json:"query,omitempty"`
+	Pattern     *string         `json:"pattern,omitempty"`
+	Replacement *string         `json:"replacement,omitempty"`
+	Priority    *int            `json:"priority,omitempty"`
+	Description *string         `json:"description,omitempty"`
+}
+
+// URLQueryPolicy keeps only the Allow parameters when set, removes the Deny
+// ones, or with DropAll the whole query. Names ending in * are prefixes.
+type URLQueryPolicy struct {
+	Allow   []string `json:"allow,omitempty" example:"id,page"`
+	Deny    []string `json:"deny,omitempty" example:"utm_*"`
+	DropAll bool     `json:"drop_all,omitempty"`
+}
+
+// URLRuleResponse describes a stored URL rule.
+type URLRuleResponse struct {
+	ID          uuid.UUID       `json:"id"`
+	Kind        string          `json:"kind" example:"path"`
+	Host        string          `json:"host,omitempty" example:"www.byfood.com"`
+	TargetHost  string          `json:"target_host,omitempty"`
+	Query       *URLQueryPolicy `json:"query,omitempty"`
+	Pattern     string          `json:"pattern,omitempty" example:"^/items/(\\d+)$"`
+	Replacement string          `json:"replacement,omitempty" example:"/products/$1"`
+	Priority    int             `json:"priority"`
+	Description string          `json:"description,omitempty"`
+	DateCreated time.Time       `json:"date_created"`
+	DateUpdated time.Time       `json:"date_updated"`
+	Version     int             `json:"version"`
+}
+
+// URLRuleDryRunRequest asks how URLs would be processed with the stored rules
+// changed: Rule added, or in place of the rule with ID when that is set; ID
+// alone removes the rule. The URLs go through Operation, "all" when neither
+// it nor Rules is given, or the pipeline of Rules.
+type URLRuleDryRunRequest struct {
+	ID        *uuid.UUID      `json:"id,omitempty"`
+	Rule      *URLRuleRequest `json:"rule,omitempty"`
+	URLs      []string        `json:"urls" example:"https://www.byfood.com/items/42"`
+	Operation string          `json:"operation,omitempty"`
+	Rules     []string        `json:"rules,omitempty"`
+	Explain   bool            `json:"explain,omitempty"`
+}
+
+// URLRuleDryRunResponse lists the outcome for every URL of a dry run, in the
+// order they were given.
+type URLRuleDryRunResponse struct {
+	Results []URLRuleDryRunResult `json:"results"`
+}
+
+// URLRuleDryRunResult compares the processing of a URL with the current
+// rules and with the proposed ones. Changed reports whether they differ.
+type URLRuleDryRunResult struct {
+	URL      string     `json:"url"`
+	Current  URLOutcome `json:"current"`
+	Proposed URLOutcome `json:"proposed"`
+	Changed  bool       `json:"changed"`
+}
+
+// URLOutcome is the result of processing a URL, or the problem that failed
+// it.
+type URLOutcome struct {
+	*URLResponse
+	Error *response.Problem `json:"error,omitempty"`
+}
+
+const (
+	healthStatusPass = "pass"
+	healthStatusFail = "fail"
+)
+
+// HealthCheck reports the outcome of a single readiness check.
+type HealthCheck struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ReadinessResponse is returned by the readiness probe.
+type ReadinessResponse struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// LogLevel reports or changes the minimum level of logged records.
+type LogLevel struct {
+	Level string `json:"level" example:"INFO"`
 }
@@ -20,20 +20,71 @@ func (app *application) routes() http.Handler {
 
 	mux.NotFound = http.HandlerFunc(app.notFound)
 	mux.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowed)
+	mux.GlobalOPTIONS = http.HandlerFunc(app.preflight)
 
-	mux.HandlerFunc("GET", "/status", app.status)
+	maxBodyBytes := app.config.http.maxBodyBytes
+	if maxBodyBytes <= 0 {
+		maxBodyBytes = defaultMaxBodyBytes
+	}
 
-	mux.HandlerFunc("GET", "/books", app.listBooksHandler)
-	mux.HandlerFunc("POST", "/books", app.createBookHandler)
-	mux.HandlerFunc("GET", "/books/:id", app.showBookHandler)
-	mux.HandlerFunc("PUT", "/books/:id", app.updateBookHandler)
-	mux.HandlerFunc("DELETE", "/books/:id", app.deleteBookHandler)
+	batchMaxBytes := app.config.urlProcessor.batchMaxBytes
+	if batchMaxBytes <= 0 {
+		batchMaxBytes = defaultURLBatchMaxBytes
+	}
 
-	mux.HandlerFunc("POST", "/url/process", app.processURLHandler)
+	// handle registers a route whose request bodies are capped at
+	// http-max-body-bytes; handleRoute leaves the cap to the route.
+	handleRoute := func(method, pattern string, handler http.Handler) {
+		mux.Handler(method, pattern, app.withRoute(pattern, handler))
+	}
+	handle := func(method, pattern string, handler http.Handler) {
+		handleRoute(method, pattern, app.limitBody(maxBodyBytes, handler))
+	}
 
-	mux.Handler(http.MethodGet, "/swagger/*any", httpSwagger.WrapHandler)
+	handle("GET", "/healthz", http.HandlerFunc(app.healthz))
+	handle("GET", "/readyz", http.HandlerFunc(app.readyz))
+	handle("GET", "/status", http.HandlerFunc(app.healthz))
 
-	mux.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
+	booksLimiter := app.newRateLimiter(app.config.limiter.books)
+	handle("GET", "/books", app.rateLimit(booksLimiter, http.HandlerFunc(app.listBooksHandler)))
+	handle("POST", "/books", app.rateLimit(booksLimiter, app.idempotent(http.HandlerFunc(app.createBookHandler))))
+	handle("GET", "/books/:id", app.rateLimit(booksLimiter, http.HandlerFunc(app.showBookHandler)))
+	handle("PUT", "/books/:id", app.rateLimit(booksLimiter, http.HandlerFunc(app.updateBookHandler)))
+	handle("DELETE", "/books/:id", app.rateLimit(booksLimiter, http.HandlerFunc(app.deleteBookHandler)))
 
-	return app.logAccess(app.recoverPanic(mux))
+	urlLimiter := app.newRateLimiter(app.config.limiter.url)
+	handle("POST", "/url/process", app.rateLimit(urlLimiter, app.idempotent(http.HandlerFunc(app.processURLHandler))))
+	// Batch responses are streamed while they are produced, so they cannot be
+	// stored for replay; clients retry the lines that failed instead.
+	handleRoute("POST", urlBatchPath, app.limitBody(batchMaxBytes, app.rateLimit(urlLimiter, http.HandlerFunc(app.processURLBatchHandler))))
+	handle("POST", "/shortlinks", app.rateLimit(urlLimiter, app.idempotent(http.HandlerFunc(app.createShortLinkHandler))))
+	handle("GET", "/shortlinks/:code", app.rateLimit(urlLimiter, http.HandlerFunc(app.showShortLinkHandler)))
+
+	// Following a link counts a hit, which is a database write.
+	followLimiter := app.newRateLimiter(app.config.limiter.shortlinks)
+	handle("GET", shortLinkPrefix+":code", app.rateLimit(followLimiter, http.HandlerFunc(app.followShortLinkHandler)))
+
+	handle(http.MethodGet, "/swagger/*any", httpSwagger.WrapHandler)
+
+	handle(http.MethodGet, "/debug/vars", expvar.Handler())
+
+	if app.config.admin.token != "" && app.logLevel != nil {
+		handle(http.MethodGet, "/admin/log-level", app.requireAdminToken(http.HandlerFunc(app.showLogLevelHandler)))
+		handle(http.MethodPut, "/admin/log-level", app.requireAdminToken(http.HandlerFunc(app.updateLogLevelHandler)))
+	}
+
+	if app.config.admin.token != "" && app.urlRuleCore != nil {
+		handle(http.MethodGet, "/url/rules", app.requireAdminToken(http.HandlerFunc(app.listURLRulesHandler)))
+		handle(http.MethodPost, "/url/rules", app.requireAdminToken(app.idempotent(http.HandlerFunc(app.createURLRuleHandler))))
+		handle(http.MethodPost, "/url/rules/dry-run", app.requireAdminToken(http.HandlerFunc(app.dryRunURLRuleHandler)))
+		handle(http.MethodGet, "/url/rules/:id", app.requireAdminToken(http.HandlerFunc(app.showURLRuleHandler)))
+		handle(http.MethodPut, "/url/rules/:id", app.requireAdminToken(http.HandlerFunc(app.updateURLRuleHandler)))
+		handle(http.MethodDelete, "/url/rules/:id", app.requireAdminToken(http.HandlerFunc(app.deleteURLRuleHandler)))
+	}
+
+	if app.metrics != nil {
+		handle(http.MethodGet, "/metrics", app.metrics.Handler())
+	}
+
+	return app.requestID(app.traceRequests(app.identifyClient(app.recordMetrics(app.logAccess(app.recoverPanic(app.enableCORS(app.readYourWrites(mux))))))))
 }
@@ -10,41 +10,98 @@ import (
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/Babatunde50/book-crud/server/internal/logging"
+	"github.com/Babatunde50/book-crud/server/internal/tlsconfig"
 )
 
 const (
-	defaultIdleTimeout    = time.Minute
-	defaultReadTimeout    = 5 * time.Second
-	defaultWriteTimeout   = 10 * time.Second
-	defaultShutdownPeriod = 30 * time.Second
+	defaultPurgeInterval  = 10 * time.Minute
+	readinessCheckTimeout = 2 * time.Second
 )
 
 func (app *application) serveHTTP() error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.httpPort),
+		Addr:         fmt.Sprintf(":%d", app.config.http.port),
 		Handler:      app.routes(),
 		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelWarn),
-		IdleTimeout:  defaultIdleTimeout,
-		ReadTimeout:  defaultReadTimeout,
-		WriteTimeout: defaultWriteTimeout,
+		IdleTimeout:  app.config.http.idleTimeout,
+		ReadTimeout:  app.config.http.readTimeout,
+		WriteTimeout: app.config.http.writeTimeout,
 	}
 
 	shutdownErrorChan := make(chan error)
 
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+
+	useTLS := app.config.tls.certFile != ""
+	if useTLS {
+		certs, err := tlsconfig.New(tlsconfig.Config{
+			CertFile:     app.config.tls.certFile,
+			KeyFile:      app.config.tls.keyFile,
+			ClientCAFile: app.config.tls.clientCAFile,
+			ClientAuth:   app.config.tls.clientAuth,
+			MinVersion:   app.config.tls.minVersion,
+			CipherSuites: app.config.tls.cipherSuites,
+		})
+		if err != nil {
+			return err
+		}
+
+		srv.TLSConfig = certs.TLSConfig()
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetHTTP2(app.config.tls.http2)
+
+		app.wg.Add(1)
+		go func() {
+			defer app.wg.Done()
+			certs.Watch(backgroundCtx, app.config.tls.reloadInterval, app.logger)
+		}()
+	}
+
 	go func() {
 		quitChan := make(chan os.Signal, 1)
 		signal.Notify(quitChan, syscall.SIGINT, syscall.SIGTERM)
-		<-quitChan
+		sig := <-quitChan
+
+		// Fail readiness first so load balancers stop routing new requests
+		// here while in-flight ones are still being served.
+		app.draining.Store(true)
+		app.logger.Info("draining server", "signal", sig.String(), "delay", app.config.shutdown.drainDelay)
+		time.Sleep(app.config.shutdown.drainDelay)
+
+		stopBackground()
 
-		ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownPeriod)
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdown.timeout)
 		defer cancel()
 
 		shutdownErrorChan <- srv.Shutdown(ctx)
 	}()
 
-	app.logger.Info("starting server", slog.Group("server", "addr", srv.Addr))
+	app.wg.Add(3)
+	go func() {
+		defer app.wg.Done()
+		app.purgeExpiredIdempotencyKeys(backgroundCtx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.reloadURLRules(backgroundCtx)
+	}()
+	go func() {
+		defer app.wg.Done()
+		app.reloadLogLevelOnHangup(backgroundCtx)
+	}()
+
+	app.logger.Info("starting server", slog.Group("server", "addr", srv.Addr, "tls", useTLS))
 
-	err := srv.ListenAndServe()
+	var err error
+	if useTLS {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -59,3 +116,72 @@ func (app *application) serveHTTP() error {
 	app.wg.Wait()
 	return nil
 }
+
+// purgeExpiredIdempotencyKeys periodically deletes stored idempotent responses
+// whose TTL has elapsed, until ctx is cancelled.
+func (app *application) purgeExpiredIdempotencyKeys(ctx context.Context) {
+	ticker := time.NewTicker(defaultPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := app.idempotencyCore.PurgeExpired(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				app.logger.Error("purging idempotency keys", "error", err)
+				continue
+			}
+			if n > 0 {
+				app.logger.Debug("purged idempotency keys", "count", n)
+			}
+		}
+	}
+}
+
+// reloadURLRules periodically picks up URL rules changed through other
+// instances, until ctx is cancelled. Changes made through this one apply at
+// once.
+func (app *application) reloadURLRules(ctx context.Context) {
+	ticker := time.NewTicker(app.config.urlProcessor.rulesReload)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := app.urlRuleCore.Reload(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				app.logger.Error("reloading url rules", "error", err)
+			}
+		}
+	}
+}
+
+// reloadLogLevelOnHangup loads the configuration again on SIGHUP, so that an
+// edited config file takes effect, and applies its log level.
+func (app *application) reloadLogLevelOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			cfg, _, err := loadConfig(os.Args[1:], os.Getenv)
+			if err != nil {
+				app.logger.Error("reloading configuration", "error", err)
+				continue
+			}
+
+			level, _ := logging.ParseLevel(cfg.log.level)
+			previous := app.logLevel.Level()
+			app.logLevel.Set(level)
+			app.logger.Warn("log level changed", "from", previous, "to", level, "by", "SIGHUP")
+		}
+	}
+}
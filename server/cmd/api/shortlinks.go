@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/shortlink"
+	"github.com/Babatunde50/book-crud/server/internal/request"
+	"github.com/Babatunde50/book-crud/server/internal/response"
+	"github.com/Babatunde50/book-crud/server/internal/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+// shortLinkPrefix is where short links redirect from.
+const shortLinkPrefix = "/s/"
+
+func validateShortLinkRequest(input ShortLinkRequest) validator.Validator {
+	var v validator.Validator
+
+	v.CheckField(input.URL != "", "url", "must be provided")
+	v.CheckField(len(input.URL) <= shortlink.MaxURLLength, "url", "must not be more than 2048 bytes long")
+
+	if input.Alias != "" {
+		v.CheckField(shortlink.ValidateAlias(input.Alias) == nil, "alias", "must be 3 to 64 letters, digits, '-' or '_'")
+	}
+
+	if input.ExpiresAt != nil {
+		v.CheckField(input.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+
+	return v
+}
+
+// @Summary      Create a short link
+// @Description  Shortens the canonical form of a URL: normalized, with tracking parameters and the fragment removed. A link without an alias or expiry is shared by every request for the same canonical URL, which gets the existing link back with status 200.
+// @Tags         shortlinks
+// @Accept       json
+// @Produce      json
+// @Param        payload body ShortLinkRequest true "URL to shorten"
+// @Param        Idempotency-Key header string false "Key that makes retries of this request safe"
+// @Success      200 {object} ShortLinkResponse
+// @Success      201 {object} ShortLinkResponse
+// @Failure      400 {object} response.Problem
+// @Failure      409 {object} response.Problem
+// @Failure      422 {object} response.Problem
+// @Failure      429 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /shortlinks [post]
+func (app *application) createShortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	var input ShortLinkRequest
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validateShortLinkRequest(input)
+	if v.HasErrors() {
+		app.failedValidation(w, r, v)
+		return
+	}
+
+	nl := shortlink.NewLink{
+		URL:       input.URL,
+		Alias:     input.Alias,
+		ExpiresAt: input.ExpiresAt,
+	}
+
+	link, created, err := app.shortlinkCore.Create(r.Context(), nl)
+	if err != nil {
+		switch {
+		case errors.Is(err, shortlink.ErrInvalidURL):
+			v.AddFieldError("url", "must be a valid http or https URL")
+			app.failedValidation(w, r, v)
+		case errors.Is(err, shortlink.ErrAliasTaken):
+			app.errorMessage(w, r, http.StatusConflict, "The alias is already taken", nil)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+
+	err = response.JSON(w, status, app.toShortLinkResponse(link))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// @Summary      Show a short link
+// @Description  Reports where a short link redirects and how often it was followed
+// @Tags         shortlinks
+// @Produce      json
+// @Param        code path string true "Code of the short link"
+// @Success      200 {object} ShortLinkResponse
+// @Failure      404 {object} response.Problem
+// @Failure      429 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /shortlinks/{code} [get]
+func (app *application) showShortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	link, err := app.shortlinkCore.QueryByCode(r.Context(), code)
+	if err != nil {
+		switch {
+		case errors.Is(err, shortlink.ErrNotFound):
+			app.notFound(w, r)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = response.JSON(w, http.StatusOK, app.toShortLinkResponse(link))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// @Summary      Follow a short link
+// @Description  Redirects to the URL of a short link and counts the hit: with 301 for links that never expire and 302 for links that do. Responses are not cached, so every hit is counted.
+// @Tags         shortlinks
+// @Param        code path string true "Code of the short link"
+// @Success      301
+// @Success      302
+// @Failure      404 {object} response.Problem
+// @Failure      410 {object} response.Problem
+// @Failure      429 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /s/{code} [get]
+func (app *application) followShortLinkHandler(w http.ResponseWriter, r *http.Request) {
+	code := httprouter.ParamsFromContext(r.Context()).ByName("code")
+
+	link, err := app.shortlinkCore.Visit(r.Context(), code)
+	if err != nil {
+		switch {
+		case errors.Is(err, shortlink.ErrNotFound):
+			app.notFound(w, r)
+		case errors.Is(err, shortlink.ErrExpired):
+			app.errorMessage(w, r, http.StatusGone, "The short link has expired", nil)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	status := http.StatusFound
+	if link.Permanent() {
+		status = http.StatusMovedPermanently
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, link.URL, status)
+}
+
+func (app *application) toShortLinkResponse(link shortlink.Link) ShortLinkResponse {
+	return ShortLinkResponse{
+		Code:        link.Code,
+		ShortURL:    strings.TrimRight(app.config.baseURL, "/") + shortLinkPrefix + link.Code,
+		URL:         link.URL,
+		Alias:       link.Alias,
+		Permanent:   link.Permanent(),
+		Hits:        link.Hits,
+		DateCreated: link.DateCreated,
+		ExpiresAt:   link.ExpiresAt,
+	}
+}
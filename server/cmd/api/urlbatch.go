@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/internal/request"
+	"github.com/Babatunde50/book-crud/server/internal/response"
+	"github.com/Babatunde50/book-crud/server/internal/validator"
+)
+
+const (
+	urlBatchPath = "/url/process/batch"
+
+	// defaultURLBatchWorkers and defaultURLBatchMaxBytes apply when
+	// url-batch-workers and url-batch-max-bytes are not configured.
+	defaultURLBatchWorkers  = 8
+	defaultURLBatchMaxBytes = 64 << 20
+
+	// maxURLBatchLineBytes bounds a single line of NDJSON or plain text.
+	maxURLBatchLineBytes = 1 << 20
+)
+
+// Media types a batch may be sent as. Results always come back as NDJSON.
+const (
+	mediaTypeJSON   = "application/json"
+	mediaTypeNDJSON = "application/x-ndjson"
+	mediaTypeText   = "text/plain"
+)
+
+// @Summary      Process a batch of URLs
+// @Description  Processes many URLs, sent as a JSON array, NDJSON or plain text with one URL per line. Entries are strings or URLRequest objects; the query parameters set the operation, rules and options of entries that do not set their own. Results stream back as NDJSON in input order, one URLBatchResult per entry. An entry that fails carries its error and the batch goes on; a result without a line reports an error that ended the batch. URLs cannot be resolved in batches.
+// @Tags         url
+// @Accept       json
+// @Accept       application/x-ndjson
+// @Accept       plain
+// @Produce      application/x-ndjson
+// @Param        payload body []string true "URLs or URL requests"
+// @Param        operation query string false "operation for entries without one" Enums(canonical, redirection, all, normalize)
+// @Param        rules query string false "comma separated rules for entries without an operation"
+// @Param        profile query string false "profile for entries without one"
+// @Param        target_host query string false "target host for entries without one"
+// @Param        unicode query bool false "return the Unicode form of every URL"
+// @Param        explain query bool false "return a trace for every URL"
+// @Success      200 {object} URLBatchResult
+// @Failure      400 {object} response.Problem
+// @Failure      415 {object} response.Problem
+// @Failure      429 {object} response.Problem
+// @Router       /url/process/batch [post]
+func (app *application) processURLBatchHandler(w http.ResponseWriter, r *http.Request) {
+	defaults, err := urlBatchDefaults(r.URL.Query())
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var entries urlBatchReader
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case mediaTypeJSON:
+		if entries, err = newURLBatchArray(r.Body); err != nil {
+			app.badRequest(w, r, err)
+			return
+		}
+	case mediaTypeNDJSON:
+		entries = newURLBatchLines(r.Body, decodeURLBatchEntry)
+	case mediaTypeText:
+		entries = newURLBatchLines(r.Body, func(line []byte) (URLRequest, error) {
+			return URLRequest{URL: string(line)}, nil
+		})
+	default:
+		app.unsupportedMediaType(w, r, []string{mediaTypeJSON, mediaTypeNDJSON, mediaTypeText})
+		return
+	}
+
+	workers := app.config.urlProcessor.batchWorkers
+	if workers <= 0 {
+		workers = defaultURLBatchWorkers
+	}
+
+	rc := http.NewResponseController(w)
+
+	// Results are written while the body is still being read. Servers and
+	// writers that cannot do both are left to buffer.
+	_ = rc.EnableFullDuplex()
+
+	w.Header().Set("Content-Type", mediaTypeNDJSON)
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	type job struct {
+		entry  urlBatchEntry
+		result chan URLBatchResult
+	}
+
+	// pending holds the result of every entry read, in input order, and
+	// bounds how far reading runs ahead of writing.
+	pending := make(chan chan URLBatchResult, 2*workers)
+	jobs := make(chan job)
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.result <- app.processURLBatchEntry(r, j.entry)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+
+		for {
+			extendDeadline(rc.SetReadDeadline, app.config.http.readTimeout)
+
+			entry, err := entries.next()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			entry.input = withURLBatchDefaults(entry.input, defaults)
+
+			result := make(chan URLBatchResult, 1)
+			if err != nil {
+				result <- URLBatchResult{Error: batchProblem(http.StatusBadRequest, err.Error())}
+			}
+
+			select {
+			case pending <- result:
+			case <-ctx.Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+
+			select {
+			case jobs <- job{entry: entry, result: result}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	enc := json.NewEncoder(w)
+	for result := range pending {
+		extendDeadline(rc.SetWriteDeadline, app.config.http.writeTimeout)
+
+		if err := enc.Encode(<-result); err != nil {
+			break
+		}
+
+		// Flush once the results ready are written rather than after each.
+		if len(pending) == 0 {
+			if err := rc.Flush(); err != nil {
+				break
+			}
+		}
+	}
+
+	// Stop reading if writing failed, and wait for the reader and workers.
+	cancel()
+	for range pending {
+	}
+	wg.Wait()
+}
+
+// processURLBatchEntry processes a single entry of a batch, reporting any
+// failure in the result.
+func (app *application) processURLBatchEntry(r *http.Request, entry urlBatchEntry) URLBatchResult {
+	res := URLBatchResult{Line: entry.line, URL: entry.input.URL}
+
+	if entry.err == nil {
+		entry.err = checkBatchResolve(entry.input)
+	}
+	if entry.err != nil {
+		res.Error = batchProblem(http.StatusBadRequest, entry.err.Error())
+		return res
+	}
+
+	resp, v, err := app.processURL(r.Context(), app.urlProcessorCore, entry.input)
+	if res.Error = app.urlProblem(r, v, err); res.Error == nil {
+		res.URLResponse = &resp
+	}
+
+	return res
+}
+
+// urlProblem turns the outcome of processURL into the problem reported for a
+// single URL among many, or nil when it succeeded.
+func (app *application) urlProblem(r *http.Request, v validator.Validator, err error) *response.Problem {
+	switch {
+	case v.HasErrors():
+		problem := validationProblem(v)
+		return &problem
+	case errors.Is(err, urlprocessor.ErrInvalidOperation):
+		return batchProblem(http.StatusBadRequest, err.Error())
+	case errors.Is(err, urlprocessor.ErrResolve):
+		return batchProblem(http.StatusBadGateway, err.Error())
+	case err != nil:
+		app.reportServerError(r, err)
+		return batchProblem(http.StatusInternalServerError, "The server encountered a problem and could not process this URL")
+	default:
+		return nil
+	}
+}
+
+func batchProblem(status int, message string) *response.Problem {
+	if message != "" {
+		message = strings.ToUpper(message[:1]) + message[1:]
+	}
+
+	problem := response.NewProblem(status, message)
+	return &problem
+}
+
+// extendDeadline moves a read or write deadline of the connection d into the
+// future, so that a long batch is bounded per entry rather than as a whole.
+func extendDeadline(set func(time.Time) error, d time.Duration) {
+	if d > 0 {
+		_ = set(time.Now().Add(d))
+	}
+}
+
+// errBatchResolve is reported for batch entries that would resolve their URL.
+// Resolving makes outbound requests, up to url-resolve-max-hops of them, which
+// a single rate-limited batch request must not be able to multiply.
+var errBatchResolve = errors.New("the resolve rule is not available in batches, use /url/process")
+
+func checkBatchResolve(input URLRequest) error {
+	if input.Operation == string(urlprocessor.OpResolve) || slices.Contains(input.Rules, urlprocessor.RuleResolve) {
+		return errBatchResolve
+	}
+	return nil
+}
+
+// urlBatchDefaults reads the fields that entries of a batch leave empty from
+// the query string.
+func urlBatchDefaults(query url.Values) (URLRequest, error) {
+	defaults := URLRequest{
+		Operation:  query.Get("operation"),
+		Profile:    query.Get("profile"),
+		TargetHost: query.Get("target_host"),
+	}
+
+	if rules := query.Get("rules"); rules != "" {
+		if defaults.Operation != "" {
+			return URLRequest{}, errors.New("operation and rules must not both be given")
+		}
+		defaults.Rules = strings.Split(rules, ",")
+	}
+
+	if err := checkBatchResolve(defaults); err != nil {
+		return URLRequest{}, err
+	}
+
+	for name, dst := range map[string]*bool{"unicode": &defaults.Unicode, "explain": &defaults.Explain} {
+		if s := query.Get(name); s != "" {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return URLRequest{}, fmt.Errorf("%s must be true or false", name)
+			}
+			*dst = b
+		}
+	}
+
+	return defaults, nil
+}
+
+// withURLBatchDefaults fills the fields input leaves empty from defaults. An
+// input with an operation or rules of its own keeps them both.
+func withURLBatchDefaults(input, defaults URLRequest) URLRequest {
+	if input.Operation == "" && input.Rules == nil {
+		input.Operation, input.Rules = defaults.Operation, defaults.Rules
+	}
+	if input.Profile == "" {
+		input.Profile = defaults.Profile
+	}
+	if input.TargetHost == "" {
+		input.TargetHost = defaults.TargetHost
+	}
+	input.Unicode = input.Unicode || defaults.Unicode
+	input.Explain = input.Explain || defaults.Explain
+
+	return input
+}
+
+// urlBatchEntry is an entry of a batch, or the reason it could not be
+// decoded. Either way the batch goes on.
+type urlBatchEntry struct {
+	line  int
+	input URLRequest
+	err   error
+}
+
+// urlBatchReader reads the entries of a batch in order. next returns io.EOF
+// after the last entry, and any other error when the batch cannot go on.
+type urlBatchReader interface {
+	next() (urlBatchEntry, error)
+}
+
+// decodeURLBatchEntry decodes an entry given as a JSON string or a URLRequest
+// object.
+func decodeURLBatchEntry(data []byte) (URLRequest, error) {
+	if len(data) > 0 && data[0] == '"' {
+		var rawURL string
+		if err := json.Unmarshal(data, &rawURL); err != nil {
+			return URLRequest{}, request.JSONError(err)
+		}
+		return URLRequest{URL: rawURL}, nil
+	}
+
+	var input URLRequest
+	if err := json.Unmarshal(data, &input); err != nil {
+		return URLRequest{}, request.JSONError(err)
+	}
+	return input, nil
+}
+
+// urlBatchLines reads a batch of one entry per line, skipping blank lines.
+type urlBatchLines struct {
+	scanner *bufio.Scanner
+	decode  func(line []byte) (URLRequest, error)
+	line    int
+}
+
+func newURLBatchLines(r io.Reader, decode func(line []byte) (URLRequest, error)) *urlBatchLines {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxURLBatchLineBytes)
+
+	return &urlBatchLines{scanner: scanner, decode: decode}
+}
+
+func (l *urlBatchLines) next() (urlBatchEntry, error) {
+	for l.scanner.Scan() {
+		l.line++
+
+		line := bytes.TrimSpace(l.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		input, err := l.decode(line)
+		return urlBatchEntry{line: l.line, input: input, err: err}, nil
+	}
+
+	err := l.scanner.Err()
+	switch {
+	case err == nil:
+		return urlBatchEntry{}, io.EOF
+	case errors.Is(err, bufio.ErrTooLong):
+		return urlBatchEntry{}, fmt.Errorf("line %d is longer than %d bytes", l.line+1, maxURLBatchLineBytes)
+	default:
+		return urlBatchEntry{}, request.JSONError(err)
+	}
+}
+
+// urlBatchArray reads a batch sent as a JSON array, one element at a time.
+type urlBatchArray struct {
+	dec   *json.Decoder
+	index int
+}
+
+func newURLBatchArray(r io.Reader) (*urlBatchArray, error) {
+	dec := json.NewDecoder(r)
+
+	tok, err := dec.Token()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("body must not be empty")
+		}
+		return nil, request.JSONError(err)
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, errors.New("body must be a JSON array")
+	}
+
+	return &urlBatchArray{dec: dec}, nil
+}
+
+func (a *urlBatchArray) next() (urlBatchEntry, error) {
+	if !a.dec.More() {
+		if _, err := a.dec.Token(); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return urlBatchEntry{}, request.JSONError(err)
+		}
+		return urlBatchEntry{}, io.EOF
+	}
+
+	var raw json.RawMessage
+	if err := a.dec.Decode(&raw); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return urlBatchEntry{}, request.JSONError(err)
+	}
+	a.index++
+
+	input, err := decodeURLBatchEntry(raw)
+	return urlBatchEntry{line: a.index, input: input, err: err}, nil
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Babatunde50/book-crud/server/business/urlprocessor"
+	"github.com/Babatunde50/book-crud/server/business/urlrule"
+	"github.com/Babatunde50/book-crud/server/internal/request"
+	"github.com/Babatunde50/book-crud/server/internal/response"
+	"github.com/Babatunde50/book-crud/server/internal/validator"
+	"github.com/google/uuid"
+	"github.com/julienschmidt/httprouter"
+)
+
+// maxURLRuleDryRunURLs bounds the URLs of a dry run, each processed twice.
+const maxURLRuleDryRunURLs = 100
+
+// @Summary      List URL rules
+// @Description  Lists the stored URL rules by kind, path rules in the order they are tried
+// @Tags         url-rules
+// @Produce      json
+// @Security     AdminToken
+// @Success      200 {array} URLRuleResponse
+// @Failure      401 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /url/rules [get]
+func (app *application) listURLRulesHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := app.urlRuleCore.QueryAll(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	resp := make([]URLRuleResponse, len(rules))
+	for i, rule := range rules {
+		resp[i] = toURLRuleResponse(rule)
+	}
+
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// @Summary      Create a URL rule
+// @Description  Stores a host mapping, query policy or path rewrite the URL processor follows on top of its configuration, from the next URL processed on. Host and query rules win over configured ones for the same host; there is at most one of each per host.
+// @Tags         url-rules
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        payload body URLRuleRequest true "Rule to store"
+// @Param        Idempotency-Key header string false "Key that makes retries of this request safe"
+// @Success      201 {object} URLRuleResponse
+// @Failure      400 {object} response.Problem
+// @Failure      401 {object} response.Problem
+// @Failure      409 {object} response.Problem
+// @Failure      422 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /url/rules [post]
+func (app *application) createURLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var input URLRuleRequest
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	rule, err := app.urlRuleCore.Create(r.Context(), toNewURLRule(input))
+	if err != nil {
+		app.urlRuleError(w, r, err)
+		return
+	}
+
+	err = response.JSON(w, http.StatusCreated, toURLRuleResponse(rule))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// @Summary      Show a URL rule
+// @Tags         url-rules
+// @Produce      json
+// @Security     AdminToken
+// @Param        id path string true "Rule ID (UUID)"
+// @Success      200 {object} URLRuleResponse
+// @Failure      400 {object} response.Problem
+// @Failure      401 {object} response.Problem
+// @Failure      404 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /url/rules/{id} [get]
+func (app *application) showURLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	rule, err := app.urlRuleCore.QueryByID(r.Context(), id)
+	if err != nil {
+		app.urlRuleError(w, r, err)
+		return
+	}
+
+	err = response.JSON(w, http.StatusOK, toURLRuleResponse(rule))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// @Summary      Update a URL rule
+// @Description  Changes the fields of a rule that the payload sets and applies the changed rule. The kind of a rule never changes.
+// @Tags         url-rules
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        id path string true "Rule ID (UUID)"
+// @Param        payload body URLRuleUpdateRequest true "Fields to change"
+// @Success      200 {object} URLRuleResponse
+// @Failure      400 {object} response.Problem
+// @Failure      401 {object} response.Problem
+// @Failure      404 {object} response.Problem
+// @Failure      409 {object} response.Problem
+// @Failure      422 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /url/rules/{id} [put]
+func (app *application) updateURLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	var input URLRuleUpdateRequest
+	err = request.DecodeJSON(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	ur := urlrule.UpdateRule{
+		Host:        input.Host,
+		TargetHost:  input.TargetHost,
+		Pattern:     input.Pattern,
+		Replacement: input.Replacement,
+		Priority:    input.Priority,
+		Description: input.Description,
+	}
+	if input.Query != nil {
+		query := toQueryPolicy(input.Query)
+		ur.Query = &query
+	}
+
+	rule, err := app.urlRuleCore.UpdateByID(r.Context(), id, ur)
+	if err != nil {
+		app.urlRuleError(w, r, err)
+		return
+	}
+
+	err = response.JSON(w, http.StatusOK, toURLRuleResponse(rule))
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// @Summary      Delete a URL rule
+// @Description  Removes a rule and stops applying it
+// @Tags         url-rules
+// @Security     AdminToken
+// @Param        id path string true "Rule ID (UUID)"
+// @Success      204
+// @Failure      400 {object} response.Problem
+// @Failure      401 {object} response.Problem
+// @Failure      404 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /url/rules/{id} [delete]
+func (app *application) deleteURLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	err = app.urlRuleCore.Delete(r.Context(), id)
+	if err != nil {
+		app.urlRuleError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validateURLRuleDryRunRequest(input URLRuleDryRunRequest) validator.Validator {
+	var v validator.Validator
+
+	v.CheckField(input.ID != nil || input.Rule != nil, "rule", "must be provided unless id is")
+	v.CheckField(len(input.URLs) > 0, "urls", "must be provided")
+	v.CheckField(len(input.URLs) <= maxURLRuleDryRunURLs, "urls", "must not hold more than 100 URLs")
+
+	if input.Rules != nil {
+		v.CheckField(input.Operation == "", "operation", "must not be given with rules")
+	}
+
+	return v
+}
+
+// @Summary      Try out a change to the URL rules
+// @Description  Processes URLs with the stored rules and with them changed, without storing anything: rule added, or in place of the rule with the given id; id alone removes that rule. Every URL reports both outcomes and whether they differ.
+// @Tags         url-rules
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        payload body URLRuleDryRunRequest true "Change and URLs to try it on"
+// @Success      200 {object} URLRuleDryRunResponse
+// @Failure      400 {object} response.Problem
+// @Failure      401 {object} response.Problem
+// @Failure      404 {object} response.Problem
+// @Failure      409 {object} response.Problem
+// @Failure      422 {object} response.Problem
+// @Failure      500 {object} response.Problem
+// @Router       /url/rules/dry-run [post]
+func (app *application) dryRunURLRuleHandler(w http.ResponseWriter, r *http.Request) {
+	var input URLRuleDryRunRequest
+
+	err := request.DecodeJSON(w, r, &input)
+	if err != nil {
+		app.badRequest(w, r, err)
+		return
+	}
+
+	v := validateURLRuleDryRunRequest(input)
+	if v.HasErrors() {
+		app.failedValidation(w, r, v)
+		return
+	}
+
+	var replaces uuid.UUID
+	if input.ID != nil {
+		replaces = *input.ID
+	}
+
+	var nr *urlrule.NewRule
+	if input.Rule != nil {
+		rule := toNewURLRule(*input.Rule)
+		nr = &rule
+	}
+
+	proposed, err := app.urlRuleCore.Preview(r.Context(), replaces, nr)
+	if err != nil {
+		var fe urlrule.FieldErrors
+		if errors.As(err, &fe) {
+			for field, msg := range fe {
+				v.AddFieldError("rule."+field, msg)
+			}
+			app.failedValidation(w, r, v)
+			return
+		}
+
+		app.urlRuleError(w, r, err)
+		return
+	}
+
+	if input.Operation == "" && input.Rules == nil {
+		input.Operation = string(urlprocessor.OpAll)
+	}
+
+	resp := URLRuleDryRunResponse{Results: make([]URLRuleDryRunResult, len(input.URLs))}
+	for i, rawURL := range input.URLs {
+		req := URLRequest{URL: rawURL, Operation: input.Operation, Rules: input.Rules, Explain: input.Explain}
+
+		res := URLRuleDryRunResult{
+			URL:      rawURL,
+			Current:  app.processURLOutcome(r, app.urlProcessorCore, req),
+			Proposed: app.processURLOutcome(r, proposed, req),
+		}
+		res.Changed = outcomeOf(res.Current) != outcomeOf(res.Proposed)
+
+		resp.Results[i] = res
+	}
+
+	err = response.JSON(w, http.StatusOK, resp)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// processURLOutcome processes a single URL among many with p, reporting any
+// failure in the outcome.
+func (app *application) processURLOutcome(r *http.Request, p *urlprocessor.URLProcessor, input URLRequest) URLOutcome {
+	resp, v, err := app.processURL(r.Context(), p, input)
+	if problem := app.urlProblem(r, v, err); problem != nil {
+		return URLOutcome{Error: problem}
+	}
+	return URLOutcome{URLResponse: &resp}
+}
+
+// outcomeOf sums up an outcome for comparison: the processed URL, or the
+// problem that failed it.
+func outcomeOf(o URLOutcome) string {
+	if o.Error != nil {
+		return o.Error.Title + ": " + o.Error.Detail
+	}
+	return o.ProcessedURL
+}
+
+// urlRuleError reports the failure of a URL rule operation.
+func (app *application) urlRuleError(w http.ResponseWriter, r *http.Request, err error) {
+	var fe urlrule.FieldErrors
+
+	switch {
+	case errors.As(err, &fe):
+		var v validator.Validator
+		for field, msg := range fe {
+			v.AddFieldError(field, msg)
+		}
+		app.failedValidation(w, r, v)
+	case errors.Is(err, urlrule.ErrInvalidRule):
+		// The rule is valid alone but not together with the stored ones.
+		var v validator.Validator
+		v.AddError(err.Error())
+		app.failedValidation(w, r, v)
+	case errors.Is(err, urlrule.ErrConflict):
+		app.errorMessage(w, r, http.StatusConflict, "A rule of this kind already exists for the host", nil)
+	case errors.Is(err, urlrule.ErrNotFound):
+		app.notFound(w, r)
+	default:
+		app.serverError(w, r, err)
+	}
+}
+
+func toNewURLRule(input URLRuleRequest) urlrule.NewRule {
+	return urlrule.NewRule{
+		Kind:        urlrule.Kind(input.Kind),
+		Host:        input.Host,
+		TargetHost:  input.TargetHost,
+		Query:       toQueryPolicy(input.Query),
+		Pattern:     input.Pattern,
+		Replacement: input.Replacement,
+		Priority:    input.Priority,
+		Description: input.Description,
+	}
+}
+
+func toQueryPolicy(input *URLQueryPolicy) urlprocessor.QueryPolicy {
+	if input == nil {
+		return urlprocessor.QueryPolicy{}
+	}
+	return urlprocessor.QueryPolicy{Allow: input.Allow, Deny: input.Deny, DropAll: input.DropAll}
+}
+
+func toURLRuleResponse(rule urlrule.Rule) URLRuleResponse {
+	resp := URLRuleResponse{
+		ID:          rule.ID,
+		Kind:        string(rule.Kind),
+		Host:        rule.Host,
+		TargetHost:  rule.TargetHost,
+		Pattern:     rule.Pattern,
+		Replacement: rule.Replacement,
+		Priority:    rule.Priority,
+		Description: rule.Description,
+		DateCreated: rule.DateCreated,
+		DateUpdated: rule.DateUpdated,
+		Version:     rule.Version,
+	}
+
+	if rule.Kind == urlrule.KindQuery {
+		resp.Query = &URLQueryPolicy{Allow: rule.Query.Allow, Deny: rule.Query.Deny, DropAll: rule.Query.DropAll}
+	}
+
+	return resp
+}
@@ -3,25 +3,114 @@ package database
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"time"
 
-	"github.com/Babatunde50/book-crud/server/assets"
-
-	"github.com/golang-migrate/migrate/v4"
-	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
 
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/lib/pq"
 )
 
-const defaultTimeout = 3 * time.Second
+const (
+	defaultTimeout = 3 * time.Second
+
+	// automigrateTimeout bounds how long New waits for another instance's
+	// migrations to finish and applies its own.
+	automigrateTimeout = 5 * time.Minute
+)
+
+// Default connection pool settings, used for any Pool field left at zero.
+const (
+	DefaultMaxOpenConns    = 25
+	DefaultMaxIdleConns    = 25
+	DefaultConnMaxIdleTime = 5 * time.Minute
+	DefaultConnMaxLifetime = 2 * time.Hour
+)
+
+// DefaultReplicaCheckInterval is how often replicas are health-checked when
+// Config.ReplicaCheckInterval is zero.
+const DefaultReplicaCheckInterval = 5 * time.Second
 
+// Pool sizes and recycles the connections to one database server.
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+	ConnMaxLifetime time.Duration
+}
+
+func (p Pool) withDefaults() Pool {
+	if p.MaxOpenConns == 0 {
+		p.MaxOpenConns = DefaultMaxOpenConns
+	}
+	if p.MaxIdleConns == 0 {
+		p.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if p.ConnMaxIdleTime == 0 {
+		p.ConnMaxIdleTime = DefaultConnMaxIdleTime
+	}
+	if p.ConnMaxLifetime == 0 {
+		p.ConnMaxLifetime = DefaultConnMaxLifetime
+	}
+	return p
+}
+
+func (p Pool) apply(db *sqlx.DB) {
+	p = p.withDefaults()
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+}
+
+// Config describes how to connect to the database. DSNs are written as
+// user:pass@host:port/db?params, without the postgres:// scheme.
+type Config struct {
+	DSN         string
+	Automigrate bool
+	Pool        Pool
+
+	// ReplicaDSNs lists read replicas that Reader may route queries to. Every
+	// replica gets its own ReplicaPool.
+	ReplicaDSNs          []string
+	ReplicaPool          Pool
+	ReplicaCheckInterval time.Duration
+
+	// QueryTimeout bounds every statement, through its context and the
+	// server's statement_timeout. Statements taking SlowQueryThreshold or
+	// longer are logged to Logger, when set. Per-query metrics are registered
+	// with Registerer, when set.
+	QueryTimeout       time.Duration
+	SlowQueryThreshold time.Duration
+	Logger             *slog.Logger
+	Registerer         prometheus.Registerer
+}
+
+// DB is a connection pool to the primary database, and optionally a set of
+// read replicas.
 type DB struct {
 	*sqlx.DB
+	name     string
+	replicas *replicaSet
+	obs      *observer
 }
 
-func New(dsn string, automigrate bool) (*DB, error) {
+// New connects to the primary database, migrating it if asked to, and starts
+// health-checking the replicas. Replicas that are unreachable at startup do
+// not cause an error; reads are served by the primary until they recover.
+func New(cfg Config) (*DB, error) {
+	obs, err := newObserver(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	dsn, err := withStatementTimeout(cfg.DSN, obs.timeout)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
 	defer cancel()
 
@@ -30,30 +119,48 @@ func New(dsn string, automigrate bool) (*DB, error) {
 		return nil, err
 	}
 
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxIdleTime(5 * time.Minute)
-	db.SetConnMaxLifetime(2 * time.Hour)
+	cfg.Pool.apply(db)
 
-	if automigrate {
-		iofsDriver, err := iofs.New(assets.EmbeddedFiles, "migrations")
-		if err != nil {
+	if cfg.Automigrate {
+		if err := automigrate(cfg.DSN); err != nil {
+			db.Close()
 			return nil, err
 		}
+	}
 
-		migrator, err := migrate.NewWithSourceInstance("iofs", iofsDriver, "postgres://"+dsn)
-		if err != nil {
-			return nil, err
-		}
+	replicas, err := openReplicas(cfg, obs)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
 
-		err = migrator.Up()
-		switch {
-		case errors.Is(err, migrate.ErrNoChange):
-			break
-		case err != nil:
-			return nil, err
-		}
+	return &DB{DB: db, name: "primary", replicas: replicas, obs: obs}, nil
+}
+
+func automigrate(dsn string) error {
+	migrator, err := NewMigrator(dsn)
+	if err != nil {
+		return err
 	}
+	defer migrator.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), automigrateTimeout)
+	defer cancel()
+
+	err = migrator.Up(ctx)
+	if errors.Is(err, ErrNoChange) {
+		return nil
+	}
+	return err
+}
+
+// Name identifies the connection pool, "primary" or "replica-N".
+func (db *DB) Name() string {
+	return db.name
+}
 
-	return &DB{db}, nil
+// Close stops health-checking the replicas and closes every pool.
+func (db *DB) Close() error {
+	errs := []error{db.replicas.close()}
+	return errors.Join(append(errs, db.DB.Close())...)
 }
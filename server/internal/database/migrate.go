@@ -0,0 +1,217 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Babatunde50/book-crud/server/assets"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// ErrNoChange is returned by Migrator methods when the database was already
+// at the requested version.
+var ErrNoChange = migrate.ErrNoChange
+
+// migrationLockKey identifies the advisory lock held while migrating. It is
+// shared by every instance of the service.
+var migrationLockKey = func() int64 {
+	h := fnv.New64a()
+	h.Write([]byte("book-crud:migrations"))
+	return int64(h.Sum64())
+}()
+
+// Migrator applies the migrations embedded in the binary. Every operation
+// holds a PostgreSQL advisory lock, so instances starting together or an
+// operator running the CLI never migrate concurrently.
+type Migrator struct {
+	dsn  string
+	lock *sql.DB
+}
+
+// NewMigrator constructs a Migrator for the database at dsn.
+func NewMigrator(dsn string) (*Migrator, error) {
+	lock, err := sql.Open("postgres", "postgres://"+dsn)
+	if err != nil {
+		return nil, err
+	}
+	lock.SetMaxOpenConns(1)
+
+	return &Migrator{dsn: dsn, lock: lock}, nil
+}
+
+// Close releases the Migrator's connection.
+func (m *Migrator) Close() error {
+	return m.lock.Close()
+}
+
+// Up applies every migration not applied yet.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.run(ctx, func(mg *migrate.Migrate) error { return mg.Up() })
+}
+
+// Down rolls back the n most recently applied migrations.
+func (m *Migrator) Down(ctx context.Context, n int) error {
+	if n < 1 {
+		return errors.New("the number of migrations to roll back must be at least 1")
+	}
+	return m.run(ctx, func(mg *migrate.Migrate) error { return mg.Steps(-n) })
+}
+
+// Goto migrates up or down to version.
+func (m *Migrator) Goto(ctx context.Context, version uint) error {
+	return m.run(ctx, func(mg *migrate.Migrate) error { return mg.Migrate(version) })
+}
+
+// Force records version as the current one and clears the dirty flag without
+// running any migration, to recover from a migration that failed part way.
+func (m *Migrator) Force(ctx context.Context, version int) error {
+	return m.run(ctx, func(mg *migrate.Migrate) error { return mg.Force(version) })
+}
+
+// Version returns the current version and whether it is dirty. A database
+// that has never been migrated reports version 0.
+func (m *Migrator) Version(ctx context.Context) (version uint, dirty bool, err error) {
+	err = m.run(ctx, func(mg *migrate.Migrate) error {
+		version, dirty, err = mg.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return nil
+		}
+		return err
+	})
+	return version, dirty, err
+}
+
+func (m *Migrator) run(ctx context.Context, fn func(*migrate.Migrate) error) (err error) {
+	conn, err := m.lock.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer func() {
+		// The session lock outlives a cancelled ctx, so unlock without it.
+		if _, unlockErr := conn.ExecContext(context.WithoutCancel(ctx), `SELECT pg_advisory_unlock($1)`, migrationLockKey); unlockErr != nil {
+			err = errors.Join(err, fmt.Errorf("releasing migration lock: %w", unlockErr))
+		}
+	}()
+
+	source, err := iofs.New(assets.EmbeddedFiles, "migrations")
+	if err != nil {
+		return err
+	}
+
+	mg, err := migrate.NewWithSourceInstance("iofs", source, "postgres://"+m.dsn)
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+
+	return fn(mg)
+}
+
+var (
+	migrationFile   = regexp.MustCompile(`^(\d+)_.*\.(up|down)\.sql$`)
+	nonAlphanumeric = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// CreateMigration writes empty up and down files for a migration called name
+// to dir, numbered after the highest existing migration, and returns their
+// paths.
+func CreateMigration(dir, name string) (up, down string, err error) {
+	name = strings.Trim(nonAlphanumeric.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", "", errors.New("migration name must contain letters or digits")
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	var last uint64
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		if n, err := strconv.ParseUint(match[1], 10, 64); err == nil && n > last {
+			last = n
+		}
+	}
+
+	base := filepath.Join(dir, fmt.Sprintf("%06d_%s", last+1, name))
+	up, down = base+".up.sql", base+".down.sql"
+
+	for _, path := range []string{up, down} {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return "", "", err
+		}
+		if err := f.Close(); err != nil {
+			return "", "", err
+		}
+	}
+
+	return up, down, nil
+}
+
+// ExpectedSchemaVersion returns the version of the newest migration embedded
+// in the binary.
+func ExpectedSchemaVersion() (uint, error) {
+	driver, err := iofs.New(assets.EmbeddedFiles, "migrations")
+	if err != nil {
+		return 0, err
+	}
+	defer driver.Close()
+
+	version, err := driver.First()
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		next, err := driver.Next(version)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return version, nil
+		case err != nil:
+			return 0, err
+		}
+		version = next
+	}
+}
+
+// SchemaVersion returns the migration version the database is at and whether
+// the last migration failed part way through. A database that has never been
+// migrated reports version 0.
+func (db *DB) SchemaVersion(ctx context.Context) (uint, bool, error) {
+	const query = `SELECT version, dirty FROM schema_migrations LIMIT 1`
+
+	var row struct {
+		Version int64 `db:"version"`
+		Dirty   bool  `db:"dirty"`
+	}
+
+	if err := db.GetContext(ctx, &row, query); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	return uint(row.Version), row.Dirty, nil
+}
@@ -0,0 +1,38 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_CreateMigration(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{"000002_create_books_table.up.sql", "000002_create_books_table.down.sql", "000010_add_index.up.sql", "README.md"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up, down, err := CreateMigration(dir, "Add Book Tags!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := filepath.Join(dir, "000011_add_book_tags.up.sql"); up != want {
+		t.Errorf("got up file %s, want %s", up, want)
+	}
+	if want := filepath.Join(dir, "000011_add_book_tags.down.sql"); down != want {
+		t.Errorf("got down file %s, want %s", down, want)
+	}
+	for _, path := range []string{up, down} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s to exist: %v", path, err)
+		}
+	}
+
+	if _, _, err := CreateMigration(dir, "!!!"); err == nil {
+		t.Error("expected a name without letters or digits to be rejected")
+	}
+}
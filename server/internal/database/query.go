@@ -0,0 +1,152 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Default query limits, used when the Config fields are zero.
+const (
+	DefaultQueryTimeout       = 5 * time.Second
+	DefaultSlowQueryThreshold = 200 * time.Millisecond
+)
+
+// observer bounds, measures and logs the statements run through a DB. It is
+// shared by the primary and its replicas.
+type observer struct {
+	timeout       time.Duration
+	slowThreshold time.Duration
+	log           *slog.Logger
+
+	queries  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newObserver(cfg Config) (*observer, error) {
+	obs := observer{
+		timeout:       cfg.QueryTimeout,
+		slowThreshold: cfg.SlowQueryThreshold,
+		log:           cfg.Logger,
+	}
+
+	if obs.timeout == 0 {
+		obs.timeout = DefaultQueryTimeout
+	}
+	if obs.slowThreshold == 0 {
+		obs.slowThreshold = DefaultSlowQueryThreshold
+	}
+
+	if cfg.Registerer == nil {
+		return &obs, nil
+	}
+
+	obs.queries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "db_queries_total",
+		Help: "Total number of database statements run.",
+	}, []string{"query", "pool", "status"})
+
+	obs.duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Time taken to run database statements.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"query", "pool"})
+
+	for _, c := range []prometheus.Collector{obs.queries, obs.duration} {
+		if err := cfg.Registerer.Register(c); err != nil {
+			return nil, fmt.Errorf("registering query metrics: %w", err)
+		}
+	}
+
+	return &obs, nil
+}
+
+// run calls fn, which runs query, under a span and the query timeout, and
+// records how long it took.
+func (db *DB) run(ctx context.Context, query string, args []any, fn func(ctx context.Context, span trace.Span) error) error {
+	name, text := parseQuery(query)
+
+	ctx, span := db.startSpan(ctx, name, text)
+	defer span.End()
+
+	if db.obs != nil && db.obs.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, db.obs.timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := fn(ctx, span)
+	elapsed := time.Since(start)
+
+	endSpan(span, err)
+	db.obs.observe(ctx, db.name, name, text, args, elapsed, err)
+
+	return err
+}
+
+func (obs *observer) observe(ctx context.Context, pool, name, text string, args []any, elapsed time.Duration, err error) {
+	if obs == nil {
+		return
+	}
+
+	if obs.queries != nil {
+		status := "ok"
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			status = "error"
+		}
+		obs.queries.WithLabelValues(name, pool, status).Inc()
+		obs.duration.WithLabelValues(name, pool).Observe(elapsed.Seconds())
+	}
+
+	if obs.log != nil && obs.slowThreshold > 0 && elapsed >= obs.slowThreshold {
+		obs.log.WarnContext(ctx, "slow query",
+			"query_name", name,
+			"query", text,
+			"args", redactArgs(args),
+			"pool", pool,
+			"elapsed", elapsed,
+			"threshold", obs.slowThreshold,
+		)
+	}
+}
+
+// redactArgs describes query arguments by type only, so that logs never carry
+// the values themselves.
+func redactArgs(args []any) []string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = fmt.Sprintf("%T", arg)
+	}
+	return types
+}
+
+// withStatementTimeout sets the statement_timeout run-time parameter on dsn,
+// so the server cancels statements that outlive their context deadline even
+// if the client has gone away. A timeout already present in dsn is kept.
+func withStatementTimeout(dsn string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return dsn, nil
+	}
+
+	u, err := url.Parse("postgres://" + dsn)
+	if err != nil {
+		return "", err
+	}
+
+	q := u.Query()
+	if !q.Has("statement_timeout") {
+		q.Set("statement_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String()[len("postgres://"):], nil
+}
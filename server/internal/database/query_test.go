@@ -0,0 +1,136 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_parseQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		wantName string
+		wantText string
+	}{
+		{
+			query: `-- name=bookdb.QueryByID
+				SELECT id, title
+				FROM books
+				WHERE id = $1`,
+			wantName: "bookdb.QueryByID",
+			wantText: "SELECT id, title FROM books WHERE id = $1",
+		},
+		{
+			query:    "insert into idempotency_keys (key) values ($1)",
+			wantName: "INSERT idempotency_keys",
+			wantText: "insert into idempotency_keys (key) values ($1)",
+		},
+		{
+			query:    "SELECT 1",
+			wantName: "SELECT",
+			wantText: "SELECT 1",
+		},
+		{
+			query:    "  ",
+			wantName: "postgresql",
+			wantText: "",
+		},
+	}
+
+	for _, tt := range tests {
+		name, text := parseQuery(tt.query)
+		if name != tt.wantName || text != tt.wantText {
+			t.Errorf("parseQuery(%q) = %q, %q; want %q, %q", tt.query, name, text, tt.wantName, tt.wantText)
+		}
+	}
+}
+
+func Test_withStatementTimeout(t *testing.T) {
+	tests := []struct {
+		dsn     string
+		timeout time.Duration
+		want    string
+	}{
+		{"user:pass@localhost/books?sslmode=disable", 5 * time.Second, "user:pass@localhost/books?sslmode=disable&statement_timeout=5000"},
+		{"user:pass@localhost/books?statement_timeout=100", 5 * time.Second, "user:pass@localhost/books?statement_timeout=100"},
+		{"user:pass@localhost/books", 0, "user:pass@localhost/books"},
+	}
+
+	for _, tt := range tests {
+		got, err := withStatementTimeout(tt.dsn, tt.timeout)
+		if err != nil {
+			t.Errorf("withStatementTimeout(%q): %v", tt.dsn, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("withStatementTimeout(%q, %s) = %q; want %q", tt.dsn, tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func Test_run(t *testing.T) {
+	var logs bytes.Buffer
+	reg := prometheus.NewRegistry()
+
+	obs, err := newObserver(Config{
+		QueryTimeout:       time.Second,
+		SlowQueryThreshold: 10 * time.Millisecond,
+		Logger:             slog.New(slog.NewTextHandler(&logs, nil)),
+		Registerer:         reg,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &DB{name: "primary", obs: obs}
+
+	const query = "-- name=bookdb.Create\nINSERT INTO books (title) VALUES ($1)"
+	ctx := context.Background()
+
+	err = db.run(ctx, query, []any{"secret title"}, func(ctx context.Context, span trace.Span) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("statement context has no deadline")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	err = db.run(ctx, query, []any{"secret title"}, func(ctx context.Context, span trace.Span) error {
+		time.Sleep(20 * time.Millisecond)
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got error %v, want %v", err, failed)
+	}
+
+	if got := testutil.ToFloat64(obs.queries.WithLabelValues("bookdb.Create", "primary", "ok")); got != 1 {
+		t.Errorf("got %v successful queries, want 1", got)
+	}
+	if got := testutil.ToFloat64(obs.queries.WithLabelValues("bookdb.Create", "primary", "error")); got != 1 {
+		t.Errorf("got %v failed queries, want 1", got)
+	}
+	if got := testutil.CollectAndCount(obs.duration); got != 1 {
+		t.Errorf("got %d duration series, want 1", got)
+	}
+
+	out := logs.String()
+	if strings.Count(out, "slow query") != 1 {
+		t.Errorf("want one slow query log, got %q", out)
+	}
+	if !strings.Contains(out, "query_name=bookdb.Create") || !strings.Contains(out, "args=[string]") {
+		t.Errorf("slow query log is missing the query name or arguments: %q", out)
+	}
+	if strings.Contains(out, "secret title") {
+		t.Errorf("slow query log leaks an argument: %q", out)
+	}
+}
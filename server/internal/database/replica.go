@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type replica struct {
+	db      *DB
+	healthy atomic.Bool
+}
+
+// replicaSet round-robins reads over the replicas that passed their last
+// health check.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+
+	stop func()
+	wg   sync.WaitGroup
+}
+
+func openReplicas(cfg Config, obs *observer) (*replicaSet, error) {
+	if len(cfg.ReplicaDSNs) == 0 {
+		return nil, nil
+	}
+
+	set := replicaSet{}
+
+	for i, dsn := range cfg.ReplicaDSNs {
+		// Open does not connect, so an unreachable replica is only marked
+		// unhealthy rather than failing startup.
+		dsn, err := withStatementTimeout(dsn, obs.timeout)
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+
+		db, err := sqlx.Open("postgres", "postgres://"+dsn)
+		if err != nil {
+			set.close()
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		cfg.ReplicaPool.apply(db)
+
+		set.replicas = append(set.replicas, &replica{
+			db: &DB{DB: db, name: fmt.Sprintf("replica-%d", i+1), obs: obs},
+		})
+	}
+
+	interval := cfg.ReplicaCheckInterval
+	if interval <= 0 {
+		interval = DefaultReplicaCheckInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	set.stop = cancel
+	set.checkAll(ctx)
+
+	set.wg.Add(1)
+	go func() {
+		defer set.wg.Done()
+		set.monitor(ctx, interval)
+	}()
+
+	return &set, nil
+}
+
+func (s *replicaSet) monitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkAll(ctx)
+		}
+	}
+}
+
+func (s *replicaSet) checkAll(ctx context.Context) {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+		r.healthy.Store(r.db.PingContext(ctx) == nil)
+		cancel()
+	}
+}
+
+// pick returns the next healthy replica, or nil when there is none.
+func (s *replicaSet) pick() *DB {
+	if s == nil {
+		return nil
+	}
+
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1)
+
+	for i := range n {
+		r := s.replicas[(start+i)%n]
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+
+	return nil
+}
+
+func (s *replicaSet) close() error {
+	if s == nil {
+		return nil
+	}
+
+	if s.stop != nil {
+		s.stop()
+	}
+	s.wg.Wait()
+
+	var errs []error
+	for _, r := range s.replicas {
+		errs = append(errs, r.db.DB.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Reader returns the pool that read-only queries for ctx should use: a
+// healthy replica when there is one, and the primary when there are no
+// replicas, none is healthy, ctx carries a transaction, or ctx has been pinned
+// to the primary by a write.
+func (db *DB) Reader(ctx context.Context) *DB {
+	if inTran(ctx) || pinnedToPrimary(ctx) {
+		return db
+	}
+
+	if r := db.replicas.pick(); r != nil {
+		return r
+	}
+
+	return db
+}
+
+// Replicas returns the replica pools, healthy or not.
+func (db *DB) Replicas() []*DB {
+	if db.replicas == nil {
+		return nil
+	}
+
+	pools := make([]*DB, len(db.replicas.replicas))
+	for i, r := range db.replicas.replicas {
+		pools[i] = r.db
+	}
+	return pools
+}
+
+type pinKey struct{}
+
+// WithReadYourWrites returns a context in which a write through the primary
+// routes every later Reader call to the primary as well, so that the caller
+// sees its own writes despite replication lag.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, pinKey{}, new(atomic.Bool))
+}
+
+func pinToPrimary(ctx context.Context) {
+	if pinned, ok := ctx.Value(pinKey{}).(*atomic.Bool); ok {
+		pinned.Store(true)
+	}
+}
+
+func pinnedToPrimary(ctx context.Context) bool {
+	pinned, ok := ctx.Value(pinKey{}).(*atomic.Bool)
+	return ok && pinned.Load()
+}
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/Babatunde50/book-crud/server/internal/database")
+
+// The methods below shadow the ones promoted from the embedded *sqlx.DB so
+// every statement the stores run is recorded as a client span, is timed and
+// bounded by the query timeout, runs in the transaction carried by the
+// context if there is one, and pins a read-your-writes context to the primary
+// when it writes.
+
+// ExecContext executes a statement that returns no rows.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	pinToPrimary(ctx)
+
+	var result sql.Result
+	err := db.run(ctx, query, args, func(ctx context.Context, span trace.Span) (err error) {
+		result, err = db.executor(ctx).ExecContext(ctx, query, args...)
+		recordAffected(span, result, err)
+		return err
+	})
+
+	return result, err
+}
+
+// NamedExecContext executes a statement using named parameters bound from arg.
+func (db *DB) NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error) {
+	pinToPrimary(ctx)
+
+	var result sql.Result
+	err := db.run(ctx, query, []any{arg}, func(ctx context.Context, span trace.Span) (err error) {
+		result, err = db.executor(ctx).NamedExecContext(ctx, query, arg)
+		recordAffected(span, result, err)
+		return err
+	})
+
+	return result, err
+}
+
+// GetContext scans a single row into dest.
+func (db *DB) GetContext(ctx context.Context, dest any, query string, args ...any) error {
+	return db.run(ctx, query, args, func(ctx context.Context, span trace.Span) error {
+		err := db.executor(ctx).GetContext(ctx, dest, query, args...)
+		if err == nil {
+			span.SetAttributes(semconv.DBResponseReturnedRows(1))
+		}
+		return err
+	})
+}
+
+// SelectContext scans every returned row into the slice pointed to by dest.
+func (db *DB) SelectContext(ctx context.Context, dest any, query string, args ...any) error {
+	return db.run(ctx, query, args, func(ctx context.Context, span trace.Span) error {
+		err := db.executor(ctx).SelectContext(ctx, dest, query, args...)
+		if err == nil {
+			if v := reflect.Indirect(reflect.ValueOf(dest)); v.Kind() == reflect.Slice {
+				span.SetAttributes(semconv.DBResponseReturnedRows(v.Len()))
+			}
+		}
+		return err
+	})
+}
+
+func (db *DB) startSpan(ctx context.Context, name, text string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemNamePostgreSQL,
+			semconv.DBOperationName(operationName(text)),
+			semconv.DBQuerySummary(name),
+			semconv.DBQueryText(text),
+			semconv.DBClientConnectionPoolName(db.name),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func recordAffected(span trace.Span, result sql.Result, err error) {
+	if err != nil {
+		return
+	}
+
+	if n, err := result.RowsAffected(); err == nil {
+		span.SetAttributes(attribute.Int64("db.response.affected_rows", n))
+	}
+}
+
+// queryNamePrefix introduces the optional first-line comment naming a query,
+// as in "-- name=bookdb.QueryByID". The name labels spans, metrics and slow
+// query logs; unnamed queries are named after their operation and table. A
+// colon would be taken for a named parameter by NamedExecContext.
+const queryNamePrefix = "-- name="
+
+// parseQuery returns the name of query and its text collapsed onto a single
+// line, without the name comment.
+func parseQuery(query string) (name, text string) {
+	text = normalizeQuery(query)
+
+	if rest, ok := strings.CutPrefix(text, queryNamePrefix); ok {
+		name, text, _ = strings.Cut(rest, " ")
+		return name, text
+	}
+
+	return summarizeQuery(text), text
+}
+
+// normalizeQuery collapses the whitespace of a query written as a multi-line
+// string literal onto a single line.
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
+
+func operationName(query string) string {
+	operation, _, _ := strings.Cut(query, " ")
+	return strings.ToUpper(operation)
+}
+
+var queryTable = regexp.MustCompile(`(?i)\b(?:from|into|update)\s+([a-z_][a-z0-9_.]*)`)
+
+// summarizeQuery names a query after its operation and first table, such as
+// "SELECT books".
+func summarizeQuery(query string) string {
+	operation := operationName(query)
+	if operation == "" {
+		return "postgresql"
+	}
+
+	if match := queryTable.FindStringSubmatch(query); match != nil {
+		return operation + " " + strings.ToLower(match[1])
+	}
+	return operation
+}
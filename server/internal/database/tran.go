@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Transactor runs a unit of work atomically.
+type Transactor interface {
+	// WithinTran calls fn with a context carrying a transaction. Stores using
+	// that context take part in the transaction, which is committed if fn
+	// returns nil and rolled back otherwise.
+	WithinTran(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+const (
+	maxTranAttempts = 3
+	tranRetryDelay  = 20 * time.Millisecond
+)
+
+// PostgreSQL error codes for conflicts that succeed when the transaction is
+// simply run again.
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+)
+
+type tranKey struct{}
+
+type tranValue struct {
+	owner *DB
+	tx    *sqlx.Tx
+}
+
+// WithinTran implements Transactor. Transactions run at the repeatable read
+// isolation level, so fn sees a single snapshot and a concurrent change to a
+// row it updates aborts it with a serialization failure. Serialization
+// failures and deadlocks are retried, running fn again from the start, up to
+// three attempts in all. A call inside a transaction joins it.
+func (db *DB) WithinTran(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if v, ok := ctx.Value(tranKey{}).(*tranValue); ok && v.owner == db {
+		return fn(ctx)
+	}
+
+	ctx, span := tracer.Start(ctx, "transaction", trace.WithSpanKind(trace.SpanKindInternal))
+	defer span.End()
+
+	err = withRetry(ctx, func(attempt int) error {
+		span.SetAttributes(attribute.Int("db.transaction.attempts", attempt))
+		return db.runTran(ctx, fn)
+	})
+	endSpan(span, err)
+
+	return err
+}
+
+func (db *DB) runTran(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	tx, err := db.DB.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+
+	defer func() {
+		if pv := recover(); pv != nil {
+			tx.Rollback()
+			panic(pv)
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+				err = errors.Join(err, fmt.Errorf("rollback: %w", rbErr))
+			}
+		}
+	}()
+
+	pinToPrimary(ctx)
+
+	if err := fn(context.WithValue(ctx, tranKey{}, &tranValue{owner: db, tx: tx})); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not worth
+// retrying, or has been attempted maxTranAttempts times.
+func withRetry(ctx context.Context, fn func(attempt int) error) error {
+	var err error
+
+	for attempt := 1; attempt <= maxTranAttempts; attempt++ {
+		err = fn(attempt)
+		if err == nil || !retryable(err) || attempt == maxTranAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(time.Duration(attempt) * tranRetryDelay):
+		}
+	}
+
+	return err
+}
+
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == codeSerializationFailure || pqErr.Code == codeDeadlockDetected
+}
+
+// executor is the part of *sqlx.DB and *sqlx.Tx that stores use.
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	NamedExecContext(ctx context.Context, query string, arg any) (sql.Result, error)
+	GetContext(ctx context.Context, dest any, query string, args ...any) error
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+// executor returns the transaction in ctx if db started it, and db otherwise.
+func (db *DB) executor(ctx context.Context) executor {
+	if v, ok := ctx.Value(tranKey{}).(*tranValue); ok && v.owner == db {
+		return v.tx
+	}
+	return db.DB
+}
+
+func inTran(ctx context.Context) bool {
+	_, ok := ctx.Value(tranKey{}).(*tranValue)
+	return ok
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func Test_withRetry(t *testing.T) {
+	serialization := fmt.Errorf("update: %w", &pq.Error{Code: codeSerializationFailure})
+	deadlock := &pq.Error{Code: codeDeadlockDetected}
+	uniqueViolation := &pq.Error{Code: "23505"}
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "success", errs: []error{nil}, wantAttempts: 1},
+		{name: "serialization failure then success", errs: []error{serialization, nil}, wantAttempts: 2},
+		{name: "deadlock then success", errs: []error{deadlock, nil}, wantAttempts: 2},
+		{name: "gives up", errs: []error{serialization, deadlock, serialization, nil}, wantAttempts: 3, wantErr: serialization},
+		{name: "not retryable", errs: []error{uniqueViolation, nil}, wantAttempts: 1, wantErr: uniqueViolation},
+	}
+
+	for _, tc := range tests {
+		attempts := 0
+		err := withRetry(context.Background(), func(attempt int) error {
+			attempts = attempt
+			return tc.errs[attempt-1]
+		})
+
+		if attempts != tc.wantAttempts {
+			t.Errorf("%s: got %d attempts, want %d", tc.name, attempts, tc.wantAttempts)
+		}
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func Test_WithinTran_Joins(t *testing.T) {
+	db := unreachable(t, "primary")
+
+	// A context already carrying one of db's transactions is passed straight
+	// through, without beginning another.
+	ctx := context.WithValue(context.Background(), tranKey{}, &tranValue{owner: db})
+
+	var got context.Context
+	err := db.WithinTran(ctx, func(ctx context.Context) error {
+		got = ctx
+		return nil
+	})
+	if err != nil || got != ctx {
+		t.Errorf("got error %v, expected the outer transaction to be joined", err)
+	}
+
+	if db.Reader(ctx) != db {
+		t.Errorf("expected reads in a transaction to use the primary")
+	}
+}
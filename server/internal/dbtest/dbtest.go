@@ -50,7 +50,7 @@ func NewTest(t *testing.T, c *docker.Container) *Test {
 	var db *database.DB
 	var err error
 	for i := 0; i < 20; i++ {
-		db, err = database.New(dsn, true)
+		db, err = database.New(database.Config{DSN: dsn, Automigrate: true})
 		if err == nil {
 			break
 		}
@@ -0,0 +1,84 @@
+// Package logging provides slog support shared by the application layers.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/Babatunde50/book-crud/server/internal/requestid"
+
+	"github.com/lmittmann/tint"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Supported output formats.
+const (
+	FormatText   = "text"   // colourised, human friendly output for local use
+	FormatJSON   = "json"   // one JSON object per line
+	FormatLogfmt = "logfmt" // key=value pairs
+)
+
+// New constructs a logger writing records at or above level to w in the given
+// format, with the request and trace IDs from the context added.
+func New(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	var h slog.Handler
+
+	switch format {
+	case FormatText, "":
+		h = tint.NewHandler(w, &tint.Options{Level: level})
+	case FormatJSON:
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	case FormatLogfmt:
+		h = slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(NewContextHandler(h)), nil
+}
+
+// ParseLevel converts a level name such as "debug" or "WARN" to a slog.Level.
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// ContextHandler wraps a slog.Handler and adds the request ID and the current
+// trace and span IDs carried by the context to every record logged through
+// the *Context logging methods.
+type ContextHandler struct {
+	handler slog.Handler
+}
+
+// NewContextHandler constructs a ContextHandler around h.
+func NewContextHandler(h slog.Handler) *ContextHandler {
+	return &ContextHandler{handler: h}
+}
+
+func (h *ContextHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestid.FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{handler: h.handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{handler: h.handler.WithGroup(name)}
+}
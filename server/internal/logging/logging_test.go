@@ -0,0 +1,78 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/Babatunde50/book-crud/server/internal/logging"
+	"github.com/Babatunde50/book-crud/server/internal/requestid"
+)
+
+func TestContextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(logging.NewContextHandler(slog.NewTextHandler(&buf, nil)))
+
+	ctx := requestid.NewContext(context.Background(), "abc-123")
+
+	logger.InfoContext(ctx, "with request id")
+	if !strings.Contains(buf.String(), "request_id=abc-123") {
+		t.Errorf("expected request_id attribute, got: %s", buf.String())
+	}
+
+	buf.Reset()
+	logger.With("component", "test").InfoContext(ctx, "derived logger")
+	if !strings.Contains(buf.String(), "request_id=abc-123") || !strings.Contains(buf.String(), "component=test") {
+		t.Errorf("expected derived logger to keep request_id, got: %s", buf.String())
+	}
+
+	buf.Reset()
+	logger.InfoContext(context.Background(), "without request id")
+	if strings.Contains(buf.String(), "request_id") {
+		t.Errorf("expected no request_id attribute, got: %s", buf.String())
+	}
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	level := new(slog.LevelVar)
+	level.Set(slog.LevelWarn)
+
+	logger, err := logging.New(&buf, logging.FormatJSON, level)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger.Info("dropped")
+	if buf.Len() != 0 {
+		t.Errorf("expected info to be dropped at warn level, got: %s", buf.String())
+	}
+
+	level.Set(slog.LevelInfo)
+	logger.InfoContext(requestid.NewContext(context.Background(), "abc-123"), "kept")
+	if !strings.Contains(buf.String(), `"msg":"kept"`) || !strings.Contains(buf.String(), `"request_id":"abc-123"`) {
+		t.Errorf("expected a JSON record with the request id, got: %s", buf.String())
+	}
+
+	buf.Reset()
+	logger, _ = logging.New(&buf, logging.FormatLogfmt, level)
+	logger.Info("kept", "key", "value")
+	if !strings.Contains(buf.String(), "msg=kept key=value") {
+		t.Errorf("expected a logfmt record, got: %s", buf.String())
+	}
+
+	if _, err := logging.New(&buf, "xml", level); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := logging.ParseLevel("WARN"); err != nil || level != slog.LevelWarn {
+		t.Errorf("got %v, %v", level, err)
+	}
+	if _, err := logging.ParseLevel("loud"); err == nil {
+		t.Error("expected an unknown level to be rejected")
+	}
+}
@@ -0,0 +1,101 @@
+// Package metrics collects service metrics and exposes them in the Prometheus
+// text format.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics owns the registry and the HTTP request (RED) metrics.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+// New constructs a registry with Go runtime, process and build metrics and
+// the HTTP request metrics registered.
+func New() *Metrics {
+	m := Metrics{
+		registry: prometheus.NewRegistry(),
+
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests handled.",
+		}, []string{"method", "route", "status"}),
+
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "http_requests_in_flight",
+			Help: "Number of HTTP requests currently being handled.",
+		}, []string{"method", "route"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewBuildInfoCollector(),
+		m.requests,
+		m.duration,
+		m.inFlight,
+	)
+
+	return &m
+}
+
+// Registerer returns the registry for packages adding their own metrics.
+func (m *Metrics) Registerer() prometheus.Registerer {
+	return m.registry
+}
+
+// RegisterDB adds connection pool statistics for db, labelled with name.
+func (m *Metrics) RegisterDB(name string, db *sql.DB) error {
+	return m.registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the collected metrics.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// ObserveRequest records a handled request.
+func (m *Metrics) ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	method = methodLabel(method)
+	code := strconv.Itoa(status)
+	m.requests.WithLabelValues(method, route, code).Inc()
+	m.duration.WithLabelValues(method, route, code).Observe(elapsed.Seconds())
+}
+
+// TrackInFlight marks a request to route as started. The returned function
+// must be called once it has finished.
+func (m *Metrics) TrackInFlight(method, route string) func() {
+	g := m.inFlight.WithLabelValues(methodLabel(method), route)
+	g.Inc()
+	return g.Dec
+}
+
+// methodLabel returns method for the standard HTTP methods and "other" for
+// anything else, since clients may send any token as a method.
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "other"
+	}
+}
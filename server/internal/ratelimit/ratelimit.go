@@ -0,0 +1,133 @@
+// Package ratelimit provides token-bucket rate limiting keyed by client.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Policy describes the token bucket handed out to each client.
+type Policy struct {
+	Rate  float64 // tokens refilled per second
+	Burst int     // bucket size
+}
+
+// Enabled reports whether the policy limits anything at all.
+func (p Policy) Enabled() bool {
+	return p.Rate > 0 && p.Burst > 0
+}
+
+// Window returns the time it takes an empty bucket to refill completely.
+func (p Policy) Window() time.Duration {
+	if !p.Enabled() {
+		return 0
+	}
+	return time.Duration(float64(p.Burst) / p.Rate * float64(time.Second))
+}
+
+// Result describes the outcome of a single Allow call.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the bucket is full again
+	RetryAfter time.Duration // until the next token is available, zero when allowed
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Limiter tracks one token bucket per client key. Buckets that have not been
+// used for longer than the idle timeout are swept periodically.
+type Limiter struct {
+	policy      Policy
+	idleTimeout time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New constructs a Limiter applying the given policy to every key.
+func New(policy Policy, idleTimeout time.Duration) *Limiter {
+	return &Limiter{
+		policy:      policy,
+		idleTimeout: idleTimeout,
+		buckets:     make(map[string]*bucket),
+		now:         time.Now,
+	}
+}
+
+// Policy returns the policy applied by the limiter.
+func (l *Limiter) Policy() Policy {
+	return l.policy
+}
+
+// Allow consumes a token from the bucket identified by key.
+func (l *Limiter) Allow(key string) Result {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch {
+	case l.lastSweep.IsZero():
+		l.lastSweep = now
+	case l.idleTimeout > 0 && now.Sub(l.lastSweep) >= l.idleTimeout:
+		l.sweep(now)
+	}
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(l.policy.Rate), l.policy.Burst)}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	res := Result{Limit: l.policy.Burst}
+
+	tokens := b.limiter.TokensAt(now)
+	if tokens < 1 {
+		res.RetryAfter = l.durationFor(1 - tokens)
+		res.Reset = l.durationFor(float64(l.policy.Burst) - tokens)
+		return res
+	}
+
+	b.limiter.AllowN(now, 1)
+	tokens--
+
+	res.Allowed = true
+	res.Remaining = int(math.Floor(tokens))
+	res.Reset = l.durationFor(float64(l.policy.Burst) - tokens)
+	return res
+}
+
+// Len returns the number of buckets currently tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buckets)
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.idleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+func (l *Limiter) durationFor(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / l.policy.Rate * float64(time.Second))
+}
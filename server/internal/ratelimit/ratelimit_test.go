@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(Policy{Rate: 1, Burst: 2}, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i, wantRemaining := range []int{1, 0} {
+		res := l.Allow("1.2.3.4")
+		if !res.Allowed {
+			t.Fatalf("request %d: expected to be allowed", i)
+		}
+		if res.Remaining != wantRemaining {
+			t.Errorf("request %d: got remaining %d, want %d", i, res.Remaining, wantRemaining)
+		}
+		if res.Limit != 2 {
+			t.Errorf("request %d: got limit %d, want 2", i, res.Limit)
+		}
+	}
+
+	res := l.Allow("1.2.3.4")
+	if res.Allowed {
+		t.Fatalf("expected third request in burst to be rejected")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("got retry after %s, want 1s", res.RetryAfter)
+	}
+	if res.Reset != 2*time.Second {
+		t.Errorf("got reset %s, want 2s", res.Reset)
+	}
+
+	if !l.Allow("5.6.7.8").Allowed {
+		t.Errorf("expected a different key to have its own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if !l.Allow("1.2.3.4").Allowed {
+		t.Errorf("expected a token to be available after refill")
+	}
+}
+
+func TestLimiter_SweepsIdleBuckets(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	l := New(Policy{Rate: 1, Burst: 1}, time.Minute)
+	l.now = func() time.Time { return now }
+
+	l.Allow("a")
+	l.Allow("b")
+
+	if got := l.Len(); got != 2 {
+		t.Fatalf("got %d buckets, want 2", got)
+	}
+
+	now = now.Add(2 * time.Minute)
+	l.Allow("c")
+
+	if got := l.Len(); got != 1 {
+		t.Errorf("got %d buckets after sweep, want 1", got)
+	}
+}
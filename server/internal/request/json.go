@@ -18,8 +18,6 @@ func DecodeJSONStrict(w http.ResponseWriter, r *http.Request, dst interface{}) e
 }
 
 func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, disallowUnknownFields bool) error {
-	r.Body = http.MaxBytesReader(w, r.Body, 1_048_576)
-
 	dec := json.NewDecoder(r.Body)
 
 	if disallowUnknownFields {
@@ -28,42 +26,10 @@ func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, disallo
 
 	err := dec.Decode(dst)
 	if err != nil {
-		var (
-			syntaxError           *json.SyntaxError
-			unmarshalTypeError    *json.UnmarshalTypeError
-			invalidUnmarshalError *json.InvalidUnmarshalError
-			maxBytesError         *http.MaxBytesError
-		)
-
-		switch {
-		case errors.Is(err, io.EOF):
+		if errors.Is(err, io.EOF) {
 			return errors.New("body must not be empty")
-
-		case errors.As(err, &syntaxError):
-			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
-
-		case errors.Is(err, io.ErrUnexpectedEOF):
-			return errors.New("body contains badly-formed JSON")
-
-		case errors.As(err, &unmarshalTypeError):
-			if unmarshalTypeError.Field != "" {
-				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
-			}
-			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
-
-		case strings.HasPrefix(err.Error(), "json: unknown field "):
-			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
-			return fmt.Errorf("body contains unknown key %s", fieldName)
-
-		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
-
-		case errors.As(err, &invalidUnmarshalError):
-			panic(err)
-
-		default:
-			return err
 		}
+		return JSONError(err)
 	}
 
 	err = dec.Decode(&struct{}{})
@@ -73,3 +39,41 @@ func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}, disallo
 
 	return nil
 }
+
+// JSONError turns an error from decoding JSON into a message fit for the
+// client.
+func JSONError(err error) error {
+	var (
+		syntaxError           *json.SyntaxError
+		unmarshalTypeError    *json.UnmarshalTypeError
+		invalidUnmarshalError *json.InvalidUnmarshalError
+		maxBytesError         *http.MaxBytesError
+	)
+
+	switch {
+	case errors.As(err, &syntaxError):
+		return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("body contains badly-formed JSON")
+
+	case errors.As(err, &unmarshalTypeError):
+		if unmarshalTypeError.Field != "" {
+			return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+		}
+		return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		return fmt.Errorf("body contains unknown key %s", fieldName)
+
+	case errors.As(err, &maxBytesError):
+		return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+
+	case errors.As(err, &invalidUnmarshalError):
+		panic(err)
+
+	default:
+		return err
+	}
+}
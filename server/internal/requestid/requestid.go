@@ -0,0 +1,54 @@
+// Package requestid carries the ID correlating a request across the access
+// log, error logs and the response sent to the client.
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header used to accept and echo request IDs.
+const Header = "X-Request-ID"
+
+const maxLength = 128
+
+type ctxKey int
+
+const key ctxKey = 1
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key, id)
+}
+
+// FromContext returns the request ID stored in ctx, or an empty string.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key).(string)
+	return id
+}
+
+// New generates a new request ID.
+func New() string {
+	return uuid.NewString()
+}
+
+// Valid reports whether an ID supplied by a client is safe to reuse. Only
+// short values made of letters, digits and a few separators are accepted so
+// the ID can be echoed in headers and logs verbatim.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,47 @@
+package response
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// CaptureResponseWriter passes a response through to the wrapped writer while
+// keeping a copy of the status code and body.
+type CaptureResponseWriter struct {
+	StatusCode    int
+	Body          bytes.Buffer
+	headerWritten bool
+	wrapped       http.ResponseWriter
+}
+
+func NewCaptureResponseWriter(w http.ResponseWriter) *CaptureResponseWriter {
+	return &CaptureResponseWriter{
+		StatusCode: http.StatusOK,
+		wrapped:    w,
+	}
+}
+
+func (cw *CaptureResponseWriter) Header() http.Header {
+	return cw.wrapped.Header()
+}
+
+func (cw *CaptureResponseWriter) WriteHeader(statusCode int) {
+	cw.wrapped.WriteHeader(statusCode)
+
+	if !cw.headerWritten {
+		cw.StatusCode = statusCode
+		cw.headerWritten = true
+	}
+}
+
+func (cw *CaptureResponseWriter) Write(b []byte) (int, error) {
+	cw.headerWritten = true
+
+	n, err := cw.wrapped.Write(b)
+	cw.Body.Write(b[:n])
+	return n, err
+}
+
+func (cw *CaptureResponseWriter) Unwrap() http.ResponseWriter {
+	return cw.wrapped
+}
@@ -10,6 +10,10 @@ func JSON(w http.ResponseWriter, status int, data any) error {
 }
 
 func JSONWithHeaders(w http.ResponseWriter, status int, data any, headers http.Header) error {
+	return encode(w, status, data, headers, "application/json")
+}
+
+func encode(w http.ResponseWriter, status int, data any, headers http.Header, contentType string) error {
 	js, err := json.MarshalIndent(data, "", "\t")
 	if err != nil {
 		return err
@@ -23,7 +27,7 @@ func JSONWithHeaders(w http.ResponseWriter, status int, data any, headers http.H
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
 	w.Write(js)
 
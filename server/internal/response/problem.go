@@ -0,0 +1,35 @@
+package response
+
+import "net/http"
+
+// Problem describes an error response in the format defined by RFC 9457
+// (Problem Details for HTTP APIs).
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	RequestID     string         `json:"request_id,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+}
+
+// InvalidParam describes why a single request parameter was rejected.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// NewProblem returns a Problem for status with no type-specific semantics.
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+func ProblemJSON(w http.ResponseWriter, problem Problem, headers http.Header) error {
+	return encode(w, problem.Status, problem, headers, "application/problem+json")
+}
@@ -0,0 +1,267 @@
+// Package tlsconfig builds server TLS configuration whose certificate and
+// client CA bundle are reloaded from disk when the files are rotated.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Client certificate policies.
+const (
+	ClientAuthNone     = "none"     // client certificates are not requested
+	ClientAuthOptional = "optional" // verified when presented
+	ClientAuthRequire  = "require"  // must be presented and valid
+)
+
+// Config describes the server side of TLS.
+type Config struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string // PEM bundle used to verify client certificates
+	ClientAuth   string
+	MinVersion   string   // "1.2" or "1.3"
+	CipherSuites []string // IANA names; empty keeps the Go defaults
+}
+
+// Manager holds the current certificate and client CA pool and hands them to
+// new TLS handshakes.
+type Manager struct {
+	cfg        Config
+	minVersion uint16
+	ciphers    []uint16
+	clientAuth tls.ClientAuthType
+
+	cert      atomic.Pointer[tls.Certificate]
+	clientCAs atomic.Pointer[x509.CertPool]
+
+	mu    sync.Mutex
+	stamp map[string]fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// New validates cfg and loads the certificate and client CA bundle.
+func New(cfg Config) (*Manager, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("both a certificate and a key file are required")
+	}
+
+	minVersion, err := ParseMinVersion(cfg.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphers, err := ParseCipherSuites(cfg.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := ParseClientAuth(cfg.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	if clientAuth != tls.NoClientCert && cfg.ClientCAFile == "" {
+		return nil, errors.New("a client CA file is required to verify client certificates")
+	}
+
+	m := Manager{
+		cfg:        cfg,
+		minVersion: minVersion,
+		ciphers:    ciphers,
+		clientAuth: clientAuth,
+		stamp:      map[string]fileStamp{},
+	}
+
+	if _, err := m.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// TLSConfig returns a server configuration that always presents the most
+// recently loaded certificate and verifies clients against the most recently
+// loaded CA bundle.
+func (m *Manager) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:   m.minVersion,
+		CipherSuites: m.ciphers,
+		ClientAuth:   m.clientAuth,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.cert.Load(), nil
+		},
+	}
+
+	if m.clientAuth == tls.NoClientCert {
+		return base
+	}
+
+	// ClientCAs is read from the config, not a callback, so each handshake
+	// gets a copy carrying the current pool.
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = m.clientCAs.Load()
+		return cfg, nil
+	}
+
+	return base
+}
+
+// Reload reads the certificate, key and client CA files again if any of them
+// changed since they were last loaded, and reports whether anything was
+// replaced. On error the previously loaded files stay in use.
+func (m *Manager) Reload() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := []string{m.cfg.CertFile, m.cfg.KeyFile}
+	if m.clientAuth != tls.NoClientCert {
+		files = append(files, m.cfg.ClientCAFile)
+	}
+
+	stamps := make(map[string]fileStamp, len(files))
+	changed := false
+
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			return false, err
+		}
+
+		stamps[name] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		if stamps[name] != m.stamp[name] {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(m.cfg.CertFile, m.cfg.KeyFile)
+	if err != nil {
+		return false, fmt.Errorf("loading certificate: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if m.clientAuth != tls.NoClientCert {
+		pem, err := os.ReadFile(m.cfg.ClientCAFile)
+		if err != nil {
+			return false, fmt.Errorf("loading client CA file: %w", err)
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("client CA file %s contains no certificates", m.cfg.ClientCAFile)
+		}
+	}
+
+	m.cert.Store(&cert)
+	m.clientCAs.Store(pool)
+	m.stamp = stamps
+
+	return true, nil
+}
+
+// Watch polls the files every interval and reloads them when they change,
+// until ctx is cancelled.
+func (m *Manager) Watch(ctx context.Context, interval time.Duration, log *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := m.Reload()
+			switch {
+			case err != nil:
+				log.Error("reloading tls certificate", "error", err)
+			case reloaded:
+				log.Info("reloaded tls certificate", "cert", m.cfg.CertFile)
+			}
+		}
+	}
+}
+
+// ParseMinVersion converts "1.2" or "1.3" to a tls.Version constant. An empty
+// string means TLS 1.2.
+func ParseMinVersion(s string) (uint16, error) {
+	switch s {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported minimum TLS version %q (use 1.2 or 1.3)", s)
+	}
+}
+
+// ParseCipherSuites converts IANA cipher suite names to their IDs. Suites Go
+// considers insecure are rejected.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// ParseClientAuth converts a client certificate policy name to a
+// tls.ClientAuthType. An empty string means ClientAuthNone.
+func ParseClientAuth(s string) (tls.ClientAuthType, error) {
+	switch s {
+	case "", ClientAuthNone:
+		return tls.NoClientCert, nil
+	case ClientAuthOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientAuthRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unknown client auth policy %q (use none, optional or require)", s)
+	}
+}
+
+// Identity names the owner of a verified client certificate: its first URI
+// SAN (such as a SPIFFE ID) when it has one, and its subject common name
+// otherwise. It returns "" when the connection carries no verified
+// certificate.
+func Identity(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	leaf := state.VerifiedChains[0][0]
+	if len(leaf.URIs) > 0 {
+		return leaf.URIs[0].String()
+	}
+	return leaf.Subject.CommonName
+}
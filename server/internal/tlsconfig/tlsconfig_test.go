@@ -0,0 +1,254 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_Manager(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.crt")
+
+	ca := newCert(t, nil, "test ca", nil)
+	writePEM(t, caFile, ca)
+
+	server := newCert(t, ca, "server v1", nil)
+	writePEM(t, certFile, server)
+	writeKey(t, keyFile, server)
+
+	clientURI, _ := url.Parse("spiffe://books.example/client")
+	client := newCert(t, ca, "client", clientURI)
+
+	m, err := New(Config{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+		ClientAuth:   ClientAuthRequire,
+		MinVersion:   "1.3",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := handshake(t, m.TLSConfig(), ca, client)
+	if got := state.PeerCertificates[0].Subject.CommonName; got != "server v1" {
+		t.Errorf("got server certificate %q, want %q", got, "server v1")
+	}
+
+	reloaded, err := m.Reload()
+	if err != nil || reloaded {
+		t.Errorf("got reloaded %t, error %v for unchanged files", reloaded, err)
+	}
+
+	// Rotate the certificate, making sure the modification time moves even on
+	// filesystems with coarse timestamps.
+	rotated := newCert(t, ca, "server v2", nil)
+	writePEM(t, certFile, rotated)
+	writeKey(t, keyFile, rotated)
+	later := time.Now().Add(time.Minute)
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reloaded, err = m.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("got reloaded %t, error %v after rotation", reloaded, err)
+	}
+
+	state = handshake(t, m.TLSConfig(), ca, client)
+	if got := state.PeerCertificates[0].Subject.CommonName; got != "server v2" {
+		t.Errorf("got server certificate %q after reload, want %q", got, "server v2")
+	}
+
+	// A half-written rotation keeps the previous certificate.
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Reload(); err == nil {
+		t.Error("expected an error for a mismatched key")
+	}
+	state = handshake(t, m.TLSConfig(), ca, client)
+	if got := state.PeerCertificates[0].Subject.CommonName; got != "server v2" {
+		t.Errorf("got server certificate %q after failed reload, want %q", got, "server v2")
+	}
+}
+
+func Test_Identity(t *testing.T) {
+	t.Parallel()
+
+	uri, _ := url.Parse("spiffe://books.example/worker")
+
+	tests := []struct {
+		name  string
+		state *tls.ConnectionState
+		want  string
+	}{
+		{name: "no tls", state: nil, want: ""},
+		{name: "no verified chain", state: &tls.ConnectionState{}, want: ""},
+		{
+			name:  "uri san",
+			state: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{URIs: []*url.URL{uri}, Subject: pkix.Name{CommonName: "worker"}}}}},
+			want:  "spiffe://books.example/worker",
+		},
+		{
+			name:  "common name",
+			state: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "worker"}}}}},
+			want:  "worker",
+		},
+	}
+
+	for _, tc := range tests {
+		if got := Identity(tc.state); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func Test_Parse(t *testing.T) {
+	t.Parallel()
+
+	if _, err := ParseMinVersion("1.1"); err == nil {
+		t.Error("expected TLS 1.1 to be rejected")
+	}
+
+	ids, err := ParseCipherSuites([]string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil || len(ids) != 1 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("got %v, %v", ids, err)
+	}
+
+	if _, err := ParseCipherSuites([]string{"TLS_RSA_WITH_RC4_128_SHA"}); err == nil {
+		t.Error("expected an insecure cipher suite to be rejected")
+	}
+
+	if _, err := ParseClientAuth("sometimes"); err == nil {
+		t.Error("expected an unknown client auth policy to be rejected")
+	}
+}
+
+type testCert struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// newCert issues a certificate signed by parent, or a self-signed CA when
+// parent is nil.
+func newCert(t *testing.T, parent *testCert, cn string, uri *url.URL) *testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+	if uri != nil {
+		tmpl.URIs = []*url.URL{uri}
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCert{cert: cert, der: der, key: key}
+}
+
+func writePEM(t *testing.T, name string, c *testCert) {
+	t.Helper()
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeKey(t *testing.T, name string, c *testCert) {
+	t.Helper()
+
+	der, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(name, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects a client presenting client to a server using cfg and
+// returns the client's view of the connection.
+func handshake(t *testing.T, cfg *tls.Config, ca, client *testCert) tls.ConnectionState {
+	t.Helper()
+
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- tls.Server(serverConn, cfg).Handshake()
+	}()
+
+	conn := tls.Client(clientConn, &tls.Config{
+		RootCAs:    roots,
+		ServerName: "localhost",
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{client.der},
+			PrivateKey:  client.key,
+		}},
+	})
+	if err := conn.Handshake(); err != nil {
+		t.Fatalf("client handshake: %v", err)
+	}
+	if err := <-serverErr; err != nil {
+		t.Fatalf("server handshake: %v", err)
+	}
+
+	return conn.ConnectionState()
+}